@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/export"
+	"github.com/bcaldwell/selfops/pkg/journal"
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/bcaldwell/selfops/pkg/ynabimporter"
+	"github.com/uptrace/bun"
+)
+
+// journalExtensions are the file extensions of the plain text accounting formats
+var journalExtensions = map[string]string{
+	journal.FormatLedger:    "ledger",
+	journal.FormatHledger:   "journal",
+	journal.FormatBeancount: "beancount",
+}
+
+// runExport writes datasets to files partitioned by month, ie
+// selfops export --format parquet --dataset transactions --from 2024-01-01 --to 2025-01-01
+// The ledger, hledger and beancount formats write the ynab transactions as a single journal instead
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", export.FormatParquet, "file format: parquet, csv, jsonl, ledger, hledger or beancount")
+	datasetNames := flags.String("dataset", "transactions", "comma separated datasets: transactions, accounts, budgets or networth")
+	from := flags.String("from", "", "only export rows on or after this date (YYYY-MM-DD)")
+	to := flags.String("to", "", "only export rows before this date (YYYY-MM-DD)")
+	out := flags.String("out", "./export", "directory the files are written to")
+	fresh := flags.Bool("fresh", false, "export from a fresh ynab import instead of the database")
+	flags.Parse(args)
+
+	opts := export.Options{Format: *format, Dir: *out}
+
+	var err error
+	if *from != "" {
+		opts.From, err = time.Parse("2006-01-02", *from)
+		if err != nil {
+			return fmt.Errorf("invalid --from: %w", err)
+		}
+	}
+	if *to != "" {
+		opts.To, err = time.Parse("2006-01-02", *to)
+		if err != nil {
+			return fmt.Errorf("invalid --to: %w", err)
+		}
+	}
+
+	if _, ok := journalExtensions[*format]; ok {
+		return exportJournal(*format, *out, opts.From, opts.To)
+	}
+
+	datasets := ynabimporter.Datasets()
+	for _, name := range strings.Split(*datasetNames, ",") {
+		if _, ok := datasets[name]; !ok {
+			return fmt.Errorf("unknown dataset %s", name)
+		}
+	}
+
+	db, cleanup, err := exportSource(*fresh)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	for _, name := range strings.Split(*datasetNames, ",") {
+		files, err := export.Export(context.Background(), db, datasets[name], opts)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d %s files for %s to %s\n", len(files), *format, name, *out)
+	}
+
+	return nil
+}
+
+// exportSource opens the database the datasets are read from. A fresh export imports into a temporary SQLite file
+func exportSource(fresh bool) (*bun.DB, func(), error) {
+	if !fresh {
+		if config.CurrentYnabConfig().SQL.SQLitePath != "" {
+			db, err := sinks.OpenSQLite(config.CurrentYnabConfig().SQL.SQLitePath)
+			return db, func() { db.Close() }, err
+		}
+
+		db, err := postgresutils.CreatePostgresClient(config.CurrentYnabConfig().SQL.YnabDatabase)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error connecting to postgres DB: %s", err)
+		}
+		return db, func() { db.Close() }, nil
+	}
+
+	dir, err := os.MkdirTemp("", "selfops-export")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	// the export only needs the datasets, sinks configured for the regular import aren't written to
+	path := filepath.Join(dir, "ynab.db")
+	ynabRunner, err := ynabimporter.NewImportYNABRunnerWithOptions(ynabimporter.Options{SQLitePath: path, DefaultSinks: true})
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("Failed to create ynab importer: %s", err)
+	}
+
+	importRun := recorder.Start("export")
+	err = ynabRunner.Run(importRun)
+	recorder.Finish(importRun, err)
+	ynabRunner.Close()
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	db, err := sinks.OpenSQLite(path)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	return db, func() {
+		db.Close()
+		cleanup()
+	}, nil
+}
+
+// exportJournal fetches the ynab transactions and writes them to <out>/ynab.<ext>
+func exportJournal(format, out string, from, to time.Time) error {
+	err := os.MkdirAll(out, 0o755)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(out, "ynab."+journalExtensions[format])
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ynabRunner, err := ynabimporter.NewImportYNABRunner()
+	if err != nil {
+		return fmt.Errorf("Failed to create ynab importer: %s", err)
+	}
+	defer ynabRunner.Close()
+
+	importRun := recorder.Start("export")
+	err = ynabRunner.WriteJournal(importRun, f, format, from, to)
+	recorder.Finish(importRun, err)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %s journal to %s\n", format, path)
+	return f.Close()
+}
@@ -4,12 +4,16 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"text/tabwriter"
 	"time"
 
 	airtableImporter "github.com/bcaldwell/selfops/pkg/airtableimporter"
 	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/importruns"
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
 	"github.com/bcaldwell/selfops/pkg/ynabimporter"
 	"github.com/robfig/cron"
+	"github.com/uptrace/bun"
 )
 
 const (
@@ -17,16 +21,19 @@ const (
 )
 
 type Runner interface {
-	Run() error
+	Run(run *importruns.Run) error
 	Close() error
 }
 
 var runner Runner
+var recorder *importruns.Recorder
+var task string
 
 func main() {
 	var frequency string
 	singleRun := flag.Bool("single-run", false, "run importer once (disable cron)")
 	once := flag.Bool("once", false, "run importer once (disable cron)")
+	limit := flag.Int("limit", 20, "number of runs to list with the runs task")
 	configFile := flag.String("config", "./config.yml", "configuration file")
 	secretsFile := flag.String("secrets", "./secrets.ejson", "secrets ejson file")
 	help := flag.Bool("help", false, "show command help")
@@ -36,6 +43,7 @@ func main() {
 	if *help {
 		fmt.Println("ynab influx importer")
 		fmt.Println("selfops [options] task")
+		fmt.Println("tasks: ynab, journal, airtable, airtable-writeback, runs, export")
 		flag.PrintDefaults()
 		return
 	}
@@ -46,13 +54,32 @@ func main() {
 		os.Exit(1)
 	}
 
-	if len(os.Args) == 1 {
+	if flag.NArg() == 0 {
 		fmt.Println("No task passed in")
 		return
 	}
-	taskIndex := len(os.Args) - 1
+	task = flag.Arg(0)
 
-	switch os.Args[taskIndex] {
+	recorder = newRecorder(task)
+	defer recorder.Close()
+
+	switch task {
+	case "runs":
+		err = listRuns(*limit)
+		if err != nil {
+			fmt.Printf("Failed to list runs: %s\n", err)
+			recorder.Close()
+			os.Exit(1)
+		}
+		return
+	case "export":
+		err = runExport(flag.Args()[1:])
+		if err != nil {
+			fmt.Printf("Failed to export: %s\n", err)
+			recorder.Close()
+			os.Exit(1)
+		}
+		return
 	case "ynab":
 		runner, err = ynabimporter.NewImportYNABRunner()
 		if err != nil {
@@ -60,9 +87,23 @@ func main() {
 			return
 		}
 		frequency = config.CurrentYnabConfig().UpdateFrequency
+	case "journal":
+		runner, err = ynabimporter.NewImportJournalRunner()
+		if err != nil {
+			fmt.Printf("Failed to create journal importer: %s\n", err)
+			return
+		}
+		frequency = config.CurrentJournalConfig().UpdateFrequency
 	case "airtable":
-		runner = airtableImporter.ImportAirtableRunner{}
+		runner = airtableImporter.NewImportAirtableRunner()
 		frequency = config.CurrentAirtableConfig().UpdateFrequency
+	case "airtable-writeback":
+		runner, err = airtableImporter.NewWriteBackRunner()
+		if err != nil {
+			fmt.Printf("Failed to create airtable write back: %s\n", err)
+			return
+		}
+		frequency = config.CurrentAirtableConfig().WriteBackFrequency
 	default:
 		fmt.Println("No task passed in")
 		return
@@ -93,7 +134,9 @@ func run() {
 
 	retryCount := 5
 	for i := range 5 {
-		err := runner.Run()
+		importRun := recorder.Start(task)
+		err := runner.Run(importRun)
+		recorder.Finish(importRun, err)
 		if err == nil {
 			fmt.Println("finished successfully, sleeping")
 			break
@@ -102,3 +145,69 @@ func run() {
 		fmt.Printf(fmt.Sprintf("Error: %v, Retrying Count: %d, Max Retries: %d", err, i, retryCount))
 	}
 }
+
+// recordedTasks are the tasks building a runner, their runs are written to the import runs table. The runs task reads
+// them back, export doesn't record its runs
+var recordedTasks = map[string]bool{
+	"ynab":               true,
+	"journal":            true,
+	"airtable":           true,
+	"airtable-writeback": true,
+	"runs":               true,
+}
+
+// openRecorderDB connects to the postgres database import runs are recorded in
+var openRecorderDB = func() (*bun.DB, error) {
+	return postgresutils.CreatePostgresClient(config.CurrentYnabConfig().SQL.YnabDatabase)
+}
+
+// newRecorder connects to postgres to record import runs. Runs are still executed but not recorded if there is no database
+func newRecorder(task string) *importruns.Recorder {
+	var db *bun.DB
+
+	if recordedTasks[task] && (config.CurrentSqlSecrets().SqlHost != "" || config.CurrentSecrets().DatabaseURL != "") {
+		var err error
+		db, err = openRecorderDB()
+		if err != nil {
+			fmt.Printf("Warning: Unable to connect to postgres, import runs will not be recorded: %s\n", err)
+			db = nil
+		}
+	}
+
+	recorder, err := importruns.NewRecorder(db, config.CurrentConfig().ImportRunsTable)
+	if err != nil {
+		fmt.Printf("Warning: Import runs will not be recorded: %s\n", err)
+		db.Close()
+		recorder, _ = importruns.NewRecorder(nil, config.CurrentConfig().ImportRunsTable)
+	}
+
+	return recorder
+}
+
+func listRuns(limit int) error {
+	runs, err := recorder.List(limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTASK\tSTARTED\tDURATION\tSTATUS\tINSERTED\tUPDATED\tDELETED\tERROR")
+
+	for _, r := range runs {
+		duration := ""
+		if !r.FinishedAt.IsZero() {
+			duration = r.FinishedAt.Sub(r.StartedAt).Round(time.Second).String()
+		}
+
+		total := importruns.TableCounts{}
+		for _, counts := range r.Tables {
+			total.Inserted += counts.Inserted
+			total.Updated += counts.Updated
+			total.Deleted += counts.Deleted
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n", r.ID, r.Task, r.StartedAt.Format(time.RFC3339), duration, r.Status, total.Inserted, total.Updated, total.Deleted, r.Error)
+	}
+
+	return w.Flush()
+}
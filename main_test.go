@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+)
+
+func TestNewRecorderConnectsForRunnerTasks(t *testing.T) {
+	sqlSecrets, open := *config.CurrentSqlSecrets(), openRecorderDB
+	t.Cleanup(func() {
+		*config.CurrentSqlSecrets() = sqlSecrets
+		openRecorderDB = open
+	})
+
+	config.CurrentSqlSecrets().SqlHost = "localhost"
+	path := filepath.Join(t.TempDir(), "selfops.db")
+	opened := 0
+	openRecorderDB = func() (*bun.DB, error) {
+		opened++
+		return sinks.OpenSQLite(path)
+	}
+
+	for _, task := range []string{"ynab", "journal", "airtable", "airtable-writeback"} {
+		recorder := newRecorder(task)
+		recorder.Finish(recorder.Start(task), nil)
+
+		runs, err := recorder.List(10)
+		require.NoError(t, err, task)
+		assert.Equal(t, task, runs[0].Task)
+		require.NoError(t, recorder.Close())
+	}
+	assert.Equal(t, 4, opened)
+
+	recorder := newRecorder("export")
+	_, err := recorder.List(10)
+	assert.Error(t, err)
+	assert.Equal(t, 4, opened)
+
+	// runs are still executed when postgres is down
+	openRecorderDB = func() (*bun.DB, error) {
+		return nil, errors.New("connection refused")
+	}
+	recorder = newRecorder("airtable")
+	_, err = recorder.List(10)
+	assert.Error(t, err)
+}
@@ -0,0 +1,191 @@
+package airtableImporter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expression is arithmetic over the fields of a record. Evaluating it fails when a field is missing or isn't a number,
+// or on division by zero
+type expression interface {
+	eval(field func(name string) (float64, bool)) (float64, bool)
+}
+
+type numberExpression float64
+
+type fieldExpression string
+
+type negateExpression struct {
+	x expression
+}
+
+type binaryExpression struct {
+	op   byte
+	x, y expression
+}
+
+func (e numberExpression) eval(field func(string) (float64, bool)) (float64, bool) {
+	return float64(e), true
+}
+
+func (e fieldExpression) eval(field func(string) (float64, bool)) (float64, bool) {
+	return field(string(e))
+}
+
+func (e negateExpression) eval(field func(string) (float64, bool)) (float64, bool) {
+	x, ok := e.x.eval(field)
+	return -x, ok
+}
+
+func (e binaryExpression) eval(field func(string) (float64, bool)) (float64, bool) {
+	x, ok := e.x.eval(field)
+	if !ok {
+		return 0, false
+	}
+	y, ok := e.y.eval(field)
+	if !ok {
+		return 0, false
+	}
+
+	switch e.op {
+	case '+':
+		return x + y, true
+	case '-':
+		return x - y, true
+	case '*':
+		return x * y, true
+	case '/':
+		if y == 0 {
+			return 0, false
+		}
+		return x / y, true
+	}
+	return 0, false
+}
+
+// expressionParser parses + - * / with parentheses, numbers and fields written as {Field Name} or a single word
+type expressionParser struct {
+	input string
+	pos   int
+}
+
+func parseExpression(input string) (expression, error) {
+	p := &expressionParser{input: input}
+	e, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at %d in %s", p.input[p.pos], p.pos, input)
+	}
+	return e, nil
+}
+
+func (p *expressionParser) sum() (expression, error) {
+	x, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return x, nil
+		}
+		p.pos++
+
+		y, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpression{op: op, x: x, y: y}
+	}
+}
+
+func (p *expressionParser) product() (expression, error) {
+	x, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return x, nil
+		}
+		p.pos++
+
+		y, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpression{op: op, x: x, y: y}
+	}
+}
+
+func (p *expressionParser) operand() (expression, error) {
+	switch c := p.peek(); {
+	case c == '-':
+		p.pos++
+		x, err := p.operand()
+		return negateExpression{x}, err
+	case c == '(':
+		p.pos++
+		x, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) in %s", p.input)
+		}
+		p.pos++
+		return x, nil
+	case c == '{':
+		end := strings.IndexByte(p.input[p.pos:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("missing } in %s", p.input)
+		}
+		name := p.input[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		return fieldExpression(name), nil
+	case c == '.' || unicode.IsDigit(rune(c)):
+		start := p.pos
+		for p.pos < len(p.input) && (p.input[p.pos] == '.' || unicode.IsDigit(rune(p.input[p.pos]))) {
+			p.pos++
+		}
+		n, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s in %s", p.input[start:p.pos], p.input)
+		}
+		return numberExpression(n), nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.input) && (p.input[p.pos] == '_' || unicode.IsLetter(rune(p.input[p.pos])) || unicode.IsDigit(rune(p.input[p.pos]))) {
+			p.pos++
+		}
+		return fieldExpression(p.input[start:p.pos]), nil
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of %s", p.input)
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d in %s", c, p.pos, p.input)
+	}
+}
+
+// peek is the next character that isn't a space, 0 at the end
+func (p *expressionParser) peek() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
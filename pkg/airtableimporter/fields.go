@@ -0,0 +1,148 @@
+package airtableImporter
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/crufter/airtable-go"
+)
+
+const defaultDisplayField = "Name"
+
+// linkedNames are the display names of linked records by field and record id
+type linkedNames map[string]map[string]string
+
+// linkedCache keeps the names of the linked tables listed during a run by base, table and display field, so tables
+// linked from several fields or synced tables are listed once
+type linkedCache map[string]map[string]string
+
+// listLinkedNames lists the display field of every record of the linked tables of a base
+func listLinkedNames(client *airtable.Client, base config.AirtableBaseConfig, cache linkedCache) (linkedNames, error) {
+	names := linkedNames{}
+	for _, linked := range base.Fields.LinkedRecords {
+		displayField := linked.DisplayField
+		if displayField == "" {
+			displayField = defaultDisplayField
+		}
+
+		cacheKey := base.BaseID + "/" + linked.Table + "/" + displayField
+		if cached, ok := cache[cacheKey]; ok {
+			names[linked.Field] = cached
+			continue
+		}
+
+		records := []AirtableRecords{}
+		err := client.ListRecords(linked.Table, &records, airtable.ListParameters{Fields: []string{displayField}})
+		if err != nil {
+			return nil, fmt.Errorf("Error getting linked airtable records of %s: %s", linked.Table, err.Error())
+		}
+
+		names[linked.Field] = make(map[string]string, len(records))
+		for _, record := range records {
+			if name, ok := record.Fields[displayField]; ok {
+				names[linked.Field][record.ID] = strings.Replace(fmt.Sprintf("%v", name), "\n", ":", -1)
+			}
+		}
+		cache[cacheKey] = names[linked.Field]
+	}
+	return names, nil
+}
+
+// listField writes a list field. Attachments are counted, numbers from rollups and lookups are flattened, linked
+// records are resolved to their names and other strings like multi-selects are tags or bool fields
+func listField(base config.AirtableBaseConfig, key string, values []interface{}, linked linkedNames, tags map[string]string, fields map[string]interface{}) {
+	strs := make([]string, 0, len(values))
+	numbers := make([]float64, 0, len(values))
+	attachments := 0
+	for _, value := range values {
+		switch value := value.(type) {
+		case string:
+			strs = append(strs, value)
+		case float64:
+			numbers = append(numbers, value)
+		case map[string]interface{}:
+			if _, ok := value["url"]; ok {
+				attachments++
+			}
+		}
+	}
+
+	switch {
+	case len(values) == 0:
+	case attachments == len(values):
+		fields[key] = float64(attachments)
+	case len(numbers) == len(values):
+		aggregate, err := flatten(base.Fields.RollupAggregates[key], numbers)
+		if err != nil {
+			slog.Error("Error flattening rollup", "field", key, "error", err)
+			return
+		}
+		fields[key] = aggregate
+	case len(strs) == len(values):
+		if names, ok := linked[key]; ok {
+			for i, id := range strs {
+				if name, ok := names[id]; ok {
+					strs[i] = name
+				}
+			}
+			tags[key] = strings.Join(strs, ",")
+		} else if stringInSlice(key, base.Fields.MultiSelectBooleans) {
+			for _, option := range strs {
+				fields[key+" "+option] = boolValue(base, true)
+			}
+		} else if stringInSlice(key, base.Fields.MultiSelectTags) {
+			for _, option := range strs {
+				tags[key+":"+option] = "true"
+			}
+		} else {
+			tags[key] = strings.Replace(strings.Join(strs, ","), "\n", ":", -1)
+		}
+	default:
+		types := []string{}
+		for _, value := range values {
+			if t := fmt.Sprintf("%T", value); !stringInSlice(t, types) {
+				types = append(types, t)
+			}
+		}
+		slog.Warn("ignoring airtable list field with values of mixed or unsupported types", "field", key, "types", types)
+	}
+}
+
+// flatten aggregates the numbers of a rollup or lookup
+func flatten(aggregate string, numbers []float64) (float64, error) {
+	switch aggregate {
+	case "", "sum":
+		sum := 0.0
+		for _, n := range numbers {
+			sum += n
+		}
+		return sum, nil
+	case "avg":
+		sum, _ := flatten("sum", numbers)
+		return sum / float64(len(numbers)), nil
+	case "min", "max":
+		sorted := append([]float64{}, numbers...)
+		sort.Float64s(sorted)
+		if aggregate == "min" {
+			return sorted[0], nil
+		}
+		return sorted[len(sorted)-1], nil
+	case "first":
+		return numbers[0], nil
+	}
+	return math.NaN(), fmt.Errorf("unknown aggregate %s, use sum, avg, min, max or first", aggregate)
+}
+
+func boolValue(base config.AirtableBaseConfig, value bool) interface{} {
+	if !base.Fields.ConvertBoolToInt {
+		return value
+	}
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package airtableImporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/crufter/airtable-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordPointLists(t *testing.T) {
+	base := config.AirtableBaseConfig{Fields: config.AirtableFieldsConfig{
+		MultiSelectTags:     []string{"Mood"},
+		MultiSelectBooleans: []string{"Symptoms"},
+		LinkedRecords:       []config.AirtableLinkedRecords{{Field: "Workouts", Table: "Workouts"}},
+		RollupAggregates:    map[string]string{"Heart Rates": "max"},
+		ConvertBoolToInt:    true,
+	}}
+	linked := linkedNames{"Workouts": {"recRun": "Run", "recYoga": "Yoga"}}
+
+	point, err := recordPoint(base, AirtableRecords{ID: "rec1", Fields: map[string]interface{}{
+		"Date":        "2024-03-01",
+		"Mood":        []interface{}{"calm", "tired"},
+		"Symptoms":    []interface{}{"headache"},
+		"Workouts":    []interface{}{"recRun", "recYoga", "recGone"},
+		"Photos":      []interface{}{map[string]interface{}{"url": "https://a"}, map[string]interface{}{"url": "https://b"}},
+		"Calories":    []interface{}{float64(200), float64(150)},
+		"Heart Rates": []interface{}{float64(120), float64(165), float64(140)},
+		"Places":      []interface{}{"home", "gym"},
+	}}, linked)
+	require.NoError(t, err)
+
+	assert.Equal(t, "true", point.Tags["Mood:calm"])
+	assert.Equal(t, "true", point.Tags["Mood:tired"])
+	assert.Equal(t, 1, point.Fields["Symptoms headache"])
+	assert.Equal(t, "Run,Yoga,recGone", point.Tags["Workouts"])
+	assert.Equal(t, 2.0, point.Fields["Photos"])
+	assert.Equal(t, 350.0, point.Fields["Calories"])
+	assert.Equal(t, 165.0, point.Fields["Heart Rates"])
+	assert.Equal(t, "home,gym", point.Tags["Places"])
+}
+
+func TestListLinkedNames(t *testing.T) {
+	api := &testAirtable{records: testRecords(120)}
+	for _, record := range api.records {
+		record.Fields["Name"] = "workout " + record.ID[len(record.ID)-3:]
+	}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	client, err := airtable.New("keyAAAAAAAAAAAAAA", "appAAAAAAAAAAAAAA")
+	require.NoError(t, err)
+	client.HTTPClient = &http.Client{Transport: rewriteTransport{server}}
+
+	base := config.AirtableBaseConfig{Fields: config.AirtableFieldsConfig{
+		LinkedRecords: []config.AirtableLinkedRecords{{Field: "Workouts", Table: "Workouts"}},
+	}}
+	cache := linkedCache{}
+	names, err := listLinkedNames(client, base, cache)
+	require.NoError(t, err)
+	assert.Len(t, names["Workouts"], 120)
+	assert.Equal(t, "workout 119", names["Workouts"][api.records[119].ID])
+	assert.Contains(t, api.requests[0], "fields%5B%5D=Name")
+
+	// the table is listed once per run
+	requests := len(api.requests)
+	names, err = listLinkedNames(client, base, cache)
+	require.NoError(t, err)
+	assert.Len(t, names["Workouts"], 120)
+	assert.Len(t, api.requests, requests)
+}
+
+func TestFlatten(t *testing.T) {
+	numbers := []float64{4, 1, 7}
+	for aggregate, expected := range map[string]float64{"": 12, "avg": 4, "min": 1, "max": 7, "first": 4} {
+		value, err := flatten(aggregate, numbers)
+		require.NoError(t, err)
+		assert.Equal(t, expected, value, aggregate)
+	}
+
+	_, err := flatten("median", numbers)
+	assert.Error(t, err)
+}
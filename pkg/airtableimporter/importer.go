@@ -1,26 +1,56 @@
 package airtableImporter
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
 	"time"
 
 	"github.com/bcaldwell/selfops/pkg/config"
-	"github.com/bcaldwell/selfops/pkg/influxHelper"
+	"github.com/bcaldwell/selfops/pkg/importruns"
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/bcaldwell/selfops/pkg/sinks"
 	"github.com/crufter/airtable-go"
-
-	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/uptrace/bun"
 )
 
-type ImportAirtableRunner struct{}
+// ImportAirtableRunner writes the records of every base to the airtable sinks. With postgres the last sync of every
+// table is saved and only the records modified since are written
+type ImportAirtableRunner struct {
+	// optional, every run rewrites the tables without it
+	db *bun.DB
+}
+
+// NewImportAirtableRunner connects to postgres to save the last sync of every table. Tables are still imported but
+// rewritten every run if there is no database
+func NewImportAirtableRunner() *ImportAirtableRunner {
+	runner := &ImportAirtableRunner{}
+	if config.CurrentSqlSecrets().SqlHost == "" && config.CurrentSecrets().DatabaseURL == "" {
+		return runner
+	}
+
+	db, err := postgresutils.CreatePostgresClient(config.CurrentYnabConfig().SQL.YnabDatabase)
+	if err != nil {
+		fmt.Printf("Warning: Unable to connect to postgres, airtable tables will be rewritten every run: %s\n", err)
+		return runner
+	}
 
-func (ImportAirtableRunner) Run() error {
-	return ImportAirtable()
+	runner.db = db
+	return runner
 }
 
-func (ImportAirtableRunner) Close() error {
-	return nil
+func (r *ImportAirtableRunner) Run(run *importruns.Run) error {
+	return r.importAirtable(run)
+}
+
+func (r *ImportAirtableRunner) Close() error {
+	if r.db == nil {
+		return nil
+	}
+	return r.db.Close()
 }
 
 type AirtableRecords struct {
@@ -28,109 +58,163 @@ type AirtableRecords struct {
 	Fields map[string]interface{}
 }
 
-func ImportAirtable() error {
-	influxDB, err := influxHelper.CreateInfluxClient()
-	if err != nil {
-		return fmt.Errorf("Error creating InfluxDB Client: %s", err.Error())
+// AirtableDataset is the dataset the records of a base are written to in the sinks. It is rebuilt when a table is synced
+// for the first time
+func AirtableDataset(base config.AirtableBaseConfig) sinks.Dataset {
+	return sinks.Dataset{
+		Name:       "airtable",
+		Table:      base.InfluxMeasurement,
+		Model:      (*sinks.Point)(nil),
+		KeyColumn:  "key",
+		TimeColumn: "time",
+		Precision:  precision(base),
 	}
+}
+
+func (r *ImportAirtableRunner) importAirtable(run *importruns.Run) error {
+	ctx := context.Background()
 
-	err = influxHelper.DropDatabase(influxDB, config.CurrentAirtableConfig().AirtableDatabase)
+	sink, err := sinks.Open("airtable", sinks.TypeInflux, sinks.Options{
+		Database: config.CurrentAirtableConfig().AirtableDatabase,
+	})
 	if err != nil {
-		return fmt.Errorf("Error dropping DB: %s", err.Error())
+		return err
 	}
-	err = influxHelper.CreateDatabase(influxDB, config.CurrentAirtableConfig().AirtableDatabase)
-	if err != nil {
-		return fmt.Errorf("Error creating DB: %s", err.Error())
+	defer sink.Close()
+
+	if r.db != nil {
+		_, err = r.db.NewCreateTable().Model((*SQLAirtableSync)(nil)).ModelTableExpr(syncTable()).IfNotExists().Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create %s table: %w", syncTable(), err)
+		}
+
+		// tables created before the config hash was saved
+		_, err = r.db.ExecContext(ctx, "ALTER TABLE ? ADD COLUMN IF NOT EXISTS config_hash varchar", bun.Ident(syncTable()))
+		if err != nil {
+			return fmt.Errorf("failed to migrate %s table: %w", syncTable(), err)
+		}
 	}
 
+	cache := linkedCache{}
 	for _, base := range config.CurrentAirtableConfig().AirtableBases {
-
 		client, err := airtable.New(config.CurrentAirtableSecrets().AirtableAPIKey, base.BaseID)
 		if err != nil {
-			panic(err)
+			return err
 		}
 
-		bp, err := influx.NewBatchPoints(influx.BatchPointsConfig{
-			Database:  config.CurrentAirtableConfig().AirtableDatabase,
-			Precision: "h",
-		})
+		last, err := r.lastSync(ctx, base)
+		if err != nil {
+			return err
+		}
 
-		airtableRecords := []AirtableRecords{}
-		if err := client.ListRecords(base.AirtableTableName, &airtableRecords); err != nil {
-			return fmt.Errorf("Error getting airtable records: %s", err.Error())
+		// the sync starts before listing so records modified while listing are in the next sync
+		sync, err := syncRecords(ctx, run, client, cache, sink, base, last, time.Now())
+		if err != nil {
+			return err
 		}
 
-		for _, record := range airtableRecords {
-			tags := map[string]string{}
-			// for name, field := range record.Fields {
-			// 	tags["tag"+name] = strings.Replace(fmt.Sprintf("%v", field), "\n", ":", -1)
-			// }
+		err = r.saveSync(ctx, sync)
+		if err != nil {
+			return err
+		}
 
-			date, err := parseAsDate(record.Fields["Date"])
-			if err != nil {
-				return fmt.Errorf("Error parsing date: %s", record.Fields["Date"])
-			}
-			fields := make(map[string]interface{})
-			for key, field := range record.Fields {
-				if stringInSlice(key, base.Fields.Blacklist) {
-					continue
-				}
-				switch field.(type) {
-				case int32, int64, float32, float64:
-					fields[key] = field
-				case bool:
-					if base.Fields.ConvertBoolToInt {
-						fields[key] = 0
-						if field.(bool) {
-							fields[key] = 1
-						}
-					} else {
-						fields[key] = field
-					}
-
-				case string:
-					if stringInSlice(key, base.Fields.ConvertToTimeFromMidnightList) {
-						valueDate, err := parseAsDateTime(field)
-						if err != nil {
-							slog.Error("Error parsing date", "field", field, "error", err)
-							continue
-						}
-						offset := 0.0
-						if record.Fields["Timezone Offset"] != nil {
-							offset = record.Fields["Timezone Offset"].(float64)
-						}
-						duration := valueDate.Sub(date)
-						fields[key] = duration.Minutes() + (offset * 60)
-					} else {
-						tags[key] = strings.Replace(fmt.Sprintf("%v", field), "\n", ":", -1)
-					}
-				default:
-					fmt.Printf("Ignoring %v \n", key)
-				}
-				if value, ok := fields[key]; ok {
-					tags["tag"+key] = fmt.Sprintf("%v", value)
-				}
-			}
+		fmt.Printf("Synced %d records from airtable base %s:%s\n", len(sync.RecordIDs), base.BaseID, base.AirtableTableName)
+	}
 
-			pt, err := influx.NewPoint(base.InfluxMeasurement, tags, fields, date)
-			if err != nil {
-				return fmt.Errorf("Error adding new point: %s", err.Error())
-			}
-			bp.AddPoint(pt)
+	return nil
+}
 
-		}
+// lastSync is the last sync of a table, nil without one or without a database
+func (r *ImportAirtableRunner) lastSync(ctx context.Context, base config.AirtableBaseConfig) (*SQLAirtableSync, error) {
+	if r.db == nil {
+		return nil, nil
+	}
 
-		err = influxDB.Write(bp)
-		if err != nil {
-			return fmt.Errorf("Error writing to influx: %s", err.Error())
-		}
+	last := &SQLAirtableSync{}
+	err := r.db.NewSelect().Model(last).ModelTableExpr(syncTable()).Where("key = ?", syncKey(base)).Limit(1).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get last sync of %s: %w", syncKey(base), err)
+	}
+
+	return last, nil
+}
 
-		fmt.Printf("Wrote %d rows to influx from airtable base %s:%s\n", len(airtableRecords), base.BaseID, base.AirtableTableName)
+func (r *ImportAirtableRunner) saveSync(ctx context.Context, sync *SQLAirtableSync) error {
+	if r.db == nil {
+		return nil
+	}
+
+	_, err := r.db.NewInsert().Model(sync).ModelTableExpr(syncTable()).
+		On("CONFLICT (key) DO UPDATE").
+		Set("last_sync = EXCLUDED.last_sync").
+		Set("record_ids = EXCLUDED.record_ids").
+		Set("config_hash = EXCLUDED.config_hash").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to save sync of %s: %w", sync.Key, err)
 	}
 
 	return nil
 }
 
+// recordPoint converts a record to a point keyed by the record id. Numbers and bools are fields, strings are tags and
+// lists depend on what they hold, see listField
+func recordPoint(base config.AirtableBaseConfig, record AirtableRecords, linked linkedNames) (sinks.Point, error) {
+	tags := map[string]string{}
+	// for name, field := range record.Fields {
+	// 	tags["tag"+name] = strings.Replace(fmt.Sprintf("%v", field), "\n", ":", -1)
+	// }
+
+	date, midnight, err := recordTime(base, record)
+	if err != nil {
+		return sinks.Point{}, err
+	}
+	fields := make(map[string]interface{})
+	for key, field := range record.Fields {
+		if stringInSlice(key, base.Fields.Blacklist) {
+			continue
+		}
+		switch field.(type) {
+		case int32, int64, float32, float64:
+			fields[key] = field
+		case bool:
+			fields[key] = boolValue(base, field.(bool))
+		case []interface{}:
+			listField(base, key, field.([]interface{}), linked, tags, fields)
+
+		case string:
+			if stringInSlice(key, base.Fields.ConvertToTimeFromMidnightList) {
+				valueDate, err := parseAsDateTime(field)
+				if err != nil {
+					slog.Error("Error parsing date", "field", field, "error", err)
+					continue
+				}
+				fields[key] = valueDate.Sub(midnight).Minutes()
+			} else {
+				tags[key] = strings.Replace(fmt.Sprintf("%v", field), "\n", ":", -1)
+			}
+		default:
+			slog.Warn("ignoring airtable field of unsupported type", "field", key, "type", fmt.Sprintf("%T", field))
+		}
+	}
+
+	return sinks.Point{
+		Key:    record.ID,
+		Time:   date,
+		Tags:   tags,
+		Fields: fields,
+	}, nil
+}
+
+// tagFields copies every field to a tag prefixed with tag, once the transforms of the base have run
+func tagFields(point *sinks.Point) {
+	for key, value := range point.Fields {
+		point.Tags["tag"+key] = fmt.Sprintf("%v", value)
+	}
+}
+
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
@@ -140,10 +224,6 @@ func stringInSlice(a string, list []string) bool {
 	return false
 }
 
-func parseAsDate(a interface{}) (time.Time, error) {
-	return time.Parse("2006-01-02", a.(string))
-}
-
 func parseAsDateTime(a interface{}) (time.Time, error) {
 	return time.Parse(time.RFC3339, a.(string))
 }
@@ -0,0 +1,215 @@
+package airtableImporter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/importruns"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/crufter/airtable-go"
+	"github.com/uptrace/bun"
+)
+
+const (
+	defaultSyncTable = "airtable_sync"
+	// default field with the timestamp of a record
+	dateField = "Date"
+	// modified times are in seconds and records can change while they are listed, overlapping syncs write them again
+	syncOverlap = time.Minute
+)
+
+// SQLAirtableSync is the last sync of a table and the records it had, records missing from the next sync were removed.
+// ConfigHash is the hash of the base config the records were written with
+type SQLAirtableSync struct {
+	bun.BaseModel `bun:"table:airtable_sync"`
+	Key           string `bun:",pk"`
+	LastSync      time.Time
+	RecordIDs     []string `bun:",array"`
+	ConfigHash    string
+}
+
+func syncTable() string {
+	if config.CurrentAirtableConfig().SyncTable != "" {
+		return config.CurrentAirtableConfig().SyncTable
+	}
+	return defaultSyncTable
+}
+
+func syncKey(base config.AirtableBaseConfig) string {
+	return base.BaseID + "/" + base.AirtableTableName
+}
+
+// configHash identifies the config of a base, the fields, transforms and timestamp settings change every point so the
+// measurement is rebuilt when it changes
+func configHash(base config.AirtableBaseConfig) string {
+	raw, err := json.Marshal(base)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// syncRecords writes the records of a table modified since the last sync and deletes the ones removed since. Without a
+// last sync or when the config of the base changed since, the measurement is rebuilt from every record. Returns the
+// sync to save
+func syncRecords(ctx context.Context, run *importruns.Run, client *airtable.Client, cache linkedCache, sink sinks.Sink, base config.AirtableBaseConfig, last *SQLAirtableSync, now time.Time) (*SQLAirtableSync, error) {
+	err := validateBase(base)
+	if err != nil {
+		return nil, fmt.Errorf("airtable base %s: %w", syncKey(base), err)
+	}
+
+	dataset := AirtableDataset(base)
+	params := airtable.ListParameters{View: base.View}
+	hash := configHash(base)
+
+	if last == nil || last.LastSync.IsZero() || last.ConfigHash != hash {
+		dataset.Replace = true
+		err = sink.Migrate(ctx, dataset)
+		if err != nil {
+			return nil, err
+		}
+
+		records := []AirtableRecords{}
+		if err := client.ListRecords(base.AirtableTableName, &records, params); err != nil {
+			return nil, fmt.Errorf("Error getting airtable records: %s", err.Error())
+		}
+
+		err = writeRecords(ctx, client, cache, sink, dataset, base, records)
+		if err != nil {
+			return nil, err
+		}
+
+		run.AddTableCounts(base.InfluxMeasurement, len(records), 0, 0)
+		return &SQLAirtableSync{Key: syncKey(base), LastSync: now, RecordIDs: recordIDs(records), ConfigHash: hash}, nil
+	}
+
+	err = sink.Migrate(ctx, dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	// listing only the ids of every record is cheap compared to the records
+	idParams := params
+	idParams.Fields = []string{timestampField(base)}
+	all := []AirtableRecords{}
+	if err := client.ListRecords(base.AirtableTableName, &all, idParams); err != nil {
+		return nil, fmt.Errorf("Error getting airtable record ids: %s", err.Error())
+	}
+
+	modifiedParams := params
+	modifiedParams.FilterByFormula = modifiedSince(base, last.LastSync.Add(-syncOverlap))
+	modified := []AirtableRecords{}
+	if err := client.ListRecords(base.AirtableTableName, &modified, modifiedParams); err != nil {
+		return nil, fmt.Errorf("Error getting modified airtable records: %s", err.Error())
+	}
+
+	ids := recordIDs(all)
+	current := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		current[id] = true
+	}
+
+	previous := make(map[string]bool, len(last.RecordIDs))
+	removed := []string{}
+	for _, id := range last.RecordIDs {
+		previous[id] = true
+		if !current[id] {
+			removed = append(removed, id)
+		}
+	}
+
+	// points are series of their tags and time in influx, a modified record with another time or tags would be written
+	// next to its old point instead of replacing it
+	written := []string{}
+	for _, record := range modified {
+		if previous[record.ID] {
+			written = append(written, record.ID)
+		}
+	}
+	if len(written) > 0 {
+		err = sink.Delete(ctx, dataset, written)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = writeRecords(ctx, client, cache, sink, dataset, base, modified)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(removed) > 0 {
+		err = sink.Delete(ctx, dataset, removed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	run.AddTableCounts(base.InfluxMeasurement, len(modified)-len(written), len(written), len(removed))
+	return &SQLAirtableSync{Key: syncKey(base), LastSync: now, RecordIDs: ids, ConfigHash: hash}, nil
+}
+
+// writeRecords writes records as points after the transforms of the base, linked records are resolved to their names
+func writeRecords(ctx context.Context, client *airtable.Client, cache linkedCache, sink sinks.Sink, dataset sinks.Dataset, base config.AirtableBaseConfig, records []AirtableRecords) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	transforms, err := compileTransforms(base)
+	if err != nil {
+		return err
+	}
+
+	linked, err := listLinkedNames(client, base, cache)
+	if err != nil {
+		return err
+	}
+
+	points := make([]sinks.Point, 0, len(records))
+	for _, record := range records {
+		point, err := recordPoint(base, record, linked)
+		if errors.Is(err, errInvalidDate) && base.MissingDate != "" && base.MissingDate != MissingDateFail {
+			if base.MissingDate == MissingDateLog {
+				slog.Warn("skipping airtable record", "base", syncKey(base), "record", record.ID, "error", err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("record %s: %w", record.ID, err)
+		}
+		transforms.apply(&point)
+		tagFields(&point)
+		points = append(points, point)
+	}
+
+	if len(points) == 0 {
+		return nil
+	}
+	return sink.Upsert(ctx, dataset, &points)
+}
+
+// modifiedSince is a formula matching the records created or modified after since
+func modifiedSince(base config.AirtableBaseConfig, since time.Time) string {
+	after := fmt.Sprintf("DATETIME_PARSE('%s')", since.UTC().Format(time.RFC3339))
+	if base.LastModifiedField != "" {
+		return fmt.Sprintf("IS_AFTER({%s}, %s)", base.LastModifiedField, after)
+	}
+	return fmt.Sprintf("OR(IS_AFTER(LAST_MODIFIED_TIME(), %s), IS_AFTER(CREATED_TIME(), %s))", after, after)
+}
+
+func recordIDs(records []AirtableRecords) []string {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+	return ids
+}
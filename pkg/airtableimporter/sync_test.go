@@ -0,0 +1,242 @@
+package airtableImporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/importruns"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/crufter/airtable-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySink keeps the points of a dataset by key, tags and time like the series of influx, so a point written again
+// with another time is kept next to the old one until it is deleted
+type memorySink struct {
+	points   map[string]sinks.Point
+	replaced bool
+}
+
+func seriesKey(p sinks.Point) string {
+	tags := make([]string, 0, len(p.Tags))
+	for k, v := range p.Tags {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	return p.Key + "," + strings.Join(tags, ",") + " " + p.Time.Format(time.RFC3339Nano)
+}
+
+// find returns the points of a key
+func (s *memorySink) find(key string) []sinks.Point {
+	points := []sinks.Point{}
+	for _, p := range s.points {
+		if p.Key == key {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func (s *memorySink) Migrate(ctx context.Context, dataset sinks.Dataset) error {
+	if dataset.Replace {
+		s.points = map[string]sinks.Point{}
+		s.replaced = true
+	}
+	return nil
+}
+
+func (s *memorySink) Upsert(ctx context.Context, dataset sinks.Dataset, rows interface{}) error {
+	for _, p := range *rows.(*[]sinks.Point) {
+		s.points[seriesKey(p)] = p
+	}
+	return nil
+}
+
+func (s *memorySink) Delete(ctx context.Context, dataset sinks.Dataset, keys []string) error {
+	for _, key := range keys {
+		for series, p := range s.points {
+			if p.Key == key {
+				delete(s.points, series)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+// testAirtable serves records 100 per page like the api. Records listed with a formula are the modified ones
+type testAirtable struct {
+	records  []AirtableRecords
+	modified map[string]bool
+	requests []string
+}
+
+func (a *testAirtable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.requests = append(a.requests, r.Method+" "+r.URL.RawQuery)
+	query := r.URL.Query()
+
+	if r.Method == http.MethodPost || r.Method == http.MethodPatch {
+		a.write(w, r)
+		return
+	}
+
+	records := []AirtableRecords{}
+	for _, record := range a.records {
+		if query.Get("filterByFormula") != "" && !a.modified[record.ID] {
+			continue
+		}
+		if fields := query["fields[]"]; len(fields) > 0 {
+			listed := AirtableRecords{ID: record.ID, Fields: map[string]interface{}{}}
+			for _, field := range fields {
+				if value, ok := record.Fields[field]; ok {
+					listed.Fields[field] = value
+				}
+			}
+			record = listed
+		}
+		records = append(records, record)
+	}
+
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	end := offset + 100
+	response := map[string]interface{}{}
+	if end < len(records) {
+		response["offset"] = strconv.Itoa(end)
+	} else {
+		end = len(records)
+	}
+	response["records"] = records[offset:end]
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// write creates a record or updates the fields of the record at the end of the path
+func (a *testAirtable) write(w http.ResponseWriter, r *http.Request) {
+	body := airtableRecord{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	if r.Method == http.MethodPost {
+		body.ID = fmt.Sprintf("rec%014d", len(a.records)+1000)
+		a.records = append(a.records, AirtableRecords{ID: body.ID, Fields: body.Fields})
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	for _, record := range a.records {
+		if record.ID == id {
+			for field, value := range body.Fields {
+				record.Fields[field] = value
+			}
+			json.NewEncoder(w).Encode(airtableRecord{ID: id, Fields: record.Fields})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// rewriteTransport sends the requests of the airtable client to a test server
+type rewriteTransport struct {
+	server *httptest.Server
+}
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = "http"
+	req.URL.Host = strings.TrimPrefix(t.server.URL, "http://")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func testRecords(n int) []AirtableRecords {
+	records := make([]AirtableRecords, n)
+	for i := range records {
+		records[i] = AirtableRecords{
+			ID:     fmt.Sprintf("rec%014d", i),
+			Fields: map[string]interface{}{dateField: "2024-01-02", "Steps": float64(i)},
+		}
+	}
+	return records
+}
+
+func TestSyncRecords(t *testing.T) {
+	api := &testAirtable{records: testRecords(250)}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	client, err := airtable.New("keyAAAAAAAAAAAAAA", "appAAAAAAAAAAAAAA")
+	require.NoError(t, err)
+	client.HTTPClient = &http.Client{Transport: rewriteTransport{server}}
+
+	recorder, err := importruns.NewRecorder(nil, "")
+	require.NoError(t, err)
+	run := recorder.Start("airtable")
+
+	base := config.AirtableBaseConfig{BaseID: "appAAAAAAAAAAAAAA", AirtableTableName: "Health", InfluxMeasurement: "health"}
+	sink := &memorySink{}
+	firstSync := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+
+	// every page of a table over 100 records is written
+	sync, err := syncRecords(context.Background(), run, client, linkedCache{}, sink, base, nil, firstSync)
+	require.NoError(t, err)
+	assert.True(t, sink.replaced)
+	assert.Len(t, sink.points, 250)
+	assert.Len(t, sync.RecordIDs, 250)
+	assert.Len(t, api.requests, 3)
+	assert.Equal(t, firstSync, sync.LastSync)
+
+	// the next sync writes the modified records and deletes the removed ones
+	api.records = append(api.records[2:], testRecords(251)[250])
+	api.records[0].Fields["Steps"] = float64(1000)
+	api.records[0].Fields[dateField] = "2024-01-05"
+	api.modified = map[string]bool{api.records[0].ID: true, api.records[len(api.records)-1].ID: true}
+	api.requests = nil
+	sink.replaced = false
+
+	sync, err = syncRecords(context.Background(), run, client, linkedCache{}, sink, base, sync, firstSync.Add(time.Hour))
+	require.NoError(t, err)
+	assert.False(t, sink.replaced)
+	assert.Len(t, sink.points, 249)
+	assert.Empty(t, sink.find(testRecords(1)[0].ID))
+	// the modified record moved to another day replaces its old point
+	modified := sink.find(api.records[0].ID)
+	require.Len(t, modified, 1)
+	assert.Equal(t, float64(1000), modified[0].Fields["Steps"])
+	assert.Len(t, sink.find(testRecords(251)[250].ID), 1)
+	assert.Len(t, sync.RecordIDs, 249)
+
+	// ids of every record over 3 pages and the modified records on one
+	require.Len(t, api.requests, 4)
+	assert.Contains(t, api.requests[3], "filterByFormula=OR%28IS_AFTER%28LAST_MODIFIED_TIME%28%29%2C+DATETIME_PARSE%28%272024-01-02T23%3A59%3A00Z%27%29%29")
+
+	// a changed config rewrites every record with it
+	base.Transforms = []config.AirtableTransform{{Field: "Steps", Type: "rename", To: "Step Count"}}
+	api.requests = nil
+
+	resync, err := syncRecords(context.Background(), run, client, linkedCache{}, sink, base, sync, firstSync.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.True(t, sink.replaced)
+	assert.Len(t, sink.points, 249)
+	assert.Len(t, api.requests, 3)
+	assert.NotEqual(t, sync.ConfigHash, resync.ConfigHash)
+	for _, p := range sink.points {
+		assert.Contains(t, p.Fields, "Step Count")
+	}
+}
+
+func TestModifiedSince(t *testing.T) {
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	base := config.AirtableBaseConfig{LastModifiedField: "Last Modified"}
+	assert.Equal(t, "IS_AFTER({Last Modified}, DATETIME_PARSE('2024-01-02T03:04:05Z'))", modifiedSince(base, since))
+}
@@ -0,0 +1,130 @@
+package airtableImporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+)
+
+const (
+	defaultPrecision = "h"
+	// hours from UTC of a record, used for times from midnight when the base has no timezone
+	legacyOffsetField = "Timezone Offset"
+
+	MissingDateSkip = "skip"
+	MissingDateLog  = "log"
+	MissingDateFail = "fail"
+)
+
+// errInvalidDate is returned for records without a valid timestamp, the base's MissingDate policy decides what happens
+var errInvalidDate = errors.New("invalid date")
+
+func timestampField(base config.AirtableBaseConfig) string {
+	if base.TimestampField != "" {
+		return base.TimestampField
+	}
+	return dateField
+}
+
+func precision(base config.AirtableBaseConfig) string {
+	if base.Precision != "" {
+		return base.Precision
+	}
+	return defaultPrecision
+}
+
+// validateBase checks the timestamp settings and transforms of a base before any record is read
+func validateBase(base config.AirtableBaseConfig) error {
+	switch base.MissingDate {
+	case "", MissingDateSkip, MissingDateLog, MissingDateFail:
+	default:
+		return fmt.Errorf("unknown missing date policy %s, use skip, log or fail", base.MissingDate)
+	}
+
+	if base.Timezone != "" {
+		if _, err := time.LoadLocation(base.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %s: %w", base.Timezone, err)
+		}
+	}
+
+	_, err := compileTransforms(base)
+	return err
+}
+
+// recordTime is the timestamp of a record and midnight of its day in the zone of the record, for times from midnight
+func recordTime(base config.AirtableBaseConfig, record AirtableRecords) (time.Time, time.Time, error) {
+	field := timestampField(base)
+	raw, ok := record.Fields[field].(string)
+	if !ok || raw == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: no %s", errInvalidDate, field)
+	}
+
+	location, explicit, err := recordLocation(base, record)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	parseIn := time.UTC
+	if explicit {
+		parseIn = location
+	}
+
+	var t time.Time
+	if base.TimestampLayout != "" {
+		t, err = time.ParseInLocation(base.TimestampLayout, raw, parseIn)
+	} else if t, err = time.ParseInLocation("2006-01-02", raw, parseIn); err != nil {
+		t, err = time.Parse(time.RFC3339, raw)
+	}
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: %s %s", errInvalidDate, field, raw)
+	}
+
+	if explicit {
+		t = t.In(location)
+	}
+
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+	return t, midnight, nil
+}
+
+// recordLocation is the zone of a record, explicit when the base sets one. Without one it is the offset of the
+// Timezone Offset field, which only moves times from midnight
+func recordLocation(base config.AirtableBaseConfig, record AirtableRecords) (*time.Location, bool, error) {
+	if base.TimezoneField != "" {
+		switch value := record.Fields[base.TimezoneField].(type) {
+		case string:
+			location, err := time.LoadLocation(value)
+			if err != nil {
+				return nil, false, fmt.Errorf("%w: timezone %s", errInvalidDate, value)
+			}
+			return location, true, nil
+		case float64, json.Number:
+			return offsetLocation(value), true, nil
+		}
+	}
+
+	if base.Timezone != "" {
+		location, err := time.LoadLocation(base.Timezone)
+		return location, true, err
+	}
+
+	if offset := record.Fields[legacyOffsetField]; offset != nil {
+		return offsetLocation(offset), false, nil
+	}
+
+	return time.UTC, false, nil
+}
+
+func offsetLocation(hours interface{}) *time.Location {
+	offset := 0.0
+	switch hours := hours.(type) {
+	case float64:
+		offset = hours
+	case json.Number:
+		offset, _ = hours.Float64()
+	}
+	return time.FixedZone("", int(offset*3600))
+}
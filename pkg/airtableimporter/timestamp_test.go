@@ -0,0 +1,67 @@
+package airtableImporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordPointTimestamps(t *testing.T) {
+	base := config.AirtableBaseConfig{Fields: config.AirtableFieldsConfig{ConvertToTimeFromMidnightList: []string{"Bedtime"}}}
+
+	// without a timezone dates are UTC and the offset only moves times from midnight
+	point, err := recordPoint(base, AirtableRecords{ID: "rec1", Fields: map[string]interface{}{
+		"Date": "2024-03-01", "Bedtime": "2024-03-02T03:30:00Z", "Timezone Offset": float64(-5),
+	}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), point.Time)
+	assert.Equal(t, 22*60+30.0, point.Fields["Bedtime"])
+
+	// a fixed zone applies to timestamps without one
+	base.TimestampField = "Woke"
+	base.TimestampLayout = "2006-01-02 15:04"
+	base.Timezone = "America/Toronto"
+	point, err = recordPoint(base, AirtableRecords{ID: "rec2", Fields: map[string]interface{}{
+		"Woke": "2024-03-01 07:15", "Bedtime": "2024-03-02T03:30:00Z",
+	}}, nil)
+	require.NoError(t, err)
+	assert.True(t, time.Date(2024, 3, 1, 12, 15, 0, 0, time.UTC).Equal(point.Time))
+	assert.Equal(t, 22*60+30.0, point.Fields["Bedtime"])
+
+	// the zone of a record wins over the base
+	base.TimezoneField = "Zone"
+	point, err = recordPoint(base, AirtableRecords{ID: "rec3", Fields: map[string]interface{}{
+		"Woke": "2024-03-01 07:15", "Zone": "Europe/Paris",
+	}}, nil)
+	require.NoError(t, err)
+	assert.True(t, time.Date(2024, 3, 1, 6, 15, 0, 0, time.UTC).Equal(point.Time))
+
+	_, err = recordPoint(base, AirtableRecords{ID: "rec4", Fields: map[string]interface{}{"Woke": "yesterday"}}, nil)
+	assert.ErrorIs(t, err, errInvalidDate)
+}
+
+func TestWriteRecordsMissingDates(t *testing.T) {
+	records := []AirtableRecords{
+		{ID: "rec1", Fields: map[string]interface{}{"Date": "2024-03-01", "Steps": float64(10)}},
+		{ID: "rec2", Fields: map[string]interface{}{"Steps": float64(20)}},
+	}
+
+	base := config.AirtableBaseConfig{InfluxMeasurement: "health"}
+	sink := &memorySink{points: map[string]sinks.Point{}}
+	err := writeRecords(context.Background(), nil, linkedCache{}, sink, AirtableDataset(base), base, records)
+	assert.ErrorContains(t, err, "rec2")
+	assert.Empty(t, sink.points)
+
+	base.MissingDate = MissingDateSkip
+	err = writeRecords(context.Background(), nil, linkedCache{}, sink, AirtableDataset(base), base, records)
+	require.NoError(t, err)
+	assert.Len(t, sink.points, 1)
+
+	base.MissingDate = "ignore"
+	assert.Error(t, validateBase(base))
+}
@@ -0,0 +1,276 @@
+package airtableImporter
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+)
+
+const (
+	TransformRename  = "rename"
+	TransformCast    = "cast"
+	TransformUnit    = "unit"
+	TransformMap     = "map"
+	TransformTag     = "tag"
+	TransformField   = "field"
+	TransformExtract = "extract"
+	TransformDefault = "default"
+	TransformCompute = "compute"
+)
+
+// transform is a transform of a base with its pattern or expression compiled
+type transform struct {
+	config.AirtableTransform
+	pattern    *regexp.Regexp
+	expression expression
+}
+
+type transforms []transform
+
+// compileTransforms checks the transforms of a base and compiles their patterns and expressions
+func compileTransforms(base config.AirtableBaseConfig) (transforms, error) {
+	compiled := make(transforms, 0, len(base.Transforms))
+	for i, t := range base.Transforms {
+		c := transform{AirtableTransform: t}
+		if t.Field == "" {
+			return nil, fmt.Errorf("transform %d has no field", i)
+		}
+
+		var err error
+		switch t.Type {
+		case TransformRename:
+			if t.To == "" {
+				err = fmt.Errorf("no new name")
+			}
+		case TransformCast:
+			switch t.Cast {
+			case "int", "float", "bool", "string", "duration":
+			default:
+				err = fmt.Errorf("unknown cast %s, use int, float, bool, string or duration", t.Cast)
+			}
+		case TransformExtract:
+			c.pattern, err = regexp.Compile(t.Pattern)
+		case TransformCompute:
+			c.expression, err = parseExpression(t.Expression)
+		case TransformUnit, TransformMap, TransformTag, TransformField, TransformDefault:
+		default:
+			err = fmt.Errorf("unknown type %s", t.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("transform %d of %s: %w", i, t.Field, err)
+		}
+
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// apply runs the transforms in order. Values that fail a cast or conversion are dropped
+func (ts transforms) apply(point *sinks.Point) {
+	for _, t := range ts {
+		t.apply(point)
+	}
+}
+
+func (t transform) apply(point *sinks.Point) {
+	value, isTag, ok := pointValue(point, t.Field)
+
+	switch t.Type {
+	case TransformRename:
+		if ok {
+			removeValue(point, t.Field)
+			setValue(point, t.To, value, isTag)
+		}
+	case TransformCast:
+		if ok {
+			cast, err := castValue(value, t.Cast)
+			if err != nil {
+				slog.Error("Error casting field", "field", t.Field, "value", value, "error", err)
+				removeValue(point, t.Field)
+				return
+			}
+			setValue(point, t.Field, cast, isTag && t.Cast == "string")
+		}
+	case TransformUnit:
+		if ok {
+			n, err := floatValue(value)
+			if err != nil {
+				slog.Error("Error converting field", "field", t.Field, "value", value, "error", err)
+				removeValue(point, t.Field)
+				return
+			}
+			factor := t.Factor
+			if factor == 0 {
+				factor = 1
+			}
+			setValue(point, t.Field, n*factor+t.Offset, false)
+		}
+	case TransformMap:
+		if ok {
+			if mapped, found := t.Values[stringValue(value)]; found {
+				_, isString := mapped.(string)
+				setValue(point, t.Field, mapped, isTag && isString)
+			}
+		}
+	case TransformTag:
+		if ok && !isTag {
+			setValue(point, t.Field, stringValue(value), true)
+		}
+	case TransformField:
+		if ok && isTag {
+			if n, err := floatValue(value); err == nil {
+				setValue(point, t.Field, n, false)
+			} else {
+				setValue(point, t.Field, value, false)
+			}
+		}
+	case TransformExtract:
+		if ok {
+			match := t.pattern.FindStringSubmatch(stringValue(value))
+			if match == nil {
+				removeValue(point, t.Field)
+				return
+			}
+			extracted := match[0]
+			if len(match) > 1 {
+				extracted = match[1]
+			}
+			setValue(point, t.Field, extracted, isTag)
+		}
+	case TransformDefault:
+		if !ok && t.Default != nil {
+			_, isString := t.Default.(string)
+			setValue(point, t.Field, t.Default, isString)
+		}
+	case TransformCompute:
+		result, ok := t.expression.eval(func(name string) (float64, bool) {
+			value, _, ok := pointValue(point, name)
+			if !ok {
+				return 0, false
+			}
+			n, err := floatValue(value)
+			return n, err == nil
+		})
+		if ok {
+			setValue(point, t.Field, result, false)
+		}
+	}
+}
+
+// pointValue is the value of a field or tag of a point, fields first
+func pointValue(point *sinks.Point, name string) (interface{}, bool, bool) {
+	if value, ok := point.Fields[name]; ok {
+		return value, false, true
+	}
+	if value, ok := point.Tags[name]; ok {
+		return value, true, true
+	}
+	return nil, false, false
+}
+
+func setValue(point *sinks.Point, name string, value interface{}, tag bool) {
+	removeValue(point, name)
+	if tag {
+		point.Tags[name] = stringValue(value)
+	} else {
+		point.Fields[name] = value
+	}
+}
+
+func removeValue(point *sinks.Point, name string) {
+	delete(point.Fields, name)
+	delete(point.Tags, name)
+}
+
+func castValue(value interface{}, cast string) (interface{}, error) {
+	switch cast {
+	case "int":
+		n, err := floatValue(value)
+		return int64(n), err
+	case "float":
+		return floatValue(value)
+	case "bool":
+		switch value := value.(type) {
+		case bool:
+			return value, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(value)) {
+			case "yes", "y", "checked":
+				return true, nil
+			case "no", "n", "":
+				return false, nil
+			}
+			return strconv.ParseBool(strings.TrimSpace(value))
+		}
+		n, err := floatValue(value)
+		return n != 0, err
+	case "string":
+		return stringValue(value), nil
+	case "duration":
+		return durationMinutes(value)
+	}
+	return nil, fmt.Errorf("unknown cast %s", cast)
+}
+
+// durationMinutes reads seconds like airtable duration fields, go durations like 1h30m or h:mm:ss as minutes
+func durationMinutes(value interface{}) (float64, error) {
+	s, ok := value.(string)
+	if !ok {
+		seconds, err := floatValue(value)
+		return seconds / 60, err
+	}
+
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return d.Minutes(), nil
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid duration %s", s)
+	}
+	minutes := 0.0
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %s", s)
+		}
+		minutes += n * []float64{60, 1, 1.0 / 60}[i]
+	}
+	return minutes, nil
+}
+
+func floatValue(value interface{}) (float64, error) {
+	switch value := value.(type) {
+	case float64:
+		return value, nil
+	case int:
+		return float64(value), nil
+	case int64:
+		return float64(value), nil
+	case bool:
+		if value {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(value), 64)
+	}
+	return 0, fmt.Errorf("%v isn't a number", value)
+}
+
+func stringValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,105 @@
+package airtableImporter
+
+import (
+	"testing"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransforms(t *testing.T) {
+	base := config.AirtableBaseConfig{Transforms: []config.AirtableTransform{
+		{Field: "Dist", Type: TransformRename, To: "Distance"},
+		{Field: "Distance", Type: TransformUnit, Factor: 1000},
+		{Field: "Time", Type: TransformCast, Cast: "duration"},
+		{Field: "Steps", Type: TransformCast, Cast: "int"},
+		{Field: "Done", Type: TransformCast, Cast: "bool"},
+		{Field: "Mood", Type: TransformMap, Values: map[string]interface{}{"good": 2.0, "bad": 0.0}},
+		{Field: "Mood", Type: TransformTag},
+		{Field: "Room", Type: TransformField},
+		{Field: "Notes", Type: TransformExtract, Pattern: `weight (\d+)`},
+		{Field: "Missing", Type: TransformExtract, Pattern: `x`},
+		{Field: "Location", Type: TransformDefault, Default: "home"},
+		{Field: "Pace", Type: TransformCompute, Expression: "{Time} / (Distance / 1000)"},
+		{Field: "Nothing", Type: TransformCompute, Expression: "{Unknown} * 2"},
+	}}
+	transforms, err := compileTransforms(base)
+	require.NoError(t, err)
+
+	point := sinks.Point{
+		Tags:   map[string]string{"Time": "1:30", "Steps": "1200.7", "Done": "yes", "Mood": "good", "Room": "12", "Notes": "weight 70kg", "Missing": "y"},
+		Fields: map[string]interface{}{"Dist": 5.0},
+	}
+	transforms.apply(&point)
+
+	assert.Equal(t, map[string]string{"Mood": "2", "Notes": "70", "Location": "home"}, point.Tags)
+	assert.Equal(t, map[string]interface{}{
+		"Distance": 5000.0,
+		"Time":     90.0,
+		"Steps":    int64(1200),
+		"Done":     true,
+		"Room":     12.0,
+		"Pace":     18.0,
+	}, point.Fields)
+}
+
+func TestTransformCastFailure(t *testing.T) {
+	transforms, err := compileTransforms(config.AirtableBaseConfig{Transforms: []config.AirtableTransform{
+		{Field: "Steps", Type: TransformCast, Cast: "int"},
+	}})
+	require.NoError(t, err)
+
+	point := sinks.Point{Tags: map[string]string{"Steps": "many"}, Fields: map[string]interface{}{}}
+	transforms.apply(&point)
+	assert.Empty(t, point.Tags)
+	assert.Empty(t, point.Fields)
+}
+
+func TestCompileTransformsErrors(t *testing.T) {
+	for _, transform := range []config.AirtableTransform{
+		{Type: TransformCast, Cast: "int"},
+		{Field: "a", Type: "unknown"},
+		{Field: "a", Type: TransformRename},
+		{Field: "a", Type: TransformCast, Cast: "date"},
+		{Field: "a", Type: TransformExtract, Pattern: "("},
+		{Field: "a", Type: TransformCompute, Expression: "(b + 1"},
+	} {
+		_, err := compileTransforms(config.AirtableBaseConfig{Transforms: []config.AirtableTransform{transform}})
+		assert.Error(t, err, "%+v", transform)
+	}
+}
+
+func TestParseExpression(t *testing.T) {
+	fields := map[string]float64{"Distance": 10, "Moving Time": 50}
+	lookup := func(name string) (float64, bool) {
+		n, ok := fields[name]
+		return n, ok
+	}
+
+	for input, want := range map[string]float64{
+		"1 + 2 * 3":                  7,
+		"(1 + 2) * 3":                9,
+		"-Distance + 4":              -6,
+		"{Moving Time} / Distance":   5,
+		"Distance - 2 - 3":           5,
+		"{Moving Time} / 2 / 5 + .5": 5.5,
+	} {
+		e, err := parseExpression(input)
+		require.NoError(t, err, input)
+		got, ok := e.eval(lookup)
+		assert.True(t, ok, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	e, err := parseExpression("Distance / (Distance - 10)")
+	require.NoError(t, err)
+	_, ok := e.eval(lookup)
+	assert.False(t, ok)
+
+	for _, input := range []string{"", "1 +", "{Distance", "1 2", "1 $ 2"} {
+		_, err := parseExpression(input)
+		assert.Error(t, err, input)
+	}
+}
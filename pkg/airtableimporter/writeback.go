@@ -0,0 +1,238 @@
+package airtableImporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/importruns"
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/crufter/airtable-go"
+	"github.com/uptrace/bun"
+)
+
+// WriteBackRunner upserts the results of postgres queries into airtable tables
+type WriteBackRunner struct {
+	db *bun.DB
+}
+
+// NewWriteBackRunner connects to the postgres database the queries run against
+func NewWriteBackRunner() (*WriteBackRunner, error) {
+	if config.CurrentSqlSecrets().SqlHost == "" && config.CurrentSecrets().DatabaseURL == "" {
+		return nil, fmt.Errorf("airtable write backs need a postgres database")
+	}
+
+	db, err := postgresutils.CreatePostgresClient(config.CurrentYnabConfig().SQL.YnabDatabase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WriteBackRunner{db: db}, nil
+}
+
+func (r *WriteBackRunner) Run(run *importruns.Run) error {
+	ctx := context.Background()
+
+	for _, wb := range config.CurrentAirtableConfig().WriteBacks {
+		client, err := airtable.New(config.CurrentAirtableSecrets().AirtableAPIKey, wb.BaseID)
+		if err != nil {
+			return err
+		}
+
+		err = writeBack(ctx, run, r.db, client, wb)
+		if err != nil {
+			return fmt.Errorf("airtable write back %s: %w", wb.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *WriteBackRunner) Close() error {
+	return r.db.Close()
+}
+
+// airtableRecord is a record as the api reads and writes it
+type airtableRecord struct {
+	ID     string                 `json:"id,omitempty"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// writeBack creates a record for every row of the query without one and updates the records that changed
+func writeBack(ctx context.Context, run *importruns.Run, db *bun.DB, client *airtable.Client, wb config.AirtableWriteBack) error {
+	if wb.KeyField == "" {
+		return fmt.Errorf("no key field")
+	}
+
+	rows, fields, err := queryRows(ctx, db, wb)
+	if err != nil {
+		return err
+	}
+
+	records := []AirtableRecords{}
+	err = client.ListRecords(wb.Table, &records, airtable.ListParameters{Fields: fields})
+	if err != nil {
+		return fmt.Errorf("Error getting airtable records: %s", err.Error())
+	}
+
+	existing := make(map[string]AirtableRecords, len(records))
+	for _, record := range records {
+		if key, ok := record.Fields[wb.KeyField]; ok && key != nil {
+			existing[stringValue(key)] = record
+		}
+	}
+
+	inserted, updated := 0, 0
+	for _, row := range rows {
+		key, ok := row[wb.KeyField]
+		if !ok || key == nil {
+			return fmt.Errorf("row without %s", wb.KeyField)
+		}
+
+		record, ok := existing[stringValue(key)]
+		if !ok {
+			created := &airtableRecord{Fields: row}
+			err = client.CreateRecord(wb.Table, created)
+			if err != nil {
+				return fmt.Errorf("Error creating airtable record %v: %s", key, err.Error())
+			}
+			existing[stringValue(key)] = AirtableRecords{ID: created.ID, Fields: row}
+			inserted++
+			continue
+		}
+
+		if unchanged(record.Fields, row) {
+			continue
+		}
+		err = client.UpdateRecord(wb.Table, record.ID, row, &airtableRecord{})
+		if err != nil {
+			return fmt.Errorf("Error updating airtable record %v: %s", key, err.Error())
+		}
+		updated++
+	}
+
+	run.AddTableCounts(wb.Table, inserted, updated, 0)
+	slog.Info("wrote rows to airtable", "base", wb.BaseID, "table", wb.Table, "rows", len(rows), "created", inserted, "updated", updated)
+	return nil
+}
+
+// queryRows runs the query of a write back, returning its rows by airtable field and the fields
+func queryRows(ctx context.Context, db *bun.DB, wb config.AirtableWriteBack) ([]map[string]interface{}, []string, error) {
+	rows, err := db.QueryContext(ctx, wb.Query)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fields := make([]string, len(columns))
+	for i, column := range columns {
+		fields[i] = column
+		if field, ok := wb.Fields[column]; ok {
+			fields[i] = field
+		}
+	}
+
+	results := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, nil, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, value := range values {
+			row[fields[i]] = fieldValue(value, columnTypes[i].DatabaseTypeName())
+		}
+		results = append(results, row)
+	}
+
+	return results, fields, rows.Err()
+}
+
+// fieldValue converts a column to a value airtable accepts. Numerics come from postgres as text, bytes are only read as
+// numbers for numeric columns or when the driver doesn't report column types like pgdriver. Dates are written like
+// airtable date fields
+func fieldValue(value interface{}, databaseType string) interface{} {
+	switch value := value.(type) {
+	case []byte:
+		if numericColumn(databaseType) {
+			if n, err := strconv.ParseFloat(string(value), 64); err == nil {
+				return n
+			}
+		}
+		return string(value)
+	case int64:
+		return float64(value)
+	case time.Time:
+		if strings.EqualFold(databaseType, "date") {
+			return value.Format("2006-01-02")
+		}
+		return value.Format(time.RFC3339)
+	}
+	return value
+}
+
+func numericColumn(databaseType string) bool {
+	if databaseType == "" {
+		return true
+	}
+
+	databaseType = strings.ToUpper(databaseType)
+	for _, prefix := range []string{"NUMERIC", "DECIMAL", "INT", "FLOAT", "REAL", "DOUBLE"} {
+		if strings.HasPrefix(databaseType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameValue is whether a value of a record is the value of a row. Times are compared as instants, airtable writes them
+// back in UTC with milliseconds
+func sameValue(current interface{}, value interface{}) bool {
+	currentString, valueString := stringValue(current), stringValue(value)
+	if currentString == valueString {
+		return true
+	}
+
+	currentTime, err := time.Parse(time.RFC3339, currentString)
+	if err != nil {
+		return false
+	}
+	valueTime, err := time.Parse(time.RFC3339, valueString)
+	return err == nil && currentTime.Equal(valueTime)
+}
+
+// unchanged is whether a record already has the values of a row, fields airtable leaves out are empty
+func unchanged(fields map[string]interface{}, row map[string]interface{}) bool {
+	for field, value := range row {
+		current, ok := fields[field]
+		if !ok || current == nil {
+			if value == nil || value == "" || value == false {
+				continue
+			}
+			return false
+		}
+		if value == nil || !sameValue(current, value) {
+			return false
+		}
+	}
+	return true
+}
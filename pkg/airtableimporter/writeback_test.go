@@ -0,0 +1,100 @@
+package airtableImporter
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/importruns"
+	"github.com/crufter/airtable-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/driver/sqliteshim"
+)
+
+func TestWriteBack(t *testing.T) {
+	ctx := context.Background()
+
+	sqldb, err := sql.Open(sqliteshim.ShimName, "file::memory:")
+	require.NoError(t, err)
+	db := bun.NewDB(sqldb, sqlitedialect.New())
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE transactions (date TEXT, category_group TEXT, amount REAL)`)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO transactions VALUES
+		('2024-01-05', 'Food', -20.5), ('2024-01-20', 'Food', -10), ('2024-01-09', 'Rent', -1000), ('2024-02-01', 'Food', -5)`)
+	require.NoError(t, err)
+
+	api := &testAirtable{records: []AirtableRecords{
+		{ID: "rec00000000000001", Fields: map[string]interface{}{"Key": "2024-01 Food", "Spent": 10.0, "Notes": "kept"}},
+		{ID: "rec00000000000002", Fields: map[string]interface{}{"Key": "2024-01 Rent", "Month": "2024-01", "Group": "Rent", "Spent": 1000.0}},
+		{ID: "rec00000000000003", Fields: map[string]interface{}{"Key": "2023-12 Food", "Spent": 40.0}},
+	}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	client, err := airtable.New("keyAAAAAAAAAAAAAA", "appAAAAAAAAAAAAAA")
+	require.NoError(t, err)
+	client.HTTPClient = &http.Client{Transport: rewriteTransport{server}}
+
+	recorder, err := importruns.NewRecorder(nil, "")
+	require.NoError(t, err)
+	run := recorder.Start("airtable-writeback")
+
+	wb := config.AirtableWriteBack{
+		Table:    "Spending",
+		KeyField: "Key",
+		Query: `SELECT substr(date, 1, 7) || ' ' || category_group AS key, substr(date, 1, 7) AS month,
+			category_group, -sum(amount) AS spent FROM transactions GROUP BY 1, 2, 3 ORDER BY 1`,
+		Fields: map[string]string{"key": "Key", "month": "Month", "category_group": "Group", "spent": "Spent"},
+	}
+	require.NoError(t, writeBack(ctx, run, db, client, wb))
+
+	// the changed record is updated, the unchanged one is left alone and the new month is created
+	require.Len(t, api.records, 4)
+	assert.Equal(t, map[string]interface{}{"Key": "2024-01 Food", "Month": "2024-01", "Group": "Food", "Spent": 30.5, "Notes": "kept"}, api.records[0].Fields)
+	assert.Equal(t, 40.0, api.records[2].Fields["Spent"])
+	assert.Equal(t, map[string]interface{}{"Key": "2024-02 Food", "Month": "2024-02", "Group": "Food", "Spent": 5.0}, api.records[3].Fields)
+	assert.Equal(t, []string{
+		"GET fields%5B%5D=Key&fields%5B%5D=Month&fields%5B%5D=Group&fields%5B%5D=Spent&offset=",
+		"PATCH ",
+		"POST ",
+	}, api.requests)
+
+	// writing the same rows again changes nothing
+	api.requests = nil
+	require.NoError(t, writeBack(ctx, run, db, client, wb))
+	assert.Len(t, api.requests, 1)
+	assert.Len(t, api.records, 4)
+}
+
+func TestUnchanged(t *testing.T) {
+	fields := map[string]interface{}{"Key": "a", "Spent": 12.5, "Count": 3.0}
+	assert.True(t, unchanged(fields, map[string]interface{}{"Key": "a", "Spent": 12.5, "Count": float64(3), "Empty": nil, "Done": false}))
+	assert.False(t, unchanged(fields, map[string]interface{}{"Key": "a", "Spent": 13.0}))
+	assert.False(t, unchanged(fields, map[string]interface{}{"Key": "a", "Spent": nil}))
+	assert.False(t, unchanged(fields, map[string]interface{}{"Key": "a", "Notes": "new"}))
+
+	// airtable returns times in UTC with milliseconds
+	times := map[string]interface{}{"At": "2024-01-02T08:04:05.000Z"}
+	assert.True(t, unchanged(times, map[string]interface{}{"At": "2024-01-02T03:04:05-05:00"}))
+	assert.False(t, unchanged(times, map[string]interface{}{"At": "2024-01-02T03:04:05Z"}))
+}
+
+func TestFieldValue(t *testing.T) {
+	date := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "2024-01-02", fieldValue(date, "DATE"))
+	assert.Equal(t, "2024-01-02T00:00:00Z", fieldValue(date, "TIMESTAMPTZ"))
+
+	assert.Equal(t, 12.5, fieldValue([]byte("12.5"), "NUMERIC"))
+	assert.Equal(t, 12.5, fieldValue([]byte("12.5"), ""))
+	assert.Equal(t, "0012", fieldValue([]byte("0012"), "BLOB"))
+	assert.Equal(t, "0012", fieldValue([]byte("0012"), "VARCHAR"))
+}
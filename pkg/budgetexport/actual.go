@@ -0,0 +1,370 @@
+package budgetexport
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/driver/sqliteshim"
+)
+
+const actualStartingBalancePayee = "Starting Balance"
+
+type actualAccount struct {
+	bun.BaseModel `bun:"table:accounts"`
+	ID            string `bun:"id"`
+	Name          string `bun:"name"`
+	Offbudget     int    `bun:"offbudget"`
+	Closed        int    `bun:"closed"`
+}
+
+type actualCategoryGroup struct {
+	bun.BaseModel `bun:"table:category_groups"`
+	ID            string `bun:"id"`
+	Name          string `bun:"name"`
+}
+
+type actualCategory struct {
+	bun.BaseModel `bun:"table:categories"`
+	ID            string `bun:"id"`
+	Name          string `bun:"name"`
+	CatGroup      string `bun:"cat_group"`
+}
+
+type actualPayee struct {
+	bun.BaseModel `bun:"table:payees"`
+	ID            string         `bun:"id"`
+	Name          sql.NullString `bun:"name"`
+	TransferAcct  sql.NullString `bun:"transfer_acct"`
+}
+
+type actualMapping struct {
+	ID       string `bun:"id"`
+	TargetID string `bun:"target_id"`
+}
+
+type actualTransaction struct {
+	bun.BaseModel `bun:"table:transactions"`
+	ID            string         `bun:"id"`
+	IsParent      int            `bun:"isParent"`
+	IsChild       int            `bun:"isChild"`
+	Acct          string         `bun:"acct"`
+	Category      sql.NullString `bun:"category"`
+	Amount        int64          `bun:"amount"`
+	Description   sql.NullString `bun:"description"`
+	Notes         sql.NullString `bun:"notes"`
+	Date          int            `bun:"date"`
+	ParentID      sql.NullString `bun:"parent_id"`
+}
+
+type actualBudget struct {
+	Month     int    `bun:"month"`
+	Category  string `bun:"category"`
+	Amount    int64  `bun:"amount"`
+	Carryover int    `bun:"carryover"`
+}
+
+// ReadActual reads the db.sqlite of an Actual Budget export. Amounts are stored in cents. Budgets are read from the
+// envelope and tracking budget tables, envelope balances roll over when positive or when carryover is set
+func ReadActual(path, budgetID string) (*Export, error) {
+	sqldb, err := sql.Open(sqliteshim.ShimName, "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open actual budget %s: %w", path, err)
+	}
+	db := bun.NewDB(sqldb, sqlitedialect.New())
+	defer db.Close()
+
+	ctx := context.Background()
+
+	accounts := []actualAccount{}
+	err = db.NewSelect().Model(&accounts).Where("tombstone = 0").Order("sort_order").Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read actual accounts: %w", err)
+	}
+
+	groups := []actualCategoryGroup{}
+	err = db.NewSelect().Model(&groups).Where("tombstone = 0").Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read actual category groups: %w", err)
+	}
+
+	categories := []actualCategory{}
+	err = db.NewSelect().Model(&categories).Where("tombstone = 0").Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read actual categories: %w", err)
+	}
+
+	payees := []actualPayee{}
+	err = db.NewSelect().Model(&payees).Where("tombstone = 0").Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read actual payees: %w", err)
+	}
+
+	transactions := []actualTransaction{}
+	err = db.NewSelect().Model(&transactions).Where("tombstone = 0").Order("date", "sort_order").Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read actual transactions: %w", err)
+	}
+
+	// merged payees and categories point at the one they were merged into
+	payeeMapping, err := actualMappings(ctx, db, "payee_mapping")
+	if err != nil {
+		return nil, err
+	}
+	categoryMapping, err := actualMappings(ctx, db, "category_mapping")
+	if err != nil {
+		return nil, err
+	}
+
+	export := &Export{}
+
+	accountNames := map[string]string{}
+	for _, a := range accounts {
+		accountNames[a.ID] = a.Name
+		export.Accounts = append(export.Accounts, Account{ID: a.ID, Name: a.Name, OnBudget: a.Offbudget == 0, Closed: a.Closed == 1})
+	}
+
+	groupNames := map[string]string{}
+	for _, g := range groups {
+		groupNames[g.ID] = g.Name
+	}
+
+	categoriesByID := map[string]actualCategory{}
+	for _, c := range categories {
+		categoriesByID[c.ID] = c
+	}
+
+	payeesByID := map[string]actualPayee{}
+	for _, p := range payees {
+		payeesByID[p.ID] = p
+	}
+
+	children := map[string][]actualTransaction{}
+	for _, t := range transactions {
+		if t.IsChild == 1 && t.ParentID.Valid {
+			children[t.ParentID.String] = append(children[t.ParentID.String], t)
+		}
+	}
+
+	convert := func(t actualTransaction) *Transaction {
+		transaction := &Transaction{
+			id:          "actual::" + t.ID,
+			budgetID:    budgetID,
+			date:        actualDate(t.Date),
+			accountID:   t.Acct,
+			accountName: accountNames[t.Acct],
+			notes:       t.Notes.String,
+			amount:      float64(t.Amount) / 100.0,
+			tags:        notesTags(t.Notes.String),
+		}
+
+		if t.Description.Valid {
+			payeeID := mapped(payeeMapping, t.Description.String)
+			payee := payeesByID[payeeID]
+			transaction.payeeID = payeeID
+			transaction.payeeName = payee.Name.String
+			if payee.TransferAcct.Valid {
+				transaction.transferAccount = accountNames[payee.TransferAcct.String]
+				if transaction.payeeName == "" {
+					transaction.payeeName = "Transfer : " + transaction.transferAccount
+				}
+			}
+		}
+
+		if t.Category.Valid {
+			category := categoriesByID[mapped(categoryMapping, t.Category.String)]
+			transaction.categoryID = category.ID
+			transaction.categoryName = category.Name
+			transaction.categoryGroup = groupNames[category.CatGroup]
+		}
+
+		return transaction
+	}
+
+	// activity of each category by month, from the transactions that have a category
+	activity := map[string]map[int]float64{}
+	addActivity := func(t actualTransaction) {
+		if !t.Category.Valid {
+			return
+		}
+		category := mapped(categoryMapping, t.Category.String)
+		if activity[category] == nil {
+			activity[category] = map[int]float64{}
+		}
+		activity[category][t.Date/100] += float64(t.Amount) / 100.0
+	}
+
+	for _, t := range transactions {
+		if t.IsChild == 1 {
+			continue
+		}
+
+		transaction := convert(t)
+		if t.IsParent == 1 {
+			for _, child := range children[t.ID] {
+				split := convert(child)
+				split.tags = mergeTags(transaction.tags, split.tags)
+				if split.notes == "" {
+					split.notes = transaction.notes
+				}
+				if split.payeeName == "" {
+					split.payeeID, split.payeeName = transaction.payeeID, transaction.payeeName
+				}
+				transaction.splits = append(transaction.splits, split)
+				addActivity(child)
+			}
+		} else {
+			addActivity(t)
+		}
+
+		export.Transactions = append(export.Transactions, transaction)
+	}
+
+	budgets := []actualBudget{}
+	for _, table := range []string{"zero_budgets", "reflect_budgets"} {
+		rows := []actualBudget{}
+		err := db.NewSelect().Table(table).Column("month", "category", "amount", "carryover").Scan(ctx, &rows)
+		if err != nil && !strings.Contains(err.Error(), "no such table") {
+			return nil, fmt.Errorf("failed to read actual %s: %w", table, err)
+		}
+		budgets = append(budgets, rows...)
+	}
+
+	export.Budgets = actualBudgets(budgets, activity, categoriesByID, groupNames)
+
+	return export, nil
+}
+
+// actualBudgets has a row for every month of a category from the first until the last month it was budgeted or spent in
+func actualBudgets(budgets []actualBudget, activity map[string]map[int]float64, categories map[string]actualCategory, groupNames map[string]string) []Budget {
+	type month struct {
+		budgeted  float64
+		carryover bool
+	}
+
+	months := map[string]map[int]month{}
+	for _, b := range budgets {
+		if _, ok := categories[b.Category]; !ok {
+			continue
+		}
+		if months[b.Category] == nil {
+			months[b.Category] = map[int]month{}
+		}
+		m := months[b.Category][b.Month]
+		m.budgeted += float64(b.Amount) / 100.0
+		m.carryover = m.carryover || b.Carryover == 1
+		months[b.Category][b.Month] = m
+	}
+
+	for categoryID, byMonth := range activity {
+		if _, ok := categories[categoryID]; !ok {
+			continue
+		}
+		if months[categoryID] == nil {
+			months[categoryID] = map[int]month{}
+		}
+		for m := range byMonth {
+			months[categoryID][m] = months[categoryID][m]
+		}
+	}
+
+	categoryIDs := make([]string, 0, len(months))
+	for id := range months {
+		categoryIDs = append(categoryIDs, id)
+	}
+	sort.Strings(categoryIDs)
+
+	rows := []Budget{}
+	for _, categoryID := range categoryIDs {
+		keys := make([]int, 0, len(months[categoryID]))
+		for m := range months[categoryID] {
+			keys = append(keys, m)
+		}
+		sort.Ints(keys)
+
+		category := categories[categoryID]
+		carried := 0.0
+		carryover := false
+		for key := keys[0]; key <= keys[len(keys)-1]; key = nextActualMonth(key) {
+			// months without a budget or activity keep the balance and carryover of the month before
+			m, ok := months[categoryID][key]
+			if !ok {
+				m.carryover = carryover
+			}
+			carryover = m.carryover
+			spent := activity[categoryID][key]
+			balance := carried + m.budgeted + spent
+
+			rows = append(rows, Budget{
+				Month:           time.Date(key/100, time.Month(key%100), 1, 0, 0, 0, 0, time.UTC),
+				CategoryID:      categoryID,
+				Category:        category.Name,
+				CategoryGroupID: category.CatGroup,
+				CategoryGroup:   groupNames[category.CatGroup],
+				Budgeted:        m.budgeted,
+				Activity:        spent,
+				Balance:         balance,
+			})
+
+			carried = 0
+			if balance > 0 || m.carryover {
+				carried = balance
+			}
+		}
+	}
+
+	return rows
+}
+
+// nextActualMonth is the month after a yyyymm month
+func nextActualMonth(month int) int {
+	if month%100 == 12 {
+		return (month/100+1)*100 + 1
+	}
+	return month + 1
+}
+
+// actualMappings reads a mapping table, older exports don't have them
+func actualMappings(ctx context.Context, db *bun.DB, table string) (map[string]string, error) {
+	rows := []actualMapping{}
+	err := db.NewSelect().Table(table).ColumnExpr("id, targetId AS target_id").Scan(ctx, &rows)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("failed to read actual %s: %w", table, err)
+	}
+
+	mapping := make(map[string]string, len(rows))
+	for _, row := range rows {
+		mapping[row.ID] = row.TargetID
+	}
+	return mapping, nil
+}
+
+func mapped(mapping map[string]string, id string) string {
+	if target, ok := mapping[id]; ok && target != "" {
+		return target
+	}
+	return id
+}
+
+// actualDate formats the YYYYMMDD integers actual stores dates as
+func actualDate(date int) string {
+	return fmt.Sprintf("%04d-%02d-%02d", date/10000, date/100%100, date%100)
+}
+
+func mergeTags(a, b []string) []string {
+	tags := append([]string{}, a...)
+	for _, tag := range b {
+		if !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,108 @@
+package budgetexport
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const actualSchema = `
+CREATE TABLE accounts (id TEXT PRIMARY KEY, name TEXT, offbudget INTEGER DEFAULT 0, closed INTEGER DEFAULT 0, sort_order REAL, tombstone INTEGER DEFAULT 0);
+CREATE TABLE category_groups (id TEXT PRIMARY KEY, name TEXT, is_income INTEGER DEFAULT 0, tombstone INTEGER DEFAULT 0);
+CREATE TABLE categories (id TEXT PRIMARY KEY, name TEXT, cat_group TEXT, is_income INTEGER DEFAULT 0, tombstone INTEGER DEFAULT 0);
+CREATE TABLE payees (id TEXT PRIMARY KEY, name TEXT, transfer_acct TEXT, tombstone INTEGER DEFAULT 0);
+CREATE TABLE payee_mapping (id TEXT PRIMARY KEY, targetId TEXT);
+CREATE TABLE transactions (id TEXT PRIMARY KEY, isParent INTEGER DEFAULT 0, isChild INTEGER DEFAULT 0, acct TEXT, category TEXT, amount INTEGER, description TEXT, notes TEXT, date INTEGER, parent_id TEXT, sort_order REAL, tombstone INTEGER DEFAULT 0);
+CREATE TABLE zero_budgets (id TEXT PRIMARY KEY, month INTEGER, category TEXT, amount INTEGER, carryover INTEGER DEFAULT 0);
+
+INSERT INTO accounts (id, name, offbudget, sort_order) VALUES ('chq', 'Chequing', 0, 1), ('visa', 'Visa', 0, 2), ('old', 'Old', 0, 3);
+UPDATE accounts SET tombstone = 1 WHERE id = 'old';
+INSERT INTO category_groups (id, name) VALUES ('g1', 'Everyday');
+INSERT INTO categories (id, name, cat_group) VALUES ('food', 'Groceries', 'g1'), ('home', 'Home', 'g1');
+INSERT INTO payees (id, name, transfer_acct) VALUES ('costco', 'Costco', NULL), ('costco-dup', 'costco', NULL), ('to-visa', '', 'visa'), ('to-chq', '', 'chq');
+INSERT INTO payee_mapping (id, targetId) VALUES ('costco-dup', 'costco');
+INSERT INTO transactions (id, isParent, isChild, acct, category, amount, description, notes, date, parent_id, sort_order) VALUES
+	('t1', 1, 0, 'visa', NULL, -8000, 'costco-dup', 'weekly #Food', 20240102, NULL, 1),
+	('t1a', 0, 1, 'visa', 'food', -5000, NULL, NULL, 20240102, 't1', 2),
+	('t1b', 0, 1, 'visa', 'home', -3000, NULL, 'chairs #patio', 20240102, 't1', 3),
+	('t2', 0, 0, 'chq', NULL, -8000, 'to-visa', NULL, 20240201, NULL, 4),
+	('t3', 0, 0, 'visa', NULL, 8000, 'to-chq', NULL, 20240201, NULL, 5),
+	('t4', 0, 0, 'chq', 'food', -2000, 'costco', NULL, 20240203, NULL, 6);
+INSERT INTO zero_budgets (id, month, category, amount) VALUES ('202401-food', 202401, 'food', 6000), ('202402-food', 202402, 'food', 1000), ('202401-home', 202401, 'home', 2000);
+`
+
+func TestReadActual(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+	db, err := sinks.OpenSQLite(path)
+	require.NoError(t, err)
+	_, err = db.Exec(actualSchema)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	export, err := ReadActual(path, "actual::friends")
+	require.NoError(t, err)
+
+	assert.Equal(t, []Account{{ID: "chq", Name: "Chequing", OnBudget: true}, {ID: "visa", Name: "Visa", OnBudget: true}}, export.Accounts)
+	require.Len(t, export.Transactions, 4)
+
+	split := export.Transactions[0]
+	assert.Equal(t, "actual::t1", split.IndexKey())
+	assert.Equal(t, "2024-01-02", split.Date())
+	assert.Equal(t, "Costco", split.Payee())
+	assert.Equal(t, -80.0, split.Amount())
+	assert.Equal(t, []string{"food"}, split.Tags())
+
+	subs := split.SubTransactions()
+	require.Len(t, subs, 2)
+	assert.Equal(t, "Groceries", subs[0].Category())
+	assert.Equal(t, "Everyday", subs[0].CategoryGroup())
+	assert.Equal(t, "Costco", subs[0].Payee())
+	assert.Equal(t, "weekly #Food", subs[0].Memo())
+	assert.Equal(t, []string{"food", "patio"}, subs[1].Tags())
+
+	transfer := export.Transactions[1]
+	assert.Equal(t, "Transfer : Visa", transfer.Payee())
+	assert.Equal(t, financialimporter.Transfer, transfer.TransactionType())
+
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []Budget{
+		{Month: jan, CategoryID: "food", Category: "Groceries", CategoryGroupID: "g1", CategoryGroup: "Everyday", Budgeted: 60, Activity: -50, Balance: 10},
+		{Month: feb, CategoryID: "food", Category: "Groceries", CategoryGroupID: "g1", CategoryGroup: "Everyday", Budgeted: 10, Activity: -20, Balance: 0},
+		{Month: jan, CategoryID: "home", Category: "Home", CategoryGroupID: "g1", CategoryGroup: "Everyday", Budgeted: 20, Activity: -30, Balance: -10},
+	}, export.Budgets)
+}
+
+func TestActualBudgetsFillsGapMonths(t *testing.T) {
+	categories := map[string]actualCategory{"car": {ID: "car", Name: "Car Repairs", CatGroup: "g1"}}
+	budgets := []actualBudget{
+		{Month: 202311, Category: "car", Amount: 5000},
+		{Month: 202402, Category: "car", Amount: 5000},
+	}
+	activity := map[string]map[int]float64{"car": {202401: -20}}
+
+	rows := actualBudgets(budgets, activity, categories, map[string]string{"g1": "Sinking Funds"})
+
+	balances := map[time.Month]float64{}
+	months := []time.Time{}
+	for _, row := range rows {
+		months = append(months, row.Month)
+		balances[row.Month.Month()] = row.Balance
+	}
+	assert.Equal(t, []time.Time{
+		time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+	}, months)
+
+	// december has no budget or activity and carries the november balance
+	assert.Equal(t, Budget{Month: months[1], CategoryID: "car", Category: "Car Repairs", CategoryGroupID: "g1", CategoryGroup: "Sinking Funds", Balance: 50}, rows[1])
+	assert.Equal(t, 30.0, balances[time.January])
+	assert.Equal(t, 80.0, balances[time.February])
+}
@@ -0,0 +1,188 @@
+package budgetexport
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+)
+
+const (
+	TypeActual    = "actual"
+	TypeFirefly   = "firefly"
+	TypeSplitwise = "splitwise"
+)
+
+var notesTagRegex = regexp.MustCompile(`(?:^|\s)#([A-Za-z0-9][A-Za-z0-9\-_/]*)`)
+
+// Export is the content of another budgeting app's export in the shape of a ynab budget
+type Export struct {
+	Accounts     []Account
+	Transactions []*Transaction
+	Budgets      []Budget
+	// Adjustments change account balances without a transaction
+	Adjustments []Adjustment
+}
+
+// Account is an account transactions are in. Type is a ynab account type, empty when the app doesn't have one
+type Account struct {
+	ID       string
+	Name     string
+	Type     string
+	OnBudget bool
+	Closed   bool
+}
+
+// Adjustment changes the balance of an account on a day, like what a person owes us for a shared expense
+type Adjustment struct {
+	AccountID string
+	Date      string
+	Amount    float64
+	// Currency of the amount, empty for the currency of the export
+	Currency string
+}
+
+// Budget is the budgeted amount, activity and balance of a category for a month
+type Budget struct {
+	Month           time.Time
+	CategoryID      string
+	Category        string
+	CategoryGroupID string
+	CategoryGroup   string
+	Budgeted        float64
+	Activity        float64
+	Balance         float64
+}
+
+// Transaction is a transaction of an export as a financialimporter.Transaction. Transfers are in the export once per
+// account like ynab, so account balances are the sum of the account's transactions
+type Transaction struct {
+	id              string
+	budgetID        string
+	date            string
+	accountID       string
+	accountName     string
+	payeeID         string
+	payeeName       string
+	categoryID      string
+	categoryName    string
+	categoryGroup   string
+	notes           string
+	amount          float64
+	currency        string
+	transferAccount string
+	tags            []string
+	splits          []*Transaction
+}
+
+func (t *Transaction) Date() string {
+	return t.date
+}
+
+func (t *Transaction) Payee() string {
+	return t.payeeName
+}
+
+func (t *Transaction) Category() string {
+	return t.categoryName
+}
+
+func (t *Transaction) CategoryGroup() string {
+	return t.categoryGroup
+}
+
+func (t *Transaction) Memo() string {
+	return t.notes
+}
+
+func (t *Transaction) Amount() float64 {
+	return t.amount
+}
+
+// Currency is the currency of the amount, empty for the currency of the export
+func (t *Transaction) Currency() string {
+	return t.currency
+}
+
+func (t *Transaction) TransactionType() financialimporter.TransactionType {
+	if t.transferAccount != "" {
+		return financialimporter.Transfer
+	}
+
+	if t.amount >= 0 {
+		return financialimporter.Income
+	}
+
+	return financialimporter.Expense
+}
+
+func (t *Transaction) Tags() []string {
+	return t.tags
+}
+
+func (t *Transaction) HasSubTransactions() bool {
+	return len(t.splits) > 0
+}
+
+func (t *Transaction) SubTransactions() []financialimporter.Transaction {
+	transactions := make([]financialimporter.Transaction, len(t.splits))
+	for i, split := range t.splits {
+		transactions[i] = split
+	}
+	return transactions
+}
+
+func (t *Transaction) Account() string {
+	return t.accountName
+}
+
+func (t *Transaction) IndexKey() string {
+	return t.id
+}
+
+func (t *Transaction) TransferAccount() string {
+	return t.transferAccount
+}
+
+func (t *Transaction) BudgetID() string {
+	return t.budgetID
+}
+
+func (t *Transaction) AccountID() string {
+	return t.accountID
+}
+
+func (t *Transaction) CategoryID() string {
+	return t.categoryID
+}
+
+func (t *Transaction) PayeeID() string {
+	return t.payeeID
+}
+
+// notesTags are the #tags in notes, neither app has tags on splits
+func notesTags(notes string) []string {
+	tags := []string{}
+	for _, match := range notesTagRegex.FindAllStringSubmatch(notes, -1) {
+		tag := strings.ToLower(match[1])
+		if !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func contains(list []string, item string) bool {
+	for _, l := range list {
+		if l == item {
+			return true
+		}
+	}
+	return false
+}
+
+func monthOf(date string) time.Time {
+	t, _ := time.Parse("2006-01-02", date)
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
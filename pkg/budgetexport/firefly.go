@@ -0,0 +1,400 @@
+package budgetexport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const fireflyInitialBalance = "initial balance"
+
+// fireflySplit is a transaction journal, a split of a firefly transaction group. The json is the format of the api and
+// the csv columns of the data export use the same names
+type fireflySplit struct {
+	GroupID         string   `json:"-"`
+	JournalID       string   `json:"transaction_journal_id"`
+	Type            string   `json:"type"`
+	Date            string   `json:"date"`
+	Amount          string   `json:"amount"`
+	CurrencyCode    string   `json:"currency_code"`
+	Description     string   `json:"description"`
+	SourceID        string   `json:"source_id"`
+	SourceName      string   `json:"source_name"`
+	SourceType      string   `json:"source_type"`
+	DestinationID   string   `json:"destination_id"`
+	DestinationName string   `json:"destination_name"`
+	DestinationType string   `json:"destination_type"`
+	CategoryID      string   `json:"category_id"`
+	CategoryName    string   `json:"category_name"`
+	BudgetID        string   `json:"budget_id"`
+	BudgetName      string   `json:"budget_name"`
+	Tags            []string `json:"tags"`
+	Notes           string   `json:"notes"`
+}
+
+type fireflyPage struct {
+	Data []struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Transactions []fireflySplit `json:"transactions"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// ReadFirefly reads a Firefly III transactions export, either the csv of the data export or the json of the
+// transactions api as one page or a list of pages. budgetsPath is the optional csv export of the budgets, their limits
+// are the budgeted amounts. Firefly budgets are the category groups of transactions
+func ReadFirefly(path, budgetsPath, budgetID string) (*Export, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var splits []fireflySplit
+	if filepath.Ext(path) == ".json" {
+		splits, err = fireflyJSON(raw)
+	} else {
+		splits, err = fireflyCSV(bytes.NewReader(raw))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read firefly export %s: %w", path, err)
+	}
+
+	export := fireflyExport(splits, budgetID)
+
+	if budgetsPath != "" {
+		f, err := os.Open(budgetsPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		export.Budgets, err = fireflyBudgets(f, export.Transactions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read firefly budgets %s: %w", budgetsPath, err)
+		}
+	}
+
+	return export, nil
+}
+
+func fireflyJSON(raw []byte) ([]fireflySplit, error) {
+	pages := []fireflyPage{}
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(raw, &pages); err != nil {
+			return nil, err
+		}
+	} else {
+		page := fireflyPage{}
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+
+	splits := []fireflySplit{}
+	for _, page := range pages {
+		for _, group := range page.Data {
+			for _, split := range group.Attributes.Transactions {
+				split.GroupID = group.ID
+				splits = append(splits, split)
+			}
+		}
+	}
+	return splits, nil
+}
+
+// csvRecords reads a csv with a header into maps of column to value
+func csvRecords(r io.Reader) ([]map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	header := rows[0]
+	records := make([]map[string]string, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := make(map[string]string, len(header))
+		for i, column := range header {
+			if i < len(row) {
+				record[strings.TrimSpace(column)] = strings.TrimSpace(row[i])
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func fireflyCSV(r io.Reader) ([]fireflySplit, error) {
+	records, err := csvRecords(r)
+	if err != nil {
+		return nil, err
+	}
+
+	splits := make([]fireflySplit, 0, len(records))
+	for _, record := range records {
+		split := fireflySplit{
+			GroupID:         record["group_id"],
+			JournalID:       record["journal_id"],
+			Type:            record["type"],
+			Date:            record["date"],
+			Amount:          record["amount"],
+			CurrencyCode:    record["currency_code"],
+			Description:     record["description"],
+			SourceName:      record["source_name"],
+			SourceType:      record["source_type"],
+			DestinationName: record["destination_name"],
+			DestinationType: record["destination_type"],
+			CategoryName:    record["category"],
+			BudgetName:      record["budget"],
+			Notes:           record["notes"],
+		}
+		for _, tag := range strings.Split(record["tags"], ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				split.Tags = append(split.Tags, tag)
+			}
+		}
+		splits = append(splits, split)
+	}
+	return splits, nil
+}
+
+// isFireflyAccount is true for the account types with a balance, the other side of a transaction is an expense or
+// revenue account which is the payee
+func isFireflyAccount(accountType string) bool {
+	accountType = strings.ToLower(accountType)
+	for _, t := range []string{"asset", "default", "cash", "loan", "debt", "mortgage"} {
+		if strings.Contains(accountType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func fireflyAccountType(accountType string) string {
+	accountType = strings.ToLower(accountType)
+	switch {
+	case strings.Contains(accountType, "mortgage"):
+		return "mortgage"
+	case strings.Contains(accountType, "loan"):
+		return "personalLoan"
+	case strings.Contains(accountType, "debt"):
+		return "otherDebt"
+	case strings.Contains(accountType, "cash"):
+		return "cash"
+	}
+	return "otherAsset"
+}
+
+// fireflyExport turns journals into transactions from the point of view of their asset or liability account. Transfers
+// between two accounts are a transaction in each. Groups of withdrawals or deposits with more than one journal are
+// split transactions
+func fireflyExport(splits []fireflySplit, budgetID string) *Export {
+	export := &Export{}
+	accounts := map[string]bool{}
+
+	addAccount := func(id, name, accountType string) {
+		if !accounts[id] {
+			accounts[id] = true
+			export.Accounts = append(export.Accounts, Account{ID: id, Name: name, Type: fireflyAccountType(accountType), OnBudget: true})
+		}
+	}
+
+	groups := map[string][]*Transaction{}
+	groupOrder := []string{}
+
+	for _, split := range splits {
+		amount, _ := strconv.ParseFloat(split.Amount, 64)
+		amount = math.Abs(amount)
+
+		sourceID := firstNonEmpty(split.SourceID, split.SourceName)
+		destinationID := firstNonEmpty(split.DestinationID, split.DestinationName)
+		sourceIsAccount := isFireflyAccount(split.SourceType)
+		destinationIsAccount := isFireflyAccount(split.DestinationType)
+
+		base := Transaction{
+			id:            "firefly::" + split.JournalID,
+			budgetID:      budgetID,
+			date:          firstN(split.Date, 10),
+			categoryID:    split.CategoryID,
+			categoryName:  split.CategoryName,
+			categoryGroup: split.BudgetName,
+			notes:         firstNonEmpty(split.Notes, split.Description),
+			currency:      split.CurrencyCode,
+			tags:          append([]string{}, split.Tags...),
+		}
+		if base.categoryID == "" {
+			base.categoryID = split.CategoryName
+		}
+
+		switch {
+		case sourceIsAccount && destinationIsAccount:
+			addAccount(sourceID, split.SourceName, split.SourceType)
+			addAccount(destinationID, split.DestinationName, split.DestinationType)
+
+			from := base
+			from.accountID, from.accountName = sourceID, split.SourceName
+			from.amount = -amount
+			from.transferAccount = split.DestinationName
+			from.payeeName = "Transfer : " + split.DestinationName
+
+			to := base
+			to.id += "::destination"
+			to.accountID, to.accountName = destinationID, split.DestinationName
+			to.amount = amount
+			to.transferAccount = split.SourceName
+			to.payeeName = "Transfer : " + split.SourceName
+
+			export.Transactions = append(export.Transactions, &from, &to)
+			continue
+		case sourceIsAccount:
+			addAccount(sourceID, split.SourceName, split.SourceType)
+			base.accountID, base.accountName = sourceID, split.SourceName
+			base.payeeID, base.payeeName = firstNonEmpty(split.DestinationID, split.DestinationName), split.DestinationName
+			base.amount = -amount
+			if strings.Contains(strings.ToLower(split.DestinationType), fireflyInitialBalance) {
+				base.payeeID, base.payeeName = "", "Starting Balance"
+			}
+		case destinationIsAccount:
+			addAccount(destinationID, split.DestinationName, split.DestinationType)
+			base.accountID, base.accountName = destinationID, split.DestinationName
+			base.payeeID, base.payeeName = firstNonEmpty(split.SourceID, split.SourceName), split.SourceName
+			base.amount = amount
+			if strings.Contains(strings.ToLower(split.SourceType), fireflyInitialBalance) {
+				base.payeeID, base.payeeName = "", "Starting Balance"
+			}
+		default:
+			continue
+		}
+
+		transaction := base
+		if _, ok := groups[split.GroupID]; !ok {
+			groupOrder = append(groupOrder, split.GroupID)
+		}
+		groups[split.GroupID] = append(groups[split.GroupID], &transaction)
+	}
+
+	for _, groupID := range groupOrder {
+		journals := groups[groupID]
+
+		// splits of a group are all in the same account, the parent is the total
+		if len(journals) == 1 || groupID == "" {
+			export.Transactions = append(export.Transactions, journals...)
+			continue
+		}
+
+		parent := *journals[0]
+		parent.id = "firefly::group::" + groupID
+		parent.categoryID, parent.categoryName, parent.categoryGroup = "", "", ""
+		parent.amount = 0
+		for _, journal := range journals {
+			parent.amount += journal.amount
+			parent.tags = mergeTags(parent.tags, journal.tags)
+		}
+		parent.splits = journals
+		export.Transactions = append(export.Transactions, &parent)
+	}
+
+	sort.SliceStable(export.Transactions, func(i, j int) bool {
+		return export.Transactions[i].date < export.Transactions[j].date
+	})
+
+	return export
+}
+
+// fireflyBudgets reads the budget limits of a budgets export. Activity is the spending of the transactions in the
+// budget during the month of the limit
+func fireflyBudgets(r io.Reader, transactions []*Transaction) ([]Budget, error) {
+	records, err := csvRecords(r)
+	if err != nil {
+		return nil, err
+	}
+
+	activity := map[string]map[time.Time]float64{}
+	addActivity := func(t *Transaction) {
+		if t.categoryGroup == "" {
+			return
+		}
+		if activity[t.categoryGroup] == nil {
+			activity[t.categoryGroup] = map[time.Time]float64{}
+		}
+		activity[t.categoryGroup][monthOf(t.date)] += t.amount
+	}
+	for _, t := range transactions {
+		if t.HasSubTransactions() {
+			for _, split := range t.splits {
+				addActivity(split)
+			}
+		} else {
+			addActivity(t)
+		}
+	}
+
+	budgets := map[string]*Budget{}
+	keys := []string{}
+	for _, record := range records {
+		if record["start_date"] == "" || record["amount"] == "" {
+			continue
+		}
+
+		amount, err := strconv.ParseFloat(record["amount"], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount %s for budget %s", record["amount"], record["name"])
+		}
+
+		month := monthOf(firstN(record["start_date"], 10))
+		key := month.Format("2006-01") + record["name"]
+		if _, ok := budgets[key]; !ok {
+			name := record["name"]
+			budgets[key] = &Budget{
+				Month:           month,
+				CategoryID:      firstNonEmpty(record["budget_id"], name),
+				Category:        name,
+				CategoryGroupID: firstNonEmpty(record["budget_id"], name),
+				CategoryGroup:   name,
+				Activity:        activity[name][month],
+			}
+			keys = append(keys, key)
+		}
+		budgets[key].Budgeted += amount
+	}
+
+	rows := make([]Budget, 0, len(keys))
+	for _, key := range keys {
+		b := budgets[key]
+		b.Balance = b.Budgeted + b.Activity
+		rows = append(rows, *b)
+	}
+	return rows, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func firstN(s string, n int) string {
+	if len(s) < n {
+		return s
+	}
+	return s[:n]
+}
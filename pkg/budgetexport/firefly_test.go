@@ -0,0 +1,91 @@
+package budgetexport
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testFireflyCSV = `user_id,group_id,journal_id,type,amount,currency_code,description,date,source_name,source_type,destination_name,destination_type,category,budget,tags,notes
+1,1,1,Opening balance,1000.00,EUR,Initial balance,2024-01-01T00:00:00+01:00,Initial balance for Checking,Initial balance account,Checking,Asset account,,,,
+1,2,2,Withdrawal,-50.00,EUR,Groceries,2024-01-02T00:00:00+01:00,Checking,Asset account,Lidl,Expense account,Groceries,Food,"weekly,market",
+1,2,3,Withdrawal,-30.00,EUR,Soap,2024-01-02T00:00:00+01:00,Checking,Asset account,Lidl,Expense account,Household,Home,,
+1,3,4,Transfer,-200.00,EUR,Savings,2024-01-05T00:00:00+01:00,Checking,Asset account,Savings,Asset account,,,,
+1,4,5,Deposit,2500.00,EUR,Salary,2024-01-25T00:00:00+01:00,Employer,Revenue account,Checking,Asset account,Salary,,,
+`
+
+const testFireflyJSON = `[{"data":[{"type":"transactions","id":"7","attributes":{"transactions":[
+	{"transaction_journal_id":"9","type":"withdrawal","date":"2024-03-01T00:00:00+00:00","amount":"12.50","currency_code":"USD","description":"Lunch","source_id":"1","source_name":"Checking","source_type":"Asset account","destination_id":"20","destination_name":"Cafe","destination_type":"Expense account","category_id":"3","category_name":"Eating out","budget_name":"Food","tags":["work"],"notes":null}
+]}}]}]`
+
+const testFireflyBudgetsCSV = `user_id,budget_id,name,active,order,start_date,end_date,currency_code,amount
+1,1,Food,1,1,2024-01-01,2024-01-31,EUR,300.00
+1,2,Home,1,2,2024-01-01,2024-01-31,EUR,20.00
+`
+
+func TestReadFireflyCSV(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "transactions.csv")
+	budgetsPath := filepath.Join(dir, "budgets.csv")
+	require.NoError(t, os.WriteFile(path, []byte(testFireflyCSV), 0o644))
+	require.NoError(t, os.WriteFile(budgetsPath, []byte(testFireflyBudgetsCSV), 0o644))
+
+	export, err := ReadFirefly(path, budgetsPath, "firefly::home")
+	require.NoError(t, err)
+
+	assert.Equal(t, []Account{{ID: "Checking", Name: "Checking", Type: "otherAsset", OnBudget: true}, {ID: "Savings", Name: "Savings", Type: "otherAsset", OnBudget: true}}, export.Accounts)
+	require.Len(t, export.Transactions, 5)
+
+	opening := export.Transactions[0]
+	assert.Equal(t, "Starting Balance", opening.Payee())
+	assert.Equal(t, 1000.0, opening.Amount())
+	assert.Equal(t, "EUR", opening.Currency())
+
+	split := export.Transactions[1]
+	assert.Equal(t, "firefly::group::2", split.IndexKey())
+	assert.Equal(t, -80.0, split.Amount())
+	assert.Equal(t, "Lidl", split.Payee())
+	assert.Equal(t, []string{"weekly", "market"}, split.Tags())
+	subs := split.SubTransactions()
+	require.Len(t, subs, 2)
+	assert.Equal(t, "Household", subs[1].Category())
+	assert.Equal(t, "Home", subs[1].CategoryGroup())
+
+	from, to := export.Transactions[2], export.Transactions[3]
+	assert.Equal(t, -200.0, from.Amount())
+	assert.Equal(t, "Savings", from.TransferAccount())
+	assert.Equal(t, 200.0, to.Amount())
+	assert.Equal(t, "Savings", to.Account())
+	assert.Equal(t, financialimporter.Transfer, to.TransactionType())
+
+	assert.Equal(t, financialimporter.Income, export.Transactions[4].TransactionType())
+
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []Budget{
+		{Month: jan, CategoryID: "1", Category: "Food", CategoryGroupID: "1", CategoryGroup: "Food", Budgeted: 300, Activity: -50, Balance: 250},
+		{Month: jan, CategoryID: "2", Category: "Home", CategoryGroupID: "2", CategoryGroup: "Home", Budgeted: 20, Activity: -30, Balance: -10},
+	}, export.Budgets)
+}
+
+func TestReadFireflyJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.json")
+	require.NoError(t, os.WriteFile(path, []byte(testFireflyJSON), 0o644))
+
+	export, err := ReadFirefly(path, "", "firefly::work")
+	require.NoError(t, err)
+
+	require.Len(t, export.Transactions, 1)
+	lunch := export.Transactions[0]
+	assert.Equal(t, "firefly::9", lunch.IndexKey())
+	assert.Equal(t, "1", lunch.AccountID())
+	assert.Equal(t, "20", lunch.PayeeID())
+	assert.Equal(t, -12.5, lunch.Amount())
+	assert.Equal(t, "Eating out", lunch.Category())
+	assert.Equal(t, []string{"work"}, lunch.Tags())
+	assert.Equal(t, "Lunch", lunch.Memo())
+}
@@ -0,0 +1,182 @@
+package budgetexport
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	splitwiseAccount         = "Splitwise"
+	splitwisePaymentCategory = "Payment"
+	splitwiseTotalBalance    = "Total balance"
+)
+
+// ReadSplitwise reads the csv export of a Splitwise group. Each expense is a transaction of our share tagged with the
+// group, payments aren't transactions. Every other person gets an account with what they owe us, negative when we owe
+// them.
+//
+// The export has the net change of each person's balance per expense, what they paid minus their share. When our
+// change is positive we paid the whole cost and everyone with a negative change owes us their share, otherwise we owe
+// our share to the person who paid the most
+func ReadSplitwise(path, group, me, budgetID string) (*Export, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csvRecords(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read splitwise export %s: %w", path, err)
+	}
+
+	return splitwiseExport(records, group, me, budgetID)
+}
+
+func splitwiseExport(records []map[string]string, group, me, budgetID string) (*Export, error) {
+	export := &Export{}
+	if len(records) == 0 {
+		return export, nil
+	}
+
+	people := splitwisePeople(records[0])
+	if !contains(people, me) {
+		return nil, fmt.Errorf("%s isn't a person in the splitwise export, the people are %s", me, strings.Join(people, ", "))
+	}
+
+	for _, person := range people {
+		if person != me {
+			export.Accounts = append(export.Accounts, Account{ID: splitwiseAccountID(group, person), Name: splitwiseAccount + ": " + person, Type: "otherAsset"})
+		}
+	}
+
+	seen := map[string]int{}
+	for _, record := range records {
+		// the blank line and total balance at the end
+		date, err := time.Parse("2006-01-02", firstN(record["Date"], 10))
+		if err != nil || record["Description"] == splitwiseTotalBalance {
+			continue
+		}
+
+		changes := map[string]float64{}
+		for _, person := range people {
+			changes[person], err = strconv.ParseFloat(record[person], 64)
+			if err != nil && record[person] != "" {
+				return nil, fmt.Errorf("invalid amount %s for %s on %s", record[person], person, record["Date"])
+			}
+		}
+
+		cost, err := strconv.ParseFloat(record["Cost"], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cost %s on %s", record["Cost"], record["Date"])
+		}
+
+		day := date.Format("2006-01-02")
+		currency := record["Currency"]
+		adjust := func(person string, amount float64) {
+			if person != me && amount != 0 {
+				export.Adjustments = append(export.Adjustments, Adjustment{AccountID: splitwiseAccountID(group, person), Date: day, Amount: round(amount), Currency: currency})
+			}
+		}
+
+		if record["Category"] == splitwisePaymentCategory {
+			// a payment to us lowers what the payer owes us, a payment from us lowers what we owe
+			if changes[me] != 0 {
+				for _, person := range people {
+					adjust(person, -changes[person])
+				}
+			}
+			continue
+		}
+
+		var share float64
+		if changes[me] > 0 {
+			share = cost - changes[me]
+			for _, person := range people {
+				if changes[person] < 0 {
+					adjust(person, -changes[person])
+				}
+			}
+		} else {
+			share = -changes[me]
+			adjust(splitwisePayer(people, changes), changes[me])
+		}
+
+		if share == 0 {
+			continue
+		}
+
+		key := splitwiseKey(group, record)
+		seen[key]++
+		if seen[key] > 1 {
+			key = fmt.Sprintf("%s-%d", key, seen[key])
+		}
+
+		export.Transactions = append(export.Transactions, &Transaction{
+			id:            key,
+			budgetID:      budgetID,
+			date:          day,
+			accountID:     splitwiseAccountID(group, ""),
+			accountName:   splitwiseAccount,
+			payeeName:     record["Description"],
+			categoryID:    record["Category"],
+			categoryName:  record["Category"],
+			categoryGroup: group,
+			amount:        -round(share),
+			currency:      currency,
+			tags:          []string{group},
+		})
+	}
+
+	return export, nil
+}
+
+// splitwisePeople are the columns after Date, Description, Category, Cost and Currency
+func splitwisePeople(record map[string]string) []string {
+	people := []string{}
+	for column := range record {
+		switch column {
+		case "Date", "Description", "Category", "Cost", "Currency":
+		default:
+			people = append(people, column)
+		}
+	}
+	sort.Strings(people)
+	return people
+}
+
+// splitwisePayer is the person who paid the most of an expense
+func splitwisePayer(people []string, changes map[string]float64) string {
+	payer := ""
+	for _, person := range people {
+		if payer == "" || changes[person] > changes[payer] {
+			payer = person
+		}
+	}
+	return payer
+}
+
+func splitwiseAccountID(group, person string) string {
+	if person == "" {
+		return "splitwise::" + group
+	}
+	return "splitwise::" + group + "::" + person
+}
+
+// splitwiseKey hashes an expense, the export has no ids
+func splitwiseKey(group string, record map[string]string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s|%s|%s|%s|%s|%s", group, record["Date"], record["Description"], record["Category"], record["Cost"], record["Currency"])
+	return "splitwise::" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,57 @@
+package budgetexport
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSplitwiseCSV = `Date,Description,Category,Cost,Currency,Alice,Bob,Me
+2024-02-01,Groceries,Groceries,90.00,CAD,-30.00,-30.00,60.00
+2024-02-03,Dinner,Dining out,60.00,CAD,40.00,-20.00,-20.00
+2024-02-04,Movie,Entertainment,20.00,CAD,10.00,-10.00,0.00
+2024-02-10,Alice paid Me,Payment,10.00,CAD,10.00,0.00,-10.00
+
+2024-02-10,Total balance, , ,CAD,30.00,-60.00,30.00
+`
+
+func TestReadSplitwise(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cabin.csv")
+	require.NoError(t, os.WriteFile(path, []byte(testSplitwiseCSV), 0o644))
+
+	export, err := ReadSplitwise(path, "cabin", "Me", "splitwise::cabin")
+	require.NoError(t, err)
+
+	assert.Equal(t, []Account{
+		{ID: "splitwise::cabin::Alice", Name: "Splitwise: Alice", Type: "otherAsset"},
+		{ID: "splitwise::cabin::Bob", Name: "Splitwise: Bob", Type: "otherAsset"},
+	}, export.Accounts)
+
+	// the movie doesn't involve us
+	require.Len(t, export.Transactions, 2)
+
+	groceries := export.Transactions[0]
+	assert.Equal(t, -30.0, groceries.Amount())
+	assert.Equal(t, "Groceries", groceries.Payee())
+	assert.Equal(t, "cabin", groceries.CategoryGroup())
+	assert.Equal(t, []string{"cabin"}, groceries.Tags())
+	assert.Equal(t, "CAD", groceries.Currency())
+	assert.Equal(t, "splitwise::cabin", groceries.AccountID())
+
+	dinner := export.Transactions[1]
+	assert.Equal(t, -20.0, dinner.Amount())
+	assert.Equal(t, "2024-02-03", dinner.Date())
+
+	assert.Equal(t, []Adjustment{
+		{AccountID: "splitwise::cabin::Alice", Date: "2024-02-01", Amount: 30, Currency: "CAD"},
+		{AccountID: "splitwise::cabin::Bob", Date: "2024-02-01", Amount: 30, Currency: "CAD"},
+		{AccountID: "splitwise::cabin::Alice", Date: "2024-02-03", Amount: -20, Currency: "CAD"},
+		{AccountID: "splitwise::cabin::Alice", Date: "2024-02-10", Amount: -10, Currency: "CAD"},
+	}, export.Adjustments)
+
+	_, err = ReadSplitwise(path, "cabin", "Carol", "splitwise::cabin")
+	assert.Error(t, err)
+}
@@ -47,6 +47,22 @@ func CurrentExchangeRateAPISecrets() *ExchangerateAPISecrets {
 	return &secrets.ExchangerateAPI
 }
 
+func CurrentJournalConfig() *JournalConfig {
+	return &config.Journal
+}
+
+func CurrentExportFiles() []ExportFile {
+	return config.Exports
+}
+
+func CurrentHTTPSources() []HTTPSource {
+	return config.Sources
+}
+
+func CurrentHTTPSecrets() *HTTPSecrets {
+	return &secrets.HTTP
+}
+
 func CurrentAirtableConfig() *AirtableConfig {
 	return &config.Airtable
 }
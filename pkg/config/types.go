@@ -3,6 +3,31 @@ package config
 type Config struct {
 	Ynab     YnabConfig
 	Airtable AirtableConfig
+	Journal  JournalConfig
+	// Budgeting app exports and http sources, imported by the journal and ynab tasks like the journal files
+	Exports []ExportFile `json:"exports"`
+	Sources []HTTPSource `json:"sources"`
+	// Table every run is recorded in, defaults to import_runs
+	ImportRunsTable string `json:"importRunsTable"`
+	// Sinks each dataset is written to keyed by dataset: transactions, accounts, budgets, networth, http or airtable.
+	// Datasets default to a single postgres sink, airtable defaults to influx
+	Sinks map[string][]SinkConfig `json:"sinks"`
+}
+
+// SinkConfig is a destination for a dataset
+type SinkConfig struct {
+	// postgres, influx, influx2 or sqlite
+	Type string `json:"type"`
+	// Database to write to, defaults to the database of the importer
+	Database string `json:"database"`
+	// Path of the sqlite file, defaults to the sqlite file of the importer
+	Path string `json:"path"`
+	// InfluxDB 2.x settings. URL defaults to the influx endpoint secret and Bucket to the database of the importer.
+	// The bucket is created if it doesn't exist, RetentionDays of 0 keeps data forever
+	URL           string `json:"url"`
+	Org           string `json:"org"`
+	Bucket        string `json:"bucket"`
+	RetentionDays int    `json:"retentionDays"`
 }
 
 type Secrets struct {
@@ -11,6 +36,7 @@ type Secrets struct {
 	Influx          InfluxSecrets
 	SQL             SqlSecrets
 	ExchangerateAPI ExchangerateAPISecrets `json:"exchangeratesapi"`
+	HTTP            HTTPSecrets            `json:"http"`
 
 	// Altternative to Sql struct, also specifies table name which will be used for all importer
 	// designed to be used with heroku env variable
@@ -28,15 +54,51 @@ type YnabConfig struct {
 	SQL             struct {
 		YnabDatabase      string
 		TransactionsTable string
-		AccountsTable     string
-		BudgetsTable      string
-		NetworthTable     string
+		// Changes to transactions between runs, defaults to transactions_history
+		TransactionsHistoryTable string
+		AccountsTable            string
+		BudgetsTable             string
+		NetworthTable            string
+		LoansTable               string
+		LoanPaymentsTable        string
+		LoanScheduleTable        string
+		// Also write a daily copy of every budget row to BudgetSnapshotsTable, defaults to budget_snapshots
+		BudgetSnapshots      bool
+		BudgetSnapshotsTable string
+		// Defaults to scheduled_transactions and cash_flow_forecast
+		ScheduledTransactionsTable string
+		CashFlowForecastTable      string
+		// Defaults to categories, category_groups, payees and payee_locations
+		CategoriesTable     string
+		CategoryGroupsTable string
+		PayeesTable         string
+		PayeeLocationsTable string
+		// Goal progress per month per category, defaults to goal_progress. Goals at risk this month
+		// are in the <table>_at_risk view
+		GoalProgressTable string
 		BatchSize         int
+		// Write to a SQLite file instead of postgres, for running without a postgres server. Only transactions,
+		// accounts, budgets, net worth and last seen are written
+		SQLitePath string
+		// How account and net worth balances are stored, "daily" (default) writes a row per day
+		// and "changes" only writes a row when the balance changes. Both are readable daily
+		// through the <table>_daily views
+		AccountsStorage string
+		// Roll up history older than RollupAfterDays to one row per "weekly" or "monthly" period
+		Rollup          string
+		RollupAfterDays int
 	}
 	Tags struct {
 		Enabled    bool
 		RegexMatch string
 	}
+	Loans []Loan
+	// Number of days the cash flow forecast covers, defaults to 90
+	ForecastDays int
+	// Number of budgets imported at the same time, defaults to 4
+	Concurrency int
+	// Maximum YNAB API requests per hour shared by all budgets, defaults to 200
+	RateLimit int
 }
 
 type Budget struct {
@@ -58,6 +120,23 @@ type CalculatedField struct {
 	Inverted      bool
 }
 
+// Loan describes a mortgage or loan tracked by a YNAB account. YNAB only knows the
+// balance, the rest of the terms are needed to model the amortization schedule
+type Loan struct {
+	Name string `json:"name"`
+	// Name of the YNAB account that holds the loan balance
+	Account string `json:"account"`
+	// Budget the account belongs to, if empty all budgets are searched
+	Budget    string  `json:"budget"`
+	Principal float64 `json:"principal"`
+	// Annual interest rate as a percent, ie 4.5 for 4.5%
+	Rate float64 `json:"rate"`
+	// Term of the loan in months
+	TermMonths int `json:"termMonths"`
+	// Date of the loan start in 01-02-2006 format, first payment is due a month later
+	StartDate string `json:"startDate"`
+}
+
 type CurrencyConversion map[string]float64
 
 type YnabSecrets struct {
@@ -68,6 +147,8 @@ type InfluxSecrets struct {
 	InfluxEndpoint string
 	InfluxUsername string
 	InfluxPassword string
+	// API token for InfluxDB 2.x sinks
+	InfluxToken string `env:"INFLUX_TOKEN"`
 }
 
 type SqlSecrets struct {
@@ -80,6 +161,140 @@ type ExchangerateAPISecrets struct {
 	AccessKey string `json:"accessKey" env:"EXCHANGE_RATES_API_ACCESS_KEY"`
 }
 
+///////////////////////////////////////////////////////////////////////////////////////
+// Journal
+///////////////////////////////////////////////////////////////////////////////////////
+
+// JournalConfig lists beancount and hledger files imported into the ynab tables. The journal task imports only these,
+// the exports and the sources, the ynab task imports them alongside the budgets so both end up in the same tables and
+// net worth
+type JournalConfig struct {
+	UpdateFrequency string        `json:"updateFrequency"`
+	Files           []JournalFile `json:"files"`
+}
+
+type JournalFile struct {
+	// Name the accounts of the file are grouped under, like a budget name. Defaults to the file name
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// beancount or hledger, defaults to beancount for .beancount and .bean files and hledger otherwise
+	Format string `json:"format"`
+	// Currency of amounts in $ or without a commodity, defaults to USD
+	Currency string `json:"currency"`
+	// Commodities that are currencies besides Currency and the ynab currencies. Postings in other commodities, like
+	// stocks, are skipped
+	Currencies []string `json:"currencies"`
+	// Date to import transactions after in 01-02-2006 format
+	ImportAfterDate  string `json:"importAfterDate"`
+	CalculatedFields []CalculatedField
+}
+
+///////////////////////////////////////////////////////////////////////////////////////
+// Exports
+///////////////////////////////////////////////////////////////////////////////////////
+
+// ExportFile is an export of Actual Budget or Firefly III, imported like a ynab budget
+type ExportFile struct {
+	// Name of the budget, defaults to the file name
+	Name string `json:"name"`
+	// actual, firefly or splitwise
+	Type string `json:"type"`
+	// The db.sqlite of an Actual Budget export, the transactions csv or json of a Firefly III export or the csv export
+	// of a Splitwise group
+	Path string `json:"path"`
+	// Optional Firefly III budgets csv export for the budgets table
+	BudgetsPath string `json:"budgetsPath"`
+	// Splitwise group transactions are tagged with, defaults to the name
+	Group string `json:"group"`
+	// Our column in the Splitwise export
+	Me string `json:"me"`
+	// Currency of the budget, Firefly III and Splitwise transactions have their own. Defaults to USD
+	Currency         string `json:"currency"`
+	ImportAfterDate  string `json:"importAfterDate"`
+	CalculatedFields []CalculatedField
+}
+
+///////////////////////////////////////////////////////////////////////////////////////
+// Sources
+///////////////////////////////////////////////////////////////////////////////////////
+
+// HTTPSource is a json api or file mapped to transactions or to measurement rows of the http dataset
+type HTTPSource struct {
+	Name string `json:"name"`
+	// http-json
+	Type string `json:"type"`
+	// http(s) url or path of a json file
+	URL string `json:"url"`
+	// Name of the secret in the http secrets sent in AuthHeader, which defaults to Authorization
+	AuthSecret string `json:"authSecret"`
+	AuthHeader string `json:"authHeader"`
+	// Path of the list of items in a response, defaults to the response
+	Items      string         `json:"items"`
+	Pagination HTTPPagination `json:"pagination"`
+	// Query parameter sent with the largest SinceField of the items of the last run, for apis that filter on it.
+	// Transactions are rebuilt every run so only measurement sources are incremental
+	SinceParam string `json:"sinceParam"`
+	SinceField string `json:"sinceField"`
+	// One of Transactions or Measurement
+	Transactions *HTTPTransactionMapping `json:"transactions"`
+	Measurement  *HTTPMeasurementMapping `json:"measurement"`
+	// Currency of transactions without a currency, defaults to USD
+	Currency         string `json:"currency"`
+	ImportAfterDate  string `json:"importAfterDate"`
+	CalculatedFields []CalculatedField
+}
+
+// HTTPPagination is how the next page of a source is requested
+type HTTPPagination struct {
+	// cursor, page or link, a single request when empty. link follows the rel="next" url of the Link header
+	Type string `json:"type"`
+	// Query parameter of the cursor or page number, defaults to cursor and page
+	Param string `json:"param"`
+	// Path of the next cursor in a response, the last cursor is where the next run of a measurement source starts
+	Next string `json:"next"`
+	// Optional path of a bool that is false on the last page
+	HasMore string `json:"hasMore"`
+	// First page number, defaults to 1
+	Start int `json:"start"`
+}
+
+// HTTPTransactionMapping has the paths of the transaction fields in an item. Paths look like $.merchant.name or
+// lines[0].amount
+type HTTPTransactionMapping struct {
+	ID            string `json:"id"`
+	Date          string `json:"date"`
+	Amount        string `json:"amount"`
+	Payee         string `json:"payee"`
+	Category      string `json:"category"`
+	CategoryGroup string `json:"categoryGroup"`
+	Memo          string `json:"memo"`
+	Account       string `json:"account"`
+	Currency      string `json:"currency"`
+	// A list of strings or a comma separated string
+	Tags string `json:"tags"`
+	// Go layout of dates, defaults to 2006-01-02 and RFC 3339
+	DateLayout string `json:"dateLayout"`
+	// Amounts are multiplied by it, ie 0.01 for cents or -1 when spending is positive. Defaults to 1
+	AmountMultiplier float64 `json:"amountMultiplier"`
+}
+
+// HTTPMeasurementMapping maps items to rows of the http dataset, written to the table or measurement Name
+type HTTPMeasurementMapping struct {
+	Name string `json:"name"`
+	// Path of the id of an item
+	Key        string `json:"key"`
+	Time       string `json:"time"`
+	TimeLayout string `json:"timeLayout"`
+	// Column names to paths
+	Tags   map[string]string `json:"tags"`
+	Fields map[string]string `json:"fields"`
+}
+
+type HTTPSecrets struct {
+	// Values of the auth headers of the http sources keyed by AuthSecret
+	Headers map[string]string `json:"headers"`
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////
 // Airtable
 ///////////////////////////////////////////////////////////////////////////////////////
@@ -88,6 +303,28 @@ type AirtableConfig struct {
 	UpdateFrequency  string               `json:"updateFrequency"`
 	AirtableDatabase string               `json:"airtableDatabase"`
 	AirtableBases    []AirtableBaseConfig `json:"airtableBases"`
+	// Postgres table with the last sync of every base and table, defaults to airtable_sync. Without postgres every
+	// run rewrites the tables
+	SyncTable string `json:"syncTable"`
+	// Postgres query results upserted into airtable tables by the airtable-writeback task
+	WriteBacks []AirtableWriteBack `json:"writeBacks"`
+	// Defaults to every hour
+	WriteBackFrequency string `json:"writeBackFrequency"`
+}
+
+// AirtableWriteBack upserts every row of a query into an airtable table, matching records on KeyField. Records without
+// a row are left in the table
+type AirtableWriteBack struct {
+	Name   string `json:"name"`
+	BaseID string `json:"airtableBaseId"`
+	Table  string `json:"table"`
+	// Query run against the ynab database, like the monthly expenses of every category group from the transactions
+	// table
+	Query string `json:"query"`
+	// Field the records are matched on, it needs a column in every row
+	KeyField string `json:"keyField"`
+	// Airtable field of a column by column name, columns without one are written to the field of the same name
+	Fields map[string]string `json:"fields"`
 }
 
 type AirtableBaseConfig struct {
@@ -95,12 +332,68 @@ type AirtableBaseConfig struct {
 	AirtableTableName string
 	InfluxMeasurement string
 	Fields            AirtableFieldsConfig
+	// Optional view records are listed from, it can filter the records
+	View string `json:"view"`
+	// Last modified time field the incremental sync filters on, defaults to LAST_MODIFIED_TIME() of the record
+	LastModifiedField string `json:"lastModifiedField"`
+	// Field with the timestamp of a record, defaults to Date. TimestampLayout is a go layout, dates and RFC 3339 times
+	// are read without one
+	TimestampField  string `json:"timestampField"`
+	TimestampLayout string `json:"timestampLayout"`
+	// Zone of timestamps without one, from a field with an IANA zone or an offset in hours, or a fixed IANA Timezone.
+	// Without either timestamps are UTC and the Timezone Offset field is only used for times from midnight
+	TimezoneField string `json:"timezoneField"`
+	Timezone      string `json:"timezone"`
+	// Precision of the written timestamps, defaults to h
+	Precision string `json:"precision"`
+	// Records with a missing or invalid timestamp are skipped, logged and skipped, or fail the run: skip, log or fail.
+	// Defaults to fail
+	MissingDate string `json:"missingDate"`
+	// Steps applied in order to the tags and fields of every record before it is written
+	Transforms []AirtableTransform `json:"transforms"`
+}
+
+// AirtableTransform is a step of the transforms of a base. Field is the tag or field the step changes
+type AirtableTransform struct {
+	Field string `json:"field"`
+	// rename to To, cast to Cast, unit multiplies by Factor and adds Offset, map replaces values found in Values, tag
+	// and field move the value between tags and fields, extract keeps the first group of Pattern, default sets Default
+	// when there is no value and compute sets the result of Expression
+	Type string `json:"type"`
+	To   string `json:"to"`
+	// int, float, bool, string or duration. Durations are minutes from seconds, like airtable duration fields, or from
+	// go durations and h:mm:ss strings
+	Cast    string                 `json:"cast"`
+	Factor  float64                `json:"factor"`
+	Offset  float64                `json:"offset"`
+	Values  map[string]interface{} `json:"values"`
+	Pattern string                 `json:"pattern"`
+	Default interface{}            `json:"default"`
+	// Arithmetic over other fields written as {Field Name}, like ({Distance} / 1000) / ({Duration} / 60)
+	Expression string `json:"expression"`
 }
 
 type AirtableFieldsConfig struct {
 	ConvertToTimeFromMidnightList []string
 	ConvertBoolToInt              bool
 	Blacklist                     []string
+	// Multi-select fields written as a tag per option named <field>:<option>, or as a bool field per option named
+	// <field> <option>. Other lists of strings, like lookups, are written as one comma separated tag
+	MultiSelectTags     []string `json:"multiSelectTags"`
+	MultiSelectBooleans []string `json:"multiSelectBooleans"`
+	// Linked record fields written as a tag of the display field of the linked records
+	LinkedRecords []AirtableLinkedRecords `json:"linkedRecords"`
+	// How lists of numbers from rollups and lookups are flattened by field: sum, avg, min, max or first. Defaults to
+	// sum. Attachment fields are written as the number of attachments
+	RollupAggregates map[string]string `json:"rollupAggregates"`
+}
+
+type AirtableLinkedRecords struct {
+	Field string `json:"field"`
+	// Table the records are linked to, in the same base
+	Table string `json:"table"`
+	// Field of the linked records written instead of their ids, defaults to Name
+	DisplayField string `json:"displayField"`
 }
 
 type AirtableSecrets struct {
@@ -0,0 +1,316 @@
+package export
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/parquet-go/parquet-go"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/schema"
+)
+
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type Options struct {
+	// csv, jsonl or parquet
+	Format string
+	// Only rows with a time in [From, To) are exported, zero times are unbounded
+	From time.Time
+	To   time.Time
+	// Files are written to Dir/<dataset>/month=YYYY-MM/<dataset>.<format>, files of earlier exports for months in the
+	// range are removed first
+	Dir string
+}
+
+// Export reads the rows of dataset from db and writes a file per month of the dataset's time column. Returns the paths
+// of the written files
+func Export(ctx context.Context, db *bun.DB, dataset sinks.Dataset, opts Options) ([]string, error) {
+	writeFile, ok := writers[opts.Format]
+	if !ok {
+		return nil, fmt.Errorf("unknown export format %s", opts.Format)
+	}
+
+	table := db.Dialect().Tables().Get(reflect.TypeOf(dataset.Model).Elem())
+	timeField, ok := table.FieldMap[dataset.TimeColumn]
+	if !ok {
+		return nil, fmt.Errorf("%s has no %s column", dataset.Table, dataset.TimeColumn)
+	}
+
+	rows := reflect.New(reflect.SliceOf(table.Type))
+	q := db.NewSelect().
+		Model(rows.Interface()).
+		ModelTableExpr("? AS ?", bun.Ident(dataset.Table), bun.Ident(table.Alias)).
+		Order(dataset.TimeColumn)
+	if !opts.From.IsZero() {
+		q = q.Where("? >= ?", bun.Ident(dataset.TimeColumn), opts.From)
+	}
+	if !opts.To.IsZero() {
+		q = q.Where("? < ?", bun.Ident(dataset.TimeColumn), opts.To)
+	}
+
+	err := q.Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dataset.Table, err)
+	}
+
+	err = removeMonths(dataset, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// rows are ordered by time so every month is one run of rows
+	files := []string{}
+	slice := rows.Elem()
+	for start := 0; start < slice.Len(); {
+		month := monthOf(timeField, slice.Index(start))
+		end := start + 1
+		for end < slice.Len() && monthOf(timeField, slice.Index(end)) == month {
+			end++
+		}
+
+		dir := filepath.Join(opts.Dir, dataset.Name, "month="+month)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return files, err
+		}
+
+		path := filepath.Join(dir, dataset.Name+"."+opts.Format)
+		if err := writeFile(path, table.Fields, slice.Slice(start, end)); err != nil {
+			return files, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+
+		files = append(files, path)
+		start = end
+	}
+
+	return files, nil
+}
+
+// removeMonths removes the files an earlier export in the same format wrote for the months of the range, so months
+// without rows anymore don't keep their old file
+func removeMonths(dataset sinks.Dataset, opts Options) error {
+	paths, err := filepath.Glob(filepath.Join(opts.Dir, dataset.Name, "month=*", dataset.Name+"."+opts.Format))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		month, err := time.Parse("2006-01", strings.TrimPrefix(filepath.Base(dir), "month="))
+		if err != nil {
+			continue
+		}
+
+		if (!opts.To.IsZero() && !month.Before(opts.To)) || (!opts.From.IsZero() && !month.AddDate(0, 1, 0).After(opts.From)) {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		// other formats may still be in the month
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+			os.Remove(dir)
+		}
+	}
+
+	return nil
+}
+
+func monthOf(field *schema.Field, row reflect.Value) string {
+	t, _ := field.Value(row).Interface().(time.Time)
+	return t.UTC().Format("2006-01")
+}
+
+type writeFunc func(path string, fields []*schema.Field, rows reflect.Value) error
+
+var writers = map[string]writeFunc{
+	FormatCSV:     writeCSV,
+	FormatJSONL:   writeJSONL,
+	FormatParquet: writeParquet,
+}
+
+func writeCSV(path string, fields []*schema.Field, rows reflect.Value) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+
+	header := make([]string, len(fields))
+	for i, field := range fields {
+		header[i] = field.Name
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(fields))
+	for i := 0; i < rows.Len(); i++ {
+		for j, field := range fields {
+			record[j], err = csvValue(field.Value(rows.Index(i)).Interface())
+			if err != nil {
+				return err
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// csvValue formats times as RFC3339 and arrays and maps as json
+func csvValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case time.Time:
+		if v.IsZero() {
+			return "", nil
+		}
+		return v.Format(time.RFC3339), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int, int64, bool:
+		return fmt.Sprint(v), nil
+	}
+
+	raw, err := json.Marshal(v)
+	return string(raw), err
+}
+
+func writeJSONL(path string, fields []*schema.Field, rows reflect.Value) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := 0; i < rows.Len(); i++ {
+		record := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			record[field.Name] = field.Value(rows.Index(i)).Interface()
+		}
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func writeParquet(path string, fields []*schema.Field, rows reflect.Value) error {
+	group := parquet.Group{}
+	for _, field := range fields {
+		group[field.Name] = parquetNode(field)
+	}
+	parquetSchema := parquet.NewSchema(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), group)
+
+	// columns of a group are sorted by name
+	columns := slices.Clone(fields)
+	slices.SortFunc(columns, func(a, b *schema.Field) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	parquetRows := make([]parquet.Row, rows.Len())
+	for i := range parquetRows {
+		row := make(parquet.Row, len(columns))
+		for j, field := range columns {
+			v, err := parquetValue(field.Value(rows.Index(i)).Interface())
+			if err != nil {
+				return err
+			}
+			row[j] = v.Level(0, 0, j)
+		}
+		parquetRows[i] = row
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := parquet.NewWriter(f, parquetSchema)
+	if _, err := w.WriteRows(parquetRows); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// parquetNode maps a column to a parquet type, arrays and maps are json
+func parquetNode(field *schema.Field) parquet.Node {
+	if field.IndirectType == timeType {
+		return parquet.Timestamp(parquet.Millisecond)
+	}
+
+	switch field.IndirectType.Kind() {
+	case reflect.Bool:
+		return parquet.Leaf(parquet.BooleanType)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return parquet.Int(64)
+	case reflect.Float32, reflect.Float64:
+		return parquet.Leaf(parquet.DoubleType)
+	case reflect.String:
+		return parquet.String()
+	}
+
+	return parquet.JSON()
+}
+
+func parquetValue(v interface{}) (parquet.Value, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return parquet.Int64Value(v.UnixMilli()), nil
+	case bool:
+		return parquet.BooleanValue(v), nil
+	case string:
+		return parquet.ByteArrayValue([]byte(v)), nil
+	}
+
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return parquet.Int64Value(rv.Int()), nil
+	case reflect.Float32, reflect.Float64:
+		return parquet.DoubleValue(rv.Float()), nil
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return parquet.Value{}, err
+	}
+	return parquet.ByteArrayValue(raw), nil
+}
@@ -0,0 +1,123 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+)
+
+type testTransaction struct {
+	bun.BaseModel   `bun:"table:transactions"`
+	ID              int64  `bun:",pk,autoincrement"`
+	Key             string `bun:",pk,unique"`
+	TransactionDate time.Time
+	Payee           string
+	Amount          float64
+	Tags            []string `bun:",array"`
+}
+
+func TestExportPartitionsByMonth(t *testing.T) {
+	ctx := context.Background()
+	db, err := sinks.OpenSQLite(filepath.Join(t.TempDir(), "selfops.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	dataset := sinks.Dataset{Name: "transactions", Table: "transactions", Model: (*testTransaction)(nil), KeyColumn: "key", TimeColumn: "transaction_date"}
+	sink := sinks.NewSQLiteSink(db, 0)
+	require.NoError(t, sink.Migrate(ctx, dataset))
+
+	rows := []testTransaction{
+		{Key: "a", TransactionDate: time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), Payee: "Cafe", Amount: -4.5, Tags: []string{"food"}},
+		{Key: "b", TransactionDate: time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC), Payee: "Grocer", Amount: -50},
+		{Key: "c", TransactionDate: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), Payee: "Employer", Amount: 2000},
+		{Key: "d", TransactionDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Payee: "Landlord", Amount: -1200},
+	}
+	require.NoError(t, sink.Upsert(ctx, dataset, &rows))
+
+	dir := t.TempDir()
+	opts := Options{From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Dir: dir}
+
+	for _, format := range []string{FormatCSV, FormatJSONL, FormatParquet} {
+		opts.Format = format
+		files, err := Export(ctx, db, dataset, opts)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			filepath.Join(dir, "transactions", "month=2024-01", "transactions."+format),
+			filepath.Join(dir, "transactions", "month=2024-02", "transactions."+format),
+		}, files)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "transactions", "month=2024-01", "transactions.csv"))
+	require.NoError(t, err)
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id", "key", "transaction_date", "payee", "amount", "tags"}, records[0])
+	assert.Equal(t, []string{"1", "a", "2024-01-05T00:00:00Z", "Cafe", "-4.5", `["food"]`}, records[1])
+	assert.Len(t, records, 3)
+
+	jsonl, err := os.Open(filepath.Join(dir, "transactions", "month=2024-01", "transactions.jsonl"))
+	require.NoError(t, err)
+	defer jsonl.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(jsonl); scanner.Scan(); {
+		lines++
+	}
+	assert.Equal(t, 2, lines)
+
+	pf, err := os.Open(filepath.Join(dir, "transactions", "month=2024-01", "transactions.parquet"))
+	require.NoError(t, err)
+	defer pf.Close()
+	stat, err := pf.Stat()
+	require.NoError(t, err)
+	file, err := parquet.OpenFile(pf, stat.Size())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), file.NumRows())
+	_, ok := file.Schema().Lookup("payee")
+	assert.True(t, ok)
+}
+
+func TestExportRemovesStaleMonths(t *testing.T) {
+	ctx := context.Background()
+	db, err := sinks.OpenSQLite(filepath.Join(t.TempDir(), "selfops.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	dataset := sinks.Dataset{Name: "transactions", Table: "transactions", Model: (*testTransaction)(nil), KeyColumn: "key", TimeColumn: "transaction_date"}
+	sink := sinks.NewSQLiteSink(db, 0)
+	require.NoError(t, sink.Migrate(ctx, dataset))
+
+	rows := []testTransaction{
+		{Key: "a", TransactionDate: time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), Payee: "Cafe", Amount: -4.5},
+		{Key: "b", TransactionDate: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), Payee: "Employer", Amount: 2000},
+		{Key: "c", TransactionDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Payee: "Landlord", Amount: -1200},
+	}
+	require.NoError(t, sink.Upsert(ctx, dataset, &rows))
+
+	dir := t.TempDir()
+	_, err = Export(ctx, db, dataset, Options{Format: FormatCSV, Dir: dir})
+	require.NoError(t, err)
+	_, err = Export(ctx, db, dataset, Options{Format: FormatJSONL, Dir: dir})
+	require.NoError(t, err)
+
+	// february no longer has rows
+	require.NoError(t, sink.Delete(ctx, dataset, []string{"b", "c"}))
+	files, err := Export(ctx, db, dataset, Options{Format: FormatCSV, Dir: dir, To: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	assert.NoFileExists(t, filepath.Join(dir, "transactions", "month=2024-02", "transactions.csv"))
+	// other formats and months outside the range are kept
+	assert.FileExists(t, filepath.Join(dir, "transactions", "month=2024-02", "transactions.jsonl"))
+	assert.FileExists(t, filepath.Join(dir, "transactions", "month=2024-03", "transactions.csv"))
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -15,8 +16,10 @@ type cacheItem struct {
 	rate       float64
 }
 
+// CurrencyConverter is safe to share between importers running at the same time
 type CurrencyConverter struct {
 	accessKey string
+	mu        sync.Mutex
 	cache     map[string]map[string]cacheItem
 }
 
@@ -43,6 +46,9 @@ func (c *CurrencyConverter) ConversionRate(from, to string) (float64, error) {
 	// https://api.exchangeratesapi.io/history?start_at=2018-01-01&end_at=2018-01-02&symbols=USD,GBP&base=USD
 	// newest plan, query /latest and use the constant base to cache all conversions
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	cacheRate, err := c.getRateFromCache(from, to)
 	if err == nil {
 		return cacheRate, nil
@@ -0,0 +1,275 @@
+package financialimporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/uptrace/bun"
+)
+
+const (
+	TransactionCreated = "created"
+	TransactionUpdated = "updated"
+	TransactionDeleted = "deleted"
+)
+
+// SQLTransactionHistory is a change to a transaction row detected at import time. Old and New hold the full row
+// keyed by column name so the state of any transaction can be rebuilt for a point in time
+type SQLTransactionHistory struct {
+	bun.BaseModel `bun:"table:transactions_history,alias:transaction_history"`
+	ID            int64 `bun:",pk,autoincrement"`
+	Key           string
+	ChangeType    string
+	ChangedAt     time.Time
+	ChangedFields []string               `bun:",array"`
+	Old           map[string]interface{} `bun:"type:jsonb"`
+	New           map[string]interface{} `bun:"type:jsonb"`
+}
+
+// TransactionHistory compares the transactions written by a run against the previous run. It is shared by all the
+// transaction importers of a run and is safe to use from multiple goroutines
+type TransactionHistory struct {
+	db        bun.IDB
+	mu        sync.Mutex
+	changedAt time.Time
+	previous  map[string]map[string]interface{}
+	seen      map[string]bool
+	changes   []SQLTransactionHistory
+}
+
+// these change on every import so they aren't tracked
+var untrackedTransactionColumns = []string{"id", "updated_at"}
+
+// MigrateTransactionHistory creates the history table and a <table>_as_of(timestamptz) function that returns every
+// transaction as it was at that time
+func MigrateTransactionHistory(db bun.IDB, historyTable string) error {
+	_, err := db.NewCreateTable().Model((*SQLTransactionHistory)(nil)).ModelTableExpr(historyTable).IfNotExists().Exec(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to create %s table: %w", historyTable, err)
+	}
+
+	_, err = db.ExecContext(context.Background(), `CREATE OR REPLACE FUNCTION ?(as_of timestamptz)
+RETURNS TABLE (key varchar, changed_at timestamptz, data jsonb) AS $$
+	SELECT key, changed_at, new FROM (
+		SELECT DISTINCT ON (h.key) h.key, h.change_type, h.changed_at, h.new
+		FROM ? h
+		WHERE h.changed_at <= as_of
+		ORDER BY h.key, h.changed_at DESC, h.id DESC
+	) latest
+	WHERE change_type <> ?
+$$ LANGUAGE sql STABLE`, bun.Ident(historyTable+"_as_of"), bun.Ident(historyTable), TransactionDeleted)
+	if err != nil {
+		return fmt.Errorf("failed to create %s_as_of function: %w", historyTable, err)
+	}
+
+	return nil
+}
+
+// LoadTransactionHistory loads the transactions from the last run to compare against. If the history is empty every
+// transaction is recorded as created so the history has a starting point
+func LoadTransactionHistory(db bun.IDB, transactionsTable, historyTable string) (*TransactionHistory, error) {
+	history := &TransactionHistory{
+		db:        db,
+		changedAt: time.Now(),
+		previous:  make(map[string]map[string]interface{}),
+		seen:      make(map[string]bool),
+	}
+
+	historyCount, err := db.NewSelect().Model((*SQLTransactionHistory)(nil)).ModelTableExpr("? AS transaction_history", bun.Ident(historyTable)).Count(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to count %s: %w", historyTable, err)
+	}
+	if historyCount == 0 {
+		return history, nil
+	}
+
+	// the table can be from an older version, only load the columns it has
+	liveColumns, err := postgresutils.TableColumns(db, transactionsTable)
+	if err != nil {
+		return nil, err
+	}
+	if len(liveColumns) == 0 {
+		return history, nil
+	}
+
+	columns := []string{}
+	for _, f := range db.Dialect().Tables().Get(reflect.TypeOf((*SQLTransaction)(nil)).Elem()).Fields {
+		if slices.Contains(liveColumns, f.Name) {
+			columns = append(columns, f.Name)
+		}
+	}
+
+	previous := []SQLTransaction{}
+	err = db.NewSelect().Model(&previous).ModelTableExpr("? AS sql_transaction", bun.Ident(transactionsTable)).Column(columns...).Scan(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load previous transactions: %w", err)
+	}
+
+	for _, t := range previous {
+		values := history.values(&t)
+		for column := range values {
+			if !slices.Contains(columns, column) {
+				delete(values, column)
+			}
+		}
+		history.previous[t.Key] = values
+	}
+
+	return history, nil
+}
+
+// Record compares rows written by an importer against the previous run. A nil history records nothing
+func (h *TransactionHistory) Record(rows []SQLTransaction) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := range rows {
+		key := rows[i].Key
+		h.seen[key] = true
+		current := h.values(&rows[i])
+
+		old, ok := h.previous[key]
+		if !ok {
+			h.changes = append(h.changes, SQLTransactionHistory{
+				Key:        key,
+				ChangeType: TransactionCreated,
+				ChangedAt:  h.changedAt,
+				New:        current,
+			})
+			continue
+		}
+
+		changedFields := []string{}
+		for column, value := range current {
+			// columns added since the last run aren't changes to the transaction
+			previousValue, ok := old[column]
+			if !ok {
+				continue
+			}
+
+			if !reflect.DeepEqual(value, previousValue) {
+				changedFields = append(changedFields, column)
+			}
+		}
+
+		if len(changedFields) == 0 {
+			continue
+		}
+
+		slices.Sort(changedFields)
+		h.changes = append(h.changes, SQLTransactionHistory{
+			Key:           key,
+			ChangeType:    TransactionUpdated,
+			ChangedAt:     h.changedAt,
+			ChangedFields: changedFields,
+			Old:           old,
+			New:           current,
+		})
+	}
+}
+
+// RecordDeletions records every previous transaction that no importer wrote as deleted, call once all importers finished
+func (h *TransactionHistory) RecordDeletions() {
+	h.RecordBudgetDeletions(func(string) bool { return true })
+}
+
+// RecordBudgetDeletions is RecordDeletions for runs that only import some budgets, previous transactions of other
+// budgets aren't deleted
+func (h *TransactionHistory) RecordBudgetDeletions(imported func(budgetID string) bool) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for key, old := range h.previous {
+		if h.seen[key] {
+			continue
+		}
+
+		budgetID, _ := old["budget_id"].(string)
+		if !imported(budgetID) {
+			continue
+		}
+
+		h.changes = append(h.changes, SQLTransactionHistory{
+			Key:        key,
+			ChangeType: TransactionDeleted,
+			ChangedAt:  h.changedAt,
+			Old:        old,
+		})
+	}
+}
+
+// Write appends the recorded changes to the history table and returns how many were written
+func (h *TransactionHistory) Write(ctx context.Context, db bun.IDB, historyTable string, batchSize int) (int, error) {
+	if h == nil {
+		return 0, nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := 0; i < len(h.changes); i += batchSize {
+		records := h.changes[i:min(len(h.changes), i+batchSize)]
+		_, err := db.NewInsert().Model(&records).ModelTableExpr(historyTable).Exec(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("error writing transaction history: %w", err)
+		}
+	}
+
+	return len(h.changes), nil
+}
+
+// values returns the tracked columns of a transaction in the same form they have once stored as json, so rows built
+// by an importer and rows loaded from the database compare equal
+func (h *TransactionHistory) values(t *SQLTransaction) map[string]interface{} {
+	table := h.db.Dialect().Tables().Get(reflect.TypeOf(t).Elem())
+	strct := reflect.ValueOf(t).Elem()
+
+	values := make(map[string]interface{}, len(table.Fields))
+	for _, f := range table.Fields {
+		if slices.Contains(untrackedTransactionColumns, f.Name) {
+			continue
+		}
+
+		value := f.Value(strct).Interface()
+		switch v := value.(type) {
+		case time.Time:
+			value = v.UTC()
+		case []string:
+			if v == nil {
+				value = []string{}
+			}
+		case map[string]interface{}:
+			if v == nil {
+				value = map[string]interface{}{}
+			}
+		}
+
+		values[f.Name] = value
+	}
+
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return values
+	}
+
+	normalized := make(map[string]interface{}, len(values))
+	if err := json.Unmarshal(raw, &normalized); err != nil {
+		return values
+	}
+
+	return normalized
+}
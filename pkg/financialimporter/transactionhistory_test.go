@@ -0,0 +1,81 @@
+package financialimporter
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func TestTransactionHistoryRecord(t *testing.T) {
+	db := bun.NewDB(&sql.DB{}, pgdialect.New())
+	history := &TransactionHistory{
+		db:        db,
+		changedAt: time.Now(),
+		previous:  make(map[string]map[string]interface{}),
+		seen:      make(map[string]bool),
+	}
+
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	unchanged := SQLTransaction{Key: "a", TransactionDate: date, Category: "Groceries", Amount: -10}
+	recategorized := SQLTransaction{Key: "b", TransactionDate: date, Category: "Dining", Amount: -25}
+	deleted := SQLTransaction{Key: "c", TransactionDate: date, Category: "Rent", Amount: -1000}
+	for _, previous := range []SQLTransaction{unchanged, recategorized, deleted} {
+		history.previous[previous.Key] = history.values(&previous)
+	}
+
+	recategorized.Category = "Groceries"
+	// id and updated_at change every run and aren't changes
+	unchanged.ID = 42
+	unchanged.UpdatedAt = time.Now()
+	created := SQLTransaction{Key: "d", TransactionDate: date, Category: "Fuel", Amount: -40}
+
+	history.Record([]SQLTransaction{unchanged, recategorized, created})
+	history.RecordDeletions()
+
+	assert.Len(t, history.changes, 3)
+
+	changes := map[string]SQLTransactionHistory{}
+	for _, change := range history.changes {
+		changes[change.Key] = change
+	}
+
+	assert.Equal(t, TransactionUpdated, changes["b"].ChangeType)
+	assert.Equal(t, []string{"category"}, changes["b"].ChangedFields)
+	assert.Equal(t, "Dining", changes["b"].Old["category"])
+	assert.Equal(t, "Groceries", changes["b"].New["category"])
+
+	assert.Equal(t, TransactionCreated, changes["d"].ChangeType)
+	assert.Nil(t, changes["d"].Old)
+
+	assert.Equal(t, TransactionDeleted, changes["c"].ChangeType)
+	assert.Equal(t, "Rent", changes["c"].Old["category"])
+	assert.Nil(t, changes["c"].New)
+}
+
+func TestTransactionHistoryRecordBudgetDeletions(t *testing.T) {
+	db := bun.NewDB(&sql.DB{}, pgdialect.New())
+	history := &TransactionHistory{
+		db:        db,
+		changedAt: time.Now(),
+		previous:  make(map[string]map[string]interface{}),
+		seen:      make(map[string]bool),
+	}
+
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, previous := range []SQLTransaction{
+		{Key: "ynab", TransactionDate: date, BudgetID: "b1", Amount: -10},
+		{Key: "journal", TransactionDate: date, BudgetID: "journal::books", Amount: -20},
+	} {
+		history.previous[previous.Key] = history.values(&previous)
+	}
+
+	history.RecordBudgetDeletions(func(budgetID string) bool { return budgetID == "journal::books" })
+
+	assert.Len(t, history.changes, 1)
+	assert.Equal(t, "journal", history.changes[0].Key)
+	assert.Equal(t, TransactionDeleted, history.changes[0].ChangeType)
+}
@@ -5,11 +5,10 @@ import (
 	"fmt"
 	"math"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/bcaldwell/selfops/pkg/config"
-	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/bcaldwell/selfops/pkg/sinks"
 	"github.com/uptrace/bun"
 )
 
@@ -23,6 +22,10 @@ type SQLTransaction struct {
 	CategoryGroup    string
 	Payee            string
 	Account          string
+	BudgetID         string
+	AccountID        string
+	CategoryID       string
+	PayeeID          string
 	Memo             string `bun:"type:text"`
 	Currency         string
 	Amount           float64
@@ -34,9 +37,22 @@ type SQLTransaction struct {
 	UpdatedAt        time.Time
 }
 
-func NewTransactionImporter(db *bun.DB, currencyConverter *CurrencyConverter, transactions []Transaction, calculatedFields []config.CalculatedField, transactionCurrency string, currencies []string, importAfterDate time.Time, sqlTable string) FinancialImporter {
+// TransactionsDataset is the dataset transactions are written to in the sinks
+func TransactionsDataset(table string) sinks.Dataset {
+	return sinks.Dataset{
+		Name:       "transactions",
+		Table:      table,
+		Model:      (*SQLTransaction)(nil),
+		KeyColumn:  "key",
+		TimeColumn: "transaction_date",
+		// easiest way to handle deleted transactions, with the speed at which it works not too bad
+		Replace: true,
+	}
+}
+
+func NewTransactionImporter(sink sinks.Sink, currencyConverter *CurrencyConverter, transactions []Transaction, calculatedFields []config.CalculatedField, transactionCurrency string, currencies []string, importAfterDate time.Time, sqlTable string, history *TransactionHistory) FinancialImporter {
 	return &TransactionImporter{
-		db:                  db,
+		sink:                sink,
 		currencyConverter:   currencyConverter,
 		calculatedFields:    calculatedFields,
 		transactions:        transactions,
@@ -44,11 +60,12 @@ func NewTransactionImporter(db *bun.DB, currencyConverter *CurrencyConverter, tr
 		currencies:          currencies,
 		importAfterDate:     importAfterDate,
 		sqlTable:            sqlTable,
+		history:             history,
 	}
 }
 
 type TransactionImporter struct {
-	db                  *bun.DB
+	sink                sinks.Sink
 	currencyConverter   *CurrencyConverter
 	calculatedFields    []config.CalculatedField
 	transactions        []Transaction
@@ -57,6 +74,8 @@ type TransactionImporter struct {
 	currencies          []string
 	currencyConversions CurrencyConversion
 	sqlTable            string
+	// optional, records changes to the transactions compared to the last run
+	history *TransactionHistory
 }
 
 // server will return that a transaction is deleted
@@ -86,33 +105,12 @@ type TransactionImporter struct {
 //   }
 
 func (importer *TransactionImporter) Migrate() error {
-	model := (*SQLTransaction)(nil)
-	tableName := config.CurrentYnabConfig().SQL.TransactionsTable
-
-	// easiest way to handle deleted transactions, with the speed at which it works not too bad
-	_, err := importer.db.NewDropTable().Model(model).ModelTableExpr(tableName).Exec(context.Background())
-	if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("ERROR: table \"%s\" does not exist (SQLSTATE=42P01)", tableName)) {
-		return fmt.Errorf("failed to drop %s table: %w", tableName, err)
-	}
-
-	_, err = importer.db.NewCreateTable().Model(model).ModelTableExpr(tableName).IfNotExists().Exec(context.Background())
-	if err != nil {
-		return fmt.Errorf("failed to create %s table: %w", tableName, err)
-	}
-
-	if err := postgresutils.SetUnlogged(importer.db, tableName); err != nil {
-		return fmt.Errorf("failed to set %s unlogged: %w", tableName, err)
-	}
-
-	return nil
+	return importer.sink.Migrate(context.Background(), TransactionsDataset(importer.sqlTable))
 }
 
 func (importer *TransactionImporter) Import() (int, error) {
 	var err error
 
-	model := (*SQLTransaction)(nil)
-	tableName := config.CurrentYnabConfig().SQL.TransactionsTable
-
 	importer.currencyConversions, err = generateCurrencyConversions(importer.currencyConverter, importer.transactionCurrency, importer.currencies)
 	if err != nil {
 		return 0, err
@@ -153,26 +151,13 @@ func (importer *TransactionImporter) Import() (int, error) {
 		}
 	}
 
-	batchSize := config.CurrentYnabConfig().SQL.BatchSize
-	if batchSize == 0 {
-		batchSize = 1000
+	if importer.history != nil {
+		importer.history.Record(sqlRecords)
 	}
 
-	for i := 0; i < len(sqlRecords); i += batchSize {
-		endIndex := min(len(sqlRecords), i+batchSize)
-
-		records := sqlRecords[i:endIndex]
-
-		_, err = importer.db.NewInsert().
-			Model(&records).
-			ModelTableExpr(tableName).
-			On("CONFLICT (key) DO UPDATE").
-			Set(postgresutils.TableSetString(importer.db, model, "id", "key")).
-			Exec(context.Background())
-
-		if err != nil {
-			return 0, fmt.Errorf("error writing to sql, transaction batch start index %i: %w", i, err)
-		}
+	err = importer.sink.Upsert(context.Background(), TransactionsDataset(importer.sqlTable), &sqlRecords)
+	if err != nil {
+		return 0, fmt.Errorf("error writing transactions: %w", err)
 	}
 
 	return len(sqlRecords), nil
@@ -219,6 +204,13 @@ func (importer *TransactionImporter) createSQLForTransaction(transaction Transac
 
 	sqlRow.Tags = transaction.Tags()
 
+	if ids, ok := transaction.(IdentifiedTransaction); ok {
+		sqlRow.BudgetID = ids.BudgetID()
+		sqlRow.AccountID = ids.AccountID()
+		sqlRow.CategoryID = ids.CategoryID()
+		sqlRow.PayeeID = ids.PayeeID()
+	}
+
 	return &sqlRow, nil
 }
 
@@ -41,6 +41,20 @@ type Transaction interface {
 	IndexKey() string
 }
 
+// IdentifiedTransaction is implemented by transactions from sources with stable ids for the budget, account, category and
+// payee. The ids are stored next to the names so renames don't break joins
+type IdentifiedTransaction interface {
+	BudgetID() string
+	AccountID() string
+	CategoryID() string
+	PayeeID() string
+}
+
+// TransferTransaction is implemented by transfers that know the account on the other side of the transfer
+type TransferTransaction interface {
+	TransferAccount() string
+}
+
 type CurrencyConversion map[string]float64
 
 // type CalculatedField struct {
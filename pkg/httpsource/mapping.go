@@ -0,0 +1,270 @@
+package httpsource
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+)
+
+const Dataset = "http"
+
+// MeasurementDataset is the dataset the rows of a measurement source are written to in the sinks
+func MeasurementDataset(measurement config.HTTPMeasurementMapping) sinks.Dataset {
+	return sinks.Dataset{
+		Name:       Dataset,
+		Table:      measurement.Name,
+		Model:      (*sinks.Point)(nil),
+		KeyColumn:  "key",
+		TimeColumn: "time",
+	}
+}
+
+// Transaction is an item of a source mapped to a transaction
+type Transaction struct {
+	id            string
+	budgetID      string
+	date          string
+	payee         string
+	category      string
+	categoryGroup string
+	memo          string
+	account       string
+	currency      string
+	amount        float64
+	tags          []string
+}
+
+// Transactions maps items to transactions. Items without an id are keyed by a hash of their content
+func Transactions(source config.HTTPSource, items []interface{}) ([]*Transaction, error) {
+	mapping := source.Transactions
+	if mapping == nil {
+		return nil, fmt.Errorf("source %s has no transaction mapping", source.Name)
+	}
+
+	multiplier := mapping.AmountMultiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+
+	transactions := make([]*Transaction, 0, len(items))
+	for _, item := range items {
+		id := lookupString(item, mapping.ID)
+		if id == "" {
+			id = itemHash(item)
+		}
+
+		date, err := parseTime(lookupString(item, mapping.Date), mapping.DateLayout)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %w", id, err)
+		}
+
+		amount, err := lookupFloat(item, mapping.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %w", id, err)
+		}
+
+		transactions = append(transactions, &Transaction{
+			id:            SourceID(source.Name) + "::" + id,
+			budgetID:      SourceID(source.Name),
+			date:          date.Format("2006-01-02"),
+			payee:         lookupString(item, mapping.Payee),
+			category:      lookupString(item, mapping.Category),
+			categoryGroup: lookupString(item, mapping.CategoryGroup),
+			memo:          lookupString(item, mapping.Memo),
+			account:       lookupString(item, mapping.Account),
+			currency:      lookupString(item, mapping.Currency),
+			amount:        amount * multiplier,
+			tags:          lookupTags(item, mapping.Tags),
+		})
+	}
+
+	return transactions, nil
+}
+
+// Points maps items to rows of the http dataset. Numbers and bools are fields, strings that aren't numbers stay strings
+func Points(source config.HTTPSource, items []interface{}) ([]sinks.Point, error) {
+	mapping := source.Measurement
+	if mapping == nil {
+		return nil, fmt.Errorf("source %s has no measurement mapping", source.Name)
+	}
+
+	points := make([]sinks.Point, 0, len(items))
+	for _, item := range items {
+		key := lookupString(item, mapping.Key)
+		if key == "" {
+			key = itemHash(item)
+		}
+
+		t, err := parseTime(lookupString(item, mapping.Time), mapping.TimeLayout)
+		if err != nil {
+			return nil, fmt.Errorf("item %s: %w", key, err)
+		}
+
+		point := sinks.Point{
+			Key:    SourceID(source.Name) + "::" + key,
+			Time:   t,
+			Tags:   map[string]string{},
+			Fields: map[string]interface{}{},
+		}
+
+		for name, path := range mapping.Tags {
+			if value := lookupString(item, path); value != "" {
+				point.Tags[name] = value
+			}
+		}
+
+		for name, path := range mapping.Fields {
+			value, ok := Lookup(item, path)
+			if !ok {
+				continue
+			}
+			switch value := value.(type) {
+			case json.Number:
+				point.Fields[name], _ = value.Float64()
+			case bool:
+				point.Fields[name] = value
+			case string:
+				if f, err := strconv.ParseFloat(value, 64); err == nil {
+					point.Fields[name] = f
+				} else {
+					point.Fields[name] = value
+				}
+			}
+		}
+
+		points = append(points, point)
+	}
+
+	return points, nil
+}
+
+// parseTime parses a timestamp with layout, or as a date or RFC 3339 without one
+func parseTime(value, layout string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("missing date")
+	}
+
+	if layout != "" {
+		return time.Parse(layout, value)
+	}
+
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// lookupTags reads a list of strings or a comma separated string
+func lookupTags(item interface{}, path string) []string {
+	tags := []string{}
+	if path == "" {
+		return tags
+	}
+
+	value, ok := Lookup(item, path)
+	if !ok {
+		return tags
+	}
+
+	switch value := value.(type) {
+	case []interface{}:
+		for _, v := range value {
+			if tag, ok := v.(string); ok && tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	case string:
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+func itemHash(item interface{}) string {
+	raw, _ := json.Marshal(item)
+	sum := sha1.Sum(raw)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func (t *Transaction) Date() string {
+	return t.date
+}
+
+func (t *Transaction) Payee() string {
+	return t.payee
+}
+
+func (t *Transaction) Category() string {
+	return t.category
+}
+
+func (t *Transaction) CategoryGroup() string {
+	return t.categoryGroup
+}
+
+func (t *Transaction) Memo() string {
+	return t.memo
+}
+
+func (t *Transaction) Amount() float64 {
+	return t.amount
+}
+
+// Currency is the currency of the amount, empty for the currency of the source
+func (t *Transaction) Currency() string {
+	return t.currency
+}
+
+func (t *Transaction) TransactionType() financialimporter.TransactionType {
+	if t.amount >= 0 {
+		return financialimporter.Income
+	}
+	return financialimporter.Expense
+}
+
+func (t *Transaction) Tags() []string {
+	return t.tags
+}
+
+func (t *Transaction) HasSubTransactions() bool {
+	return false
+}
+
+func (t *Transaction) SubTransactions() []financialimporter.Transaction {
+	return nil
+}
+
+func (t *Transaction) Account() string {
+	return t.account
+}
+
+func (t *Transaction) IndexKey() string {
+	return t.id
+}
+
+func (t *Transaction) BudgetID() string {
+	return t.budgetID
+}
+
+func (t *Transaction) AccountID() string {
+	return t.account
+}
+
+func (t *Transaction) CategoryID() string {
+	return t.category
+}
+
+func (t *Transaction) PayeeID() string {
+	return ""
+}
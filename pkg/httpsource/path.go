@@ -0,0 +1,88 @@
+package httpsource
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Lookup returns the value at a path of json decoded with numbers as json.Number. Paths are keys separated by dots with
+// [n] for list indexes, like $.data.items or lines[0].amount. An empty path or $ is the value itself
+func Lookup(value interface{}, path string) (interface{}, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return value, true
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		indexes := []int{}
+		if i := strings.Index(part, "["); i >= 0 {
+			key = part[:i]
+			for _, index := range strings.Split(strings.TrimSuffix(part[i+1:], "]"), "][") {
+				n, err := strconv.Atoi(index)
+				if err != nil {
+					return nil, false
+				}
+				indexes = append(indexes, n)
+			}
+		}
+
+		if key != "" {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[key]; !ok {
+				return nil, false
+			}
+		}
+
+		for _, n := range indexes {
+			list, ok := value.([]interface{})
+			if !ok || n < 0 || n >= len(list) {
+				return nil, false
+			}
+			value = list[n]
+		}
+	}
+
+	return value, true
+}
+
+// lookupString is the value at a path as a string, empty when it's missing or null
+func lookupString(value interface{}, path string) string {
+	if path == "" {
+		return ""
+	}
+
+	v, ok := Lookup(value, path)
+	if !ok || v == nil {
+		return ""
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// lookupFloat is the value at a path as a number, numbers in strings are parsed
+func lookupFloat(value interface{}, path string) (float64, error) {
+	v, ok := Lookup(value, path)
+	if !ok || v == nil {
+		return 0, fmt.Errorf("no number at %s", path)
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	return 0, fmt.Errorf("%v at %s isn't a number", v, path)
+}
@@ -0,0 +1,287 @@
+package httpsource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+)
+
+const (
+	Type = "http-json"
+
+	PaginationCursor = "cursor"
+	PaginationPage   = "page"
+	PaginationLink   = "link"
+
+	// stops following an api that never runs out of pages
+	maxPages = 10000
+)
+
+var linkNextRegex = regexp.MustCompile(`<([^>]*)>\s*;[^,]*rel="?next"?`)
+
+// Source fetches the items of an http-json source
+type Source struct {
+	config config.HTTPSource
+	client *http.Client
+	// value of the auth header, empty to not send one
+	auth string
+}
+
+func NewSource(source config.HTTPSource, client *http.Client, auth string) *Source {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Source{config: source, client: client, auth: auth}
+}
+
+// SourceID is the budget id of the transactions of a source
+func SourceID(name string) string {
+	return "http::" + name
+}
+
+// Fetch gets the items of every page. state is what the last run returned: the largest SinceField sent as SinceParam,
+// or without one the last cursor so cursor pagination starts from the last page of the last run. Files are read in one
+// go
+func (s *Source) Fetch(ctx context.Context, state string) ([]interface{}, string, error) {
+	if !strings.HasPrefix(s.config.URL, "http://") && !strings.HasPrefix(s.config.URL, "https://") {
+		raw, err := os.ReadFile(strings.TrimPrefix(s.config.URL, "file://"))
+		if err != nil {
+			return nil, "", err
+		}
+
+		body, err := decode(raw)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode %s: %w", s.config.URL, err)
+		}
+
+		items, err := s.items(body)
+		if err != nil {
+			return nil, "", err
+		}
+		return items, s.since(items, state), nil
+	}
+
+	pagination := s.config.Pagination
+	param := pagination.Param
+	if param == "" {
+		param = pagination.Type
+	}
+	page := pagination.Start
+	if page == 0 {
+		page = 1
+	}
+
+	cursor := ""
+	if s.config.SinceParam == "" && pagination.Type == PaginationCursor {
+		cursor = state
+	}
+
+	next := s.config.URL
+	items := []interface{}{}
+	for i := 0; i < maxPages; i++ {
+		query := map[string]string{}
+		if s.config.SinceParam != "" && state != "" {
+			query[s.config.SinceParam] = state
+		}
+		switch pagination.Type {
+		case PaginationCursor:
+			if cursor != "" {
+				query[param] = cursor
+			}
+		case PaginationPage:
+			query[param] = strconv.Itoa(page)
+		}
+
+		requestURL := next
+		if pagination.Type != PaginationLink || i == 0 {
+			var err error
+			requestURL, err = withQuery(next, query)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+
+		body, header, err := s.get(ctx, requestURL)
+		if err != nil {
+			return nil, "", err
+		}
+
+		pageItems, err := s.items(body)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, pageItems...)
+
+		more := false
+		switch pagination.Type {
+		case PaginationCursor:
+			nextCursor := lookupString(body, pagination.Next)
+			more = len(pageItems) > 0 && nextCursor != "" && nextCursor != cursor
+			if nextCursor != "" {
+				cursor = nextCursor
+			}
+		case PaginationPage:
+			more = len(pageItems) > 0
+			page++
+		case PaginationLink:
+			link := linkNext(header.Get("Link"))
+			if link != "" {
+				base, _ := url.Parse(requestURL)
+				ref, err := url.Parse(link)
+				if err != nil {
+					return nil, "", fmt.Errorf("invalid next link %s: %w", link, err)
+				}
+				next = base.ResolveReference(ref).String()
+				more = true
+			}
+		}
+
+		if pagination.HasMore != "" {
+			if hasMore, ok := Lookup(body, pagination.HasMore); ok {
+				if b, ok := hasMore.(bool); ok {
+					more = more && b
+				}
+			}
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	if s.config.SinceParam == "" && pagination.Type == PaginationCursor {
+		return items, cursor, nil
+	}
+	return items, s.since(items, state), nil
+}
+
+func (s *Source) get(ctx context.Context, requestURL string) (interface{}, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.auth != "" {
+		header := s.config.AuthHeader
+		if header == "" {
+			header = "Authorization"
+		}
+		req.Header.Set(header, s.auth)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("request to %s failed with %s: %s", req.URL.Redacted(), resp.Status, firstN(string(raw), 200))
+	}
+
+	body, err := decode(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode response of %s: %w", req.URL.Redacted(), err)
+	}
+	return body, resp.Header, nil
+}
+
+// items is the list at the items path of a response, null is an empty page
+func (s *Source) items(body interface{}) ([]interface{}, error) {
+	value, ok := Lookup(body, s.config.Items)
+	if !ok {
+		return nil, fmt.Errorf("no items at %s", s.config.Items)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("items at %s aren't a list", s.config.Items)
+	}
+	return items, nil
+}
+
+// since is the largest SinceField of the items, compared as numbers when both are numbers. Timestamps in the same
+// format compare as strings
+func (s *Source) since(items []interface{}, state string) string {
+	if s.config.SinceField == "" {
+		return state
+	}
+
+	for _, item := range items {
+		value := lookupString(item, s.config.SinceField)
+		if value != "" && greater(value, state) {
+			state = value
+		}
+	}
+	return state
+}
+
+func greater(a, b string) bool {
+	if b == "" {
+		return true
+	}
+
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	if errX == nil && errY == nil {
+		return x > y
+	}
+	return a > b
+}
+
+func decode(raw []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var body interface{}
+	err := decoder.Decode(&body)
+	return body, err
+}
+
+func withQuery(rawURL string, query map[string]string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	values := u.Query()
+	for key, value := range query {
+		values.Set(key, value)
+	}
+	u.RawQuery = values.Encode()
+	return u.String(), nil
+}
+
+// linkNext is the rel="next" url of a Link header
+func linkNext(header string) string {
+	match := linkNextRegex.FindStringSubmatch(header)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+func firstN(s string, n int) string {
+	if len(s) < n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,163 @@
+package httpsource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPages = map[string]string{
+	"":   `{"data":{"items":[{"id":"t1","date":"2024-03-01","amount":"-1250","merchant":{"name":"Cafe"},"tags":["work"]}]},"next_cursor":"c1"}`,
+	"c1": `{"data":{"items":[{"id":"t2","date":"2024-03-02T10:00:00Z","amount":500000,"merchant":{"name":"Employer"},"tags":"salary, monthly","currency":"CAD"}]},"next_cursor":"c2"}`,
+	"c2": `{"data":{"items":[]},"next_cursor":null}`,
+}
+
+func TestFetchCursor(t *testing.T) {
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests = append(requests, r.URL.Query().Get("cursor"))
+		fmt.Fprint(w, testPages[r.URL.Query().Get("cursor")])
+	}))
+	defer server.Close()
+
+	source := config.HTTPSource{
+		Name:       "bank",
+		URL:        server.URL + "/transactions",
+		AuthHeader: "X-Api-Key",
+		Items:      "$.data.items",
+		Pagination: config.HTTPPagination{Type: PaginationCursor, Next: "$.next_cursor"},
+		Transactions: &config.HTTPTransactionMapping{
+			ID:               "id",
+			Date:             "date",
+			Amount:           "amount",
+			Payee:            "merchant.name",
+			Tags:             "tags",
+			Currency:         "currency",
+			AmountMultiplier: 0.01,
+		},
+	}
+
+	items, state, err := NewSource(source, server.Client(), "secret").Fetch(context.Background(), "")
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, []string{"", "c1", "c2"}, requests)
+	assert.Equal(t, "c2", state)
+
+	transactions, err := Transactions(source, items)
+	require.NoError(t, err)
+	require.Len(t, transactions, 2)
+	assert.Equal(t, "http::bank::t1", transactions[0].IndexKey())
+	assert.Equal(t, "http::bank", transactions[0].BudgetID())
+	assert.Equal(t, -12.5, transactions[0].Amount())
+	assert.Equal(t, "Cafe", transactions[0].Payee())
+	assert.Equal(t, []string{"work"}, transactions[0].Tags())
+	assert.Equal(t, "2024-03-02", transactions[1].Date())
+	assert.Equal(t, 5000.0, transactions[1].Amount())
+	assert.Equal(t, "CAD", transactions[1].Currency())
+	assert.Equal(t, []string{"salary", "monthly"}, transactions[1].Tags())
+
+	// the next run starts from the last cursor
+	requests = nil
+	items, state, err = NewSource(source, server.Client(), "secret").Fetch(context.Background(), state)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+	assert.Equal(t, []string{"c2"}, requests)
+	assert.Equal(t, "c2", state)
+
+	_, _, err = NewSource(source, server.Client(), "wrong").Fetch(context.Background(), "")
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestFetchLinkAndSince(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			assert.Equal(t, "2024-01-01T00:00:00Z", r.URL.Query().Get("since"))
+			w.Header().Set("Link", fmt.Sprintf(`<%s/readings?page=2>; rel="next", <%s/readings?page=9>; rel="last"`, server.URL, server.URL))
+			fmt.Fprint(w, `[{"id":1,"at":"2024-01-02T00:00:00Z","kwh":"3.5","meter":"house"}]`)
+		case "2":
+			fmt.Fprint(w, `[{"id":2,"at":"2024-01-03T00:00:00Z","kwh":4,"meter":"house","ok":true}]`)
+		}
+	}))
+	defer server.Close()
+
+	source := config.HTTPSource{
+		Name:       "power",
+		URL:        server.URL + "/readings",
+		Pagination: config.HTTPPagination{Type: PaginationLink},
+		SinceParam: "since",
+		SinceField: "at",
+		Measurement: &config.HTTPMeasurementMapping{
+			Name:   "power",
+			Key:    "id",
+			Time:   "at",
+			Tags:   map[string]string{"meter": "meter"},
+			Fields: map[string]string{"kwh": "kwh", "ok": "ok"},
+		},
+	}
+
+	items, state, err := NewSource(source, nil, "").Fetch(context.Background(), "2024-01-01T00:00:00Z")
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "2024-01-03T00:00:00Z", state)
+
+	points, err := Points(source, items)
+	require.NoError(t, err)
+	require.Len(t, points, 2)
+	assert.Equal(t, "http::power::1", points[0].Key)
+	assert.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), points[0].Time)
+	assert.Equal(t, map[string]string{"meter": "house"}, points[0].Tags)
+	assert.Equal(t, map[string]interface{}{"kwh": 3.5}, points[0].Fields)
+	assert.Equal(t, map[string]interface{}{"kwh": 4.0, "ok": true}, points[1].Fields)
+}
+
+func TestFetchPagesAndFiles(t *testing.T) {
+	pages := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages = append(pages, r.URL.Query().Get("p"))
+		if r.URL.Query().Get("p") == "1" {
+			fmt.Fprint(w, `{"results":[{"id":"a"},{"id":"b"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"results":null}`)
+	}))
+	defer server.Close()
+
+	source := config.HTTPSource{URL: server.URL, Items: "results", Pagination: config.HTTPPagination{Type: PaginationPage, Param: "p"}}
+	items, _, err := NewSource(source, nil, "").Fetch(context.Background(), "")
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, []string{"1", "2"}, pages)
+
+	path := filepath.Join(t.TempDir(), "items.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"results":[{"id":"a"}]}`), 0o644))
+	items, _, err = NewSource(config.HTTPSource{URL: path, Items: "results"}, nil, "").Fetch(context.Background(), "")
+	require.NoError(t, err)
+	assert.Len(t, items, 1)
+}
+
+func TestLookup(t *testing.T) {
+	body, err := decode([]byte(`{"a":{"b":[{"c":1},{"c":[5,6]}]}}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, "1", lookupString(body, "$.a.b[0].c"))
+	assert.Equal(t, "6", lookupString(body, "a.b[1].c[1]"))
+	_, ok := Lookup(body, "a.b[2]")
+	assert.False(t, ok)
+	_, ok = Lookup(body, "a.x")
+	assert.False(t, ok)
+}
@@ -0,0 +1,176 @@
+package importruns
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/uptrace/bun"
+)
+
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+
+	defaultTable = "import_runs"
+)
+
+// SQLImportRun is one attempt of a task, written when the run starts and updated when it finishes
+type SQLImportRun struct {
+	bun.BaseModel `bun:"table:import_runs,alias:import_run"`
+	ID            int64 `bun:",pk,autoincrement"`
+	Task          string
+	StartedAt     time.Time
+	FinishedAt    time.Time `bun:",nullzero"`
+	Status        string
+	Error         string                 `bun:"type:text"`
+	Tables        map[string]TableCounts `bun:"type:jsonb"`
+	// ynab server_knowledge per budget id before and after the run
+	ServerKnowledgeBefore map[string]int64 `bun:"type:jsonb"`
+	ServerKnowledgeAfter  map[string]int64 `bun:"type:jsonb"`
+	ConfigHash            string
+}
+
+type TableCounts struct {
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"`
+	Deleted  int `json:"deleted"`
+}
+
+// Run collects what a runner changed, it is safe to use from multiple goroutines
+type Run struct {
+	mu     sync.Mutex
+	record SQLImportRun
+}
+
+func (r *Run) AddTableCounts(table string, inserted, updated, deleted int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := r.record.Tables[table]
+	counts.Inserted += inserted
+	counts.Updated += updated
+	counts.Deleted += deleted
+	r.record.Tables[table] = counts
+}
+
+func (r *Run) SetServerKnowledge(budgetID string, before, after int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record.ServerKnowledgeBefore[budgetID] = before
+	r.record.ServerKnowledgeAfter[budgetID] = after
+}
+
+// Recorder writes runs to the import runs table. A recorder without a database still hands out runs but doesn't persist them
+type Recorder struct {
+	db         *bun.DB
+	table      string
+	configHash string
+}
+
+func NewRecorder(db *bun.DB, table string) (*Recorder, error) {
+	if table == "" {
+		table = defaultTable
+	}
+
+	recorder := &Recorder{
+		db:         db,
+		table:      table,
+		configHash: ConfigHash(),
+	}
+
+	if db == nil {
+		return recorder, nil
+	}
+
+	_, err := db.NewCreateTable().Model((*SQLImportRun)(nil)).ModelTableExpr(table).IfNotExists().Exec(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s table: %w", table, err)
+	}
+
+	return recorder, nil
+}
+
+func (r *Recorder) Start(task string) *Run {
+	run := &Run{
+		record: SQLImportRun{
+			Task:                  task,
+			StartedAt:             time.Now(),
+			Status:                StatusRunning,
+			Tables:                map[string]TableCounts{},
+			ServerKnowledgeBefore: map[string]int64{},
+			ServerKnowledgeAfter:  map[string]int64{},
+			ConfigHash:            r.configHash,
+		},
+	}
+
+	if r.db == nil {
+		return run
+	}
+
+	_, err := r.db.NewInsert().Model(&run.record).ModelTableExpr(r.table).Returning("id").Exec(context.Background())
+	if err != nil {
+		slog.Warn("failed to record import run start", "task", task, "error", err)
+	}
+
+	return run
+}
+
+func (r *Recorder) Finish(run *Run, runErr error) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	run.record.FinishedAt = time.Now()
+	run.record.Status = StatusSucceeded
+	if runErr != nil {
+		run.record.Status = StatusFailed
+		run.record.Error = runErr.Error()
+	}
+
+	if r.db == nil || run.record.ID == 0 {
+		return
+	}
+
+	_, err := r.db.NewUpdate().Model(&run.record).ModelTableExpr("? AS import_run", bun.Ident(r.table)).WherePK().Exec(context.Background())
+	if err != nil {
+		slog.Warn("failed to record import run finish", "task", run.record.Task, "error", err)
+	}
+}
+
+// List returns the most recent runs, newest first
+func (r *Recorder) List(limit int) ([]SQLImportRun, error) {
+	if r.db == nil {
+		return nil, fmt.Errorf("no database configured for import runs")
+	}
+
+	runs := []SQLImportRun{}
+	err := r.db.NewSelect().Model(&runs).ModelTableExpr("? AS import_run", bun.Ident(r.table)).Order("started_at DESC").Limit(limit).Scan(context.Background())
+	return runs, err
+}
+
+// Close closes the database of the recorder
+func (r *Recorder) Close() error {
+	if r.db == nil {
+		return nil
+	}
+	return r.db.Close()
+}
+
+// ConfigHash identifies the configuration a run used, secrets are not included
+func ConfigHash() string {
+	raw, err := json.Marshal(config.CurrentConfig())
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
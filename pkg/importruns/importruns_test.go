@@ -0,0 +1,86 @@
+package importruns
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCounts(t *testing.T) {
+	recorder, err := NewRecorder(nil, "")
+	require.NoError(t, err)
+
+	run := recorder.Start("ynab")
+	assert.Equal(t, StatusRunning, run.record.Status)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run.AddTableCounts("transactions", 1, 2, 3)
+		}()
+	}
+	wg.Wait()
+
+	run.SetServerKnowledge("main", 10, 12)
+
+	assert.Equal(t, TableCounts{Inserted: 10, Updated: 20, Deleted: 30}, run.record.Tables["transactions"])
+	assert.Equal(t, int64(10), run.record.ServerKnowledgeBefore["main"])
+	assert.Equal(t, int64(12), run.record.ServerKnowledgeAfter["main"])
+}
+
+func TestRecorderWithoutDatabase(t *testing.T) {
+	recorder, err := NewRecorder(nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, defaultTable, recorder.table)
+
+	run := recorder.Start("airtable")
+	recorder.Finish(run, errors.New("boom"))
+	assert.Equal(t, StatusFailed, run.record.Status)
+	assert.Equal(t, "boom", run.record.Error)
+	assert.False(t, run.record.FinishedAt.IsZero())
+
+	_, err = recorder.List(10)
+	assert.Error(t, err)
+	assert.NoError(t, recorder.Close())
+}
+
+func TestRecorderWritesRuns(t *testing.T) {
+	db, err := sinks.OpenSQLite(filepath.Join(t.TempDir(), "runs.db"))
+	require.NoError(t, err)
+
+	recorder, err := NewRecorder(db, "runs")
+	require.NoError(t, err)
+	defer recorder.Close()
+
+	first := recorder.Start("ynab")
+	require.NotZero(t, first.record.ID)
+	first.AddTableCounts("transactions", 3, 1, 0)
+	recorder.Finish(first, nil)
+
+	second := recorder.Start("journal")
+	recorder.Finish(second, errors.New("failed to parse"))
+
+	runs, err := recorder.List(10)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+
+	assert.Equal(t, "journal", runs[0].Task)
+	assert.Equal(t, StatusFailed, runs[0].Status)
+	assert.Equal(t, "failed to parse", runs[0].Error)
+
+	assert.Equal(t, "ynab", runs[1].Task)
+	assert.Equal(t, StatusSucceeded, runs[1].Status)
+	assert.Equal(t, TableCounts{Inserted: 3, Updated: 1}, runs[1].Tables["transactions"])
+	assert.Equal(t, ConfigHash(), runs[1].ConfigHash)
+
+	runs, err = recorder.List(1)
+	require.NoError(t, err)
+	assert.Len(t, runs, 1)
+}
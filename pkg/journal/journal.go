@@ -0,0 +1,365 @@
+package journal
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+)
+
+const (
+	FormatLedger    = "ledger"
+	FormatHledger   = "hledger"
+	FormatBeancount = "beancount"
+
+	startingBalancePayee = "Starting Balance"
+	inflowCategoryGroup  = "Internal Master Category"
+)
+
+// ynab account types that are owed rather than owned
+var liabilityAccountTypes = []string{"creditCard", "lineOfCredit", "otherLiability", "mortgage", "autoLoan", "studentLoan", "personalLoan", "medicalDebt", "otherDebt"}
+
+var beancountTagRegex = regexp.MustCompile(`[^A-Za-z0-9\-_/.]+`)
+
+// Budget is the transactions of one budget, every budget gets its own asset and liability accounts
+type Budget struct {
+	Name     string
+	Currency string
+	// AccountTypes maps account names to ynab account types, debt accounts are liabilities
+	AccountTypes map[string]string
+	Transactions []financialimporter.Transaction
+}
+
+// Generator writes transactions as a plain text accounting journal in ledger, hledger or beancount syntax
+type Generator struct {
+	w      io.Writer
+	format string
+	opened map[string]bool
+}
+
+func NewGenerator(w io.Writer, format string) (*Generator, error) {
+	switch format {
+	case FormatLedger, FormatHledger, FormatBeancount:
+	default:
+		return nil, fmt.Errorf("unknown journal format %s", format)
+	}
+
+	return &Generator{
+		w:      w,
+		format: format,
+		opened: make(map[string]bool),
+	}, nil
+}
+
+// Options writes the beancount header, ledger and hledger have no equivalent
+func (g *Generator) Options(title string, operatingCurrencies []string) error {
+	if g.format != FormatBeancount {
+		return nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "option \"title\" %s\n", quote(title))
+	for _, currency := range operatingCurrencies {
+		fmt.Fprintf(&sb, "option \"operating_currency\" %s\n", quote(currency))
+	}
+	sb.WriteString("\n")
+
+	_, err := io.WriteString(g.w, sb.String())
+	return err
+}
+
+// Prices writes the price of each currency in rates in terms of currency on date
+func (g *Generator) Prices(date time.Time, currency string, rates map[string]float64) error {
+	currencies := make([]string, 0, len(rates))
+	for c := range rates {
+		if c != currency {
+			currencies = append(currencies, c)
+		}
+	}
+	sort.Strings(currencies)
+
+	for _, c := range currencies {
+		price := strconv.FormatFloat(rates[c], 'f', -1, 64)
+
+		var err error
+		if g.format == FormatBeancount {
+			_, err = fmt.Fprintf(g.w, "%s price %s %s %s\n", date.Format("2006-01-02"), c, price, currency)
+		} else {
+			_, err = fmt.Fprintf(g.w, "P %s %s %s %s\n", date.Format("2006-01-02"), c, price, currency)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(currencies) > 0 {
+		_, err := fmt.Fprintln(g.w)
+		return err
+	}
+	return nil
+}
+
+type posting struct {
+	account string
+	amount  float64
+	comment string
+}
+
+// Budget writes the transactions of a budget ordered by date. A transfer is in the budget once for each account, only
+// the first is written. Split transactions are written as one transaction with a posting per split
+func (g *Generator) Budget(b Budget) error {
+	transactions := slices.Clone(b.Transactions)
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].Date() < transactions[j].Date()
+	})
+
+	// transfers inside splits always win over the plain transaction on the other side of the transfer
+	pending := map[string]int{}
+	for _, t := range transactions {
+		for _, sub := range t.SubTransactions() {
+			if isTransfer(sub) {
+				pending[transferKey(sub)]++
+			}
+		}
+	}
+
+	for _, t := range transactions {
+		if isTransfer(t) && !t.HasSubTransactions() {
+			key := transferKey(t)
+			if pending[key] > 0 {
+				pending[key]--
+				continue
+			}
+			pending[key]++
+		}
+
+		err := g.transaction(b, t)
+		if err != nil {
+			return fmt.Errorf("failed to write transaction %s: %w", t.IndexKey(), err)
+		}
+	}
+
+	return nil
+}
+
+// transferKey is the same for both sides of a transfer
+func transferKey(t financialimporter.Transaction) string {
+	accounts := []string{t.Account(), transferAccount(t)}
+	sort.Strings(accounts)
+	return fmt.Sprintf("%s|%s|%s|%.3f", t.Date(), accounts[0], accounts[1], math.Abs(t.Amount()))
+}
+
+func isTransfer(t financialimporter.Transaction) bool {
+	if transfer, ok := t.(financialimporter.TransferTransaction); ok {
+		return transfer.TransferAccount() != ""
+	}
+	return t.TransactionType() == financialimporter.Transfer
+}
+
+func transferAccount(t financialimporter.Transaction) string {
+	if transfer, ok := t.(financialimporter.TransferTransaction); ok {
+		return transfer.TransferAccount()
+	}
+	return strings.TrimPrefix(t.Payee(), "Transfer : ")
+}
+
+func (g *Generator) transaction(b Budget, t financialimporter.Transaction) error {
+	postings := []posting{{account: g.assetAccount(b, t.Account()), amount: t.Amount()}}
+
+	splits := []financialimporter.Transaction{t}
+	if t.HasSubTransactions() {
+		splits = t.SubTransactions()
+	}
+
+	for _, split := range splits {
+		p := posting{account: g.counterAccount(b, split), amount: -split.Amount()}
+		if t.HasSubTransactions() && split.Memo() != t.Memo() {
+			p.comment = split.Memo()
+		}
+		postings = append(postings, p)
+	}
+
+	for _, p := range postings {
+		if err := g.open(t.Date(), p.account); err != nil {
+			return err
+		}
+	}
+
+	tags := t.Tags()
+	for _, split := range splits {
+		for _, tag := range split.Tags() {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	if g.format == FormatBeancount {
+		fmt.Fprintf(&sb, "%s * %s \"\"", t.Date(), quote(t.Payee()))
+		for _, tag := range tags {
+			if tag = beancountTagRegex.ReplaceAllString(tag, "-"); tag != "" {
+				sb.WriteString(" #" + tag)
+			}
+		}
+		sb.WriteString("\n")
+	} else {
+		fmt.Fprintf(&sb, "%s * %s\n", t.Date(), singleLine(t.Payee()))
+		if ledgerTags := g.ledgerTags(tags); ledgerTags != "" {
+			sb.WriteString("    ; " + ledgerTags + "\n")
+		}
+	}
+
+	if memo := singleLine(t.Memo()); memo != "" {
+		sb.WriteString("    ; " + memo + "\n")
+	}
+
+	for _, p := range postings {
+		fmt.Fprintf(&sb, "    %-60s %12s %s", p.account, formatAmount(p.amount), b.Currency)
+		if comment := singleLine(p.comment); comment != "" {
+			sb.WriteString("  ; " + comment)
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+
+	_, err := io.WriteString(g.w, sb.String())
+	return err
+}
+
+// ledgerTags are :a:b: for ledger and a:, b: for hledger
+func (g *Generator) ledgerTags(tags []string) string {
+	cleaned := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.NewReplacer(":", "-", ",", "-", " ", "-").Replace(tag)
+		if tag != "" {
+			cleaned = append(cleaned, tag)
+		}
+	}
+
+	if len(cleaned) == 0 {
+		return ""
+	}
+	if g.format == FormatHledger {
+		return strings.Join(cleaned, ":, ") + ":"
+	}
+	return ":" + strings.Join(cleaned, ":") + ":"
+}
+
+// open declares an account the first time it is used, beancount needs an open directive and ledger and hledger use
+// the declarations in strict mode
+func (g *Generator) open(date, account string) error {
+	if g.opened[account] {
+		return nil
+	}
+	g.opened[account] = true
+
+	var err error
+	if g.format == FormatBeancount {
+		_, err = fmt.Fprintf(g.w, "%s open %s\n\n", date, account)
+	} else {
+		_, err = fmt.Fprintf(g.w, "account %s\n\n", account)
+	}
+	return err
+}
+
+func (g *Generator) assetAccount(b Budget, account string) string {
+	root := "Assets"
+	if slices.Contains(liabilityAccountTypes, b.AccountTypes[account]) {
+		root = "Liabilities"
+	}
+	return g.accountName(root, b.Name, account)
+}
+
+// counterAccount is the other side of the transaction, the transfer account, income by payee or the expense category
+func (g *Generator) counterAccount(b Budget, t financialimporter.Transaction) string {
+	switch {
+	case isTransfer(t):
+		return g.assetAccount(b, transferAccount(t))
+	case t.Payee() == startingBalancePayee:
+		return g.accountName("Equity", "Opening Balances")
+	case t.CategoryGroup() == inflowCategoryGroup || (t.Category() == "" && t.Amount() > 0):
+		if t.Payee() == "" {
+			return g.accountName("Income", "Other")
+		}
+		return g.accountName("Income", t.Payee())
+	case t.Category() == "":
+		return g.accountName("Expenses", "Uncategorized")
+	}
+
+	if t.CategoryGroup() == "" {
+		return g.accountName("Expenses", t.Category())
+	}
+	return g.accountName("Expenses", t.CategoryGroup(), t.Category())
+}
+
+// accountName joins the parts with : after making each part valid for the format. Beancount parts are capitalized and
+// only letters, digits and dashes, ledger parts can't contain : or runs of spaces
+func (g *Generator) accountName(root string, parts ...string) string {
+	names := []string{root}
+	for _, part := range parts {
+		var name string
+		if g.format == FormatBeancount {
+			name = beancountAccountPart(part)
+		} else {
+			name = strings.Join(strings.Fields(strings.ReplaceAll(part, ":", "-")), " ")
+		}
+
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ":")
+}
+
+func beancountAccountPart(part string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range part {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && sb.Len() > 0 {
+				sb.WriteRune('-')
+			}
+			dash = false
+			sb.WriteRune(r)
+		} else {
+			dash = true
+		}
+	}
+
+	name := []rune(sb.String())
+	if len(name) == 0 {
+		return ""
+	}
+	if !unicode.IsDigit(name[0]) {
+		name[0] = unicode.ToUpper(name[0])
+	}
+	if !unicode.IsUpper(name[0]) && !unicode.IsDigit(name[0]) {
+		return "X" + string(name)
+	}
+	return string(name)
+}
+
+func formatAmount(amount float64) string {
+	s := strconv.FormatFloat(amount, 'f', 2, 64)
+	if s == "-0.00" {
+		return "0.00"
+	}
+	return s
+}
+
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func quote(s string) string {
+	return strconv.Quote(singleLine(s))
+}
@@ -0,0 +1,103 @@
+package journal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testTransaction struct {
+	date, payee, category, group, memo, account, transfer, key string
+	amount                                                     float64
+	tags                                                       []string
+	subs                                                       []financialimporter.Transaction
+}
+
+func (t *testTransaction) Date() string          { return t.date }
+func (t *testTransaction) Payee() string         { return t.payee }
+func (t *testTransaction) Category() string      { return t.category }
+func (t *testTransaction) CategoryGroup() string { return t.group }
+func (t *testTransaction) Memo() string          { return t.memo }
+func (t *testTransaction) Amount() float64       { return t.amount }
+func (t *testTransaction) Tags() []string        { return t.tags }
+func (t *testTransaction) Account() string       { return t.account }
+func (t *testTransaction) IndexKey() string      { return t.key }
+func (t *testTransaction) TransferAccount() string {
+	return t.transfer
+}
+func (t *testTransaction) HasSubTransactions() bool { return len(t.subs) > 0 }
+func (t *testTransaction) SubTransactions() []financialimporter.Transaction {
+	return t.subs
+}
+func (t *testTransaction) TransactionType() financialimporter.TransactionType {
+	if t.transfer != "" {
+		return financialimporter.Transfer
+	}
+	if t.amount >= 0 {
+		return financialimporter.Income
+	}
+	return financialimporter.Expense
+}
+
+func testBudget() Budget {
+	return Budget{
+		Name:         "Personal",
+		Currency:     "CAD",
+		AccountTypes: map[string]string{"Chequing": "checking", "Visa": "creditCard"},
+		Transactions: []financialimporter.Transaction{
+			&testTransaction{key: "3", date: "2024-01-03", payee: "Transfer : Chequing", account: "Visa", transfer: "Chequing", amount: 100},
+			&testTransaction{key: "2", date: "2024-01-03", payee: "Transfer : Visa", account: "Chequing", transfer: "Visa", amount: -100},
+			&testTransaction{key: "1", date: "2024-01-02", payee: "Costco", account: "Visa", amount: -80, memo: "weekly shop", tags: []string{"food"}, subs: []financialimporter.Transaction{
+				&testTransaction{key: "1a", date: "2024-01-02", payee: "Costco", account: "Visa", amount: -50, category: "Groceries", group: "Everyday Expenses", memo: "weekly shop"},
+				&testTransaction{key: "1b", date: "2024-01-02", payee: "Costco", account: "Visa", amount: -30, category: "Home & Garden", group: "Everyday Expenses", memo: "patio chairs"},
+			}},
+		},
+	}
+}
+
+func TestLedgerJournal(t *testing.T) {
+	var buf bytes.Buffer
+	g, err := NewGenerator(&buf, FormatLedger)
+	require.NoError(t, err)
+	require.NoError(t, g.Prices(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "CAD", map[string]float64{"USD": 1.35, "CAD": 1}))
+	require.NoError(t, g.Budget(testBudget()))
+
+	out := buf.String()
+	assert.Contains(t, out, "P 2024-01-01 USD 1.35 CAD\n")
+	assert.NotContains(t, out, "P 2024-01-01 CAD")
+	assert.Contains(t, out, "2024-01-02 * Costco\n    ; :food:\n    ; weekly shop\n")
+	assert.Contains(t, out, "Expenses:Everyday Expenses:Home & Garden")
+	assert.Contains(t, out, "30.00 CAD  ; patio chairs\n")
+	assert.Contains(t, out, "Liabilities:Personal:Visa")
+	// the transfer is written once
+	assert.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("* Transfer")))
+	assert.Less(t, bytes.Index(buf.Bytes(), []byte("Costco")), bytes.Index(buf.Bytes(), []byte("Transfer")))
+}
+
+func TestBeancountJournal(t *testing.T) {
+	var buf bytes.Buffer
+	g, err := NewGenerator(&buf, FormatBeancount)
+	require.NoError(t, err)
+	require.NoError(t, g.Options("YNAB", []string{"CAD"}))
+	require.NoError(t, g.Budget(testBudget()))
+
+	out := buf.String()
+	assert.Contains(t, out, "option \"operating_currency\" \"CAD\"\n")
+	assert.Contains(t, out, "2024-01-02 open Expenses:Everyday-Expenses:Home-Garden\n")
+	assert.Contains(t, out, "2024-01-02 * \"Costco\" \"\" #food\n")
+	assert.Contains(t, out, "2024-01-03 open Assets:Personal:Chequing\n")
+	assert.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("open Liabilities:Personal:Visa")))
+}
+
+func TestHledgerTags(t *testing.T) {
+	g, err := NewGenerator(&bytes.Buffer{}, FormatHledger)
+	require.NoError(t, err)
+	assert.Equal(t, "food:, trip-2024:", g.ledgerTags([]string{"food", "trip 2024"}))
+
+	_, err = NewGenerator(&bytes.Buffer{}, "gnucash")
+	assert.Error(t, err)
+}
@@ -0,0 +1,582 @@
+package journal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	dateRegex         = regexp.MustCompile(`^(\d{4})[-/.](\d{1,2})[-/.](\d{1,2})(=\S*)?$`)
+	metadataRegex     = regexp.MustCompile(`^[a-z][A-Za-z0-9\-_]*:(\s|$)`)
+	hledgerTagRegex   = regexp.MustCompile(`([^\s:,]+):([^,]*)`)
+	amountRegex       = regexp.MustCompile(`^([-+])?\s*([^-+\d\s.,"]+|"[^"]+")?\s*([-+])?\s*(\d[\d,]*(?:\.\d*)?|\.\d+)\s*([^-+\d\s.,"]+|"[^"]+")?$`)
+	postingSplitRegex = regexp.MustCompile(`\s{2,}|\t`)
+)
+
+// Posting is one leg of an entry
+type Posting struct {
+	Account string
+	Amount  float64
+	// Commodity as written in the file, empty for amounts without one
+	Commodity string
+	// Price of one unit in PriceCommodity, from @, @@ or a {cost}
+	Price          float64
+	PriceCommodity string
+	// set while parsing when the amount is left out for the entry to balance
+	elided bool
+}
+
+// weight is the amount the posting contributes to balancing the entry
+func (p Posting) weight() (float64, string) {
+	if p.PriceCommodity != "" {
+		return p.Amount * p.Price, p.PriceCommodity
+	}
+	return p.Amount, p.Commodity
+}
+
+// Entry is a transaction with its postings, postings without an amount have it inferred so every entry balances
+type Entry struct {
+	Date      time.Time
+	Payee     string
+	Narration string
+	Tags      []string
+	Postings  []Posting
+	// Line of the file the entry starts on
+	Line int
+}
+
+// Open declares an account, Date is zero for hledger account directives
+type Open struct {
+	Date        time.Time
+	Account     string
+	Commodities []string
+}
+
+type Close struct {
+	Date    time.Time
+	Account string
+}
+
+// Balance asserts the balance of an account at the start of Date. hledger assertions are after the posting they are on,
+// they are kept as the balance at the start of the next day
+type Balance struct {
+	Date      time.Time
+	Account   string
+	Amount    float64
+	Commodity string
+}
+
+// Journal is the parsed content of a beancount or hledger file
+type Journal struct {
+	Entries  []Entry
+	Opens    []Open
+	Closes   []Close
+	Balances []Balance
+}
+
+// FormatOf guesses the format of a file from its extension
+func FormatOf(path string) string {
+	switch filepath.Ext(path) {
+	case ".beancount", ".bean":
+		return FormatBeancount
+	}
+	return FormatHledger
+}
+
+// ParseFile parses a beancount or hledger file, format defaults to the format of the extension
+func ParseFile(path, format string) (*Journal, error) {
+	if format == "" {
+		format = FormatOf(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	j, err := Parse(f, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return j, nil
+}
+
+// Parse reads a beancount or hledger journal. Only the directives needed for transactions and balances are read,
+// prices, includes, periodic and automated transactions are skipped
+func Parse(r io.Reader, format string) (*Journal, error) {
+	p := &parser{format: format, journal: &Journal{}, pushed: []string{}}
+
+	switch format {
+	case FormatBeancount:
+		p.parseLine = p.beancountLine
+	case FormatHledger, FormatLedger:
+		p.parseLine = p.hledgerLine
+	default:
+		return nil, fmt.Errorf("unknown journal format %s", format)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.line++
+		if err := p.parseLine(strings.TrimRight(scanner.Text(), " \t\r")); err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := p.finishEntry(); err != nil {
+		return nil, err
+	}
+
+	return p.journal, nil
+}
+
+type parser struct {
+	format    string
+	journal   *Journal
+	parseLine func(line string) error
+	line      int
+	// entry that postings are added to, nil when indented lines belong to a skipped directive
+	entry *Entry
+	// beancount pushtag
+	pushed []string
+}
+
+func (p *parser) finishEntry() error {
+	if p.entry == nil {
+		return nil
+	}
+
+	entry := p.entry
+	p.entry = nil
+
+	err := balanceEntry(entry)
+	if err != nil {
+		return fmt.Errorf("entry on line %d: %w", entry.Line, err)
+	}
+
+	p.journal.Entries = append(p.journal.Entries, *entry)
+	return nil
+}
+
+// balanceEntry fills in the amount of the posting without one
+func balanceEntry(entry *Entry) error {
+	missing := -1
+	sums := map[string]float64{}
+	for i, posting := range entry.Postings {
+		if posting.elided {
+			if missing != -1 {
+				return fmt.Errorf("more than one posting without an amount")
+			}
+			missing = i
+			continue
+		}
+
+		amount, commodity := posting.weight()
+		sums[commodity] += amount
+	}
+
+	if missing == -1 {
+		return nil
+	}
+
+	entry.Postings[missing].elided = false
+	if len(sums) != 1 {
+		return fmt.Errorf("can't infer the amount of %s from postings in %d commodities", entry.Postings[missing].Account, len(sums))
+	}
+
+	for commodity, sum := range sums {
+		entry.Postings[missing].Amount = -math.Round(sum*1e8) / 1e8
+		entry.Postings[missing].Commodity = commodity
+	}
+	return nil
+}
+
+func (p *parser) beancountLine(line string) error {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, ";") {
+		return nil
+	}
+
+	if line[0] == ' ' || line[0] == '\t' {
+		if p.entry == nil || metadataRegex.MatchString(trimmed) {
+			return nil
+		}
+		return p.beancountPosting(trimmed)
+	}
+
+	if err := p.finishEntry(); err != nil {
+		return err
+	}
+
+	fields := strings.Fields(stripComment(trimmed))
+	switch fields[0] {
+	case "pushtag":
+		if len(fields) > 1 {
+			p.pushed = append(p.pushed, strings.TrimPrefix(fields[1], "#"))
+		}
+		return nil
+	case "poptag":
+		if len(fields) > 1 {
+			tag := strings.TrimPrefix(fields[1], "#")
+			for i := len(p.pushed) - 1; i >= 0; i-- {
+				if p.pushed[i] == tag {
+					p.pushed = append(p.pushed[:i], p.pushed[i+1:]...)
+					break
+				}
+			}
+		}
+		return nil
+	}
+
+	date, err := parseDate(fields[0])
+	if err != nil || len(fields) < 2 {
+		// option, plugin, include and anything else without a date
+		return nil
+	}
+
+	switch fields[1] {
+	case "open":
+		if len(fields) < 3 {
+			return fmt.Errorf("open without an account")
+		}
+		open := Open{Date: date, Account: fields[2]}
+		if len(fields) > 3 && !strings.HasPrefix(fields[3], `"`) {
+			open.Commodities = strings.Split(fields[3], ",")
+		}
+		p.journal.Opens = append(p.journal.Opens, open)
+	case "close":
+		if len(fields) < 3 {
+			return fmt.Errorf("close without an account")
+		}
+		p.journal.Closes = append(p.journal.Closes, Close{Date: date, Account: fields[2]})
+	case "balance":
+		if len(fields) < 5 {
+			return fmt.Errorf("balance needs an account, amount and commodity")
+		}
+		amount, err := parseNumber(fields[3])
+		if err != nil {
+			return err
+		}
+		// the amount can have a tolerance, 100.00 ~ 0.01 USD
+		commodity := fields[4]
+		if commodity == "~" && len(fields) > 6 {
+			commodity = fields[6]
+		}
+		p.journal.Balances = append(p.journal.Balances, Balance{Date: date, Account: fields[2], Amount: amount, Commodity: commodity})
+	case "txn", "*", "!":
+		header := strings.TrimSpace(trimmed[len(fields[0]):])
+		return p.beancountHeader(date, header[len(fields[1]):])
+	}
+
+	return nil
+}
+
+func (p *parser) beancountHeader(date time.Time, rest string) error {
+	entry := &Entry{Date: date, Line: p.line, Tags: append([]string{}, p.pushed...)}
+
+	strs := []string{}
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		switch rest[0] {
+		case '"':
+			s, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return fmt.Errorf("invalid string in %s: %w", rest, err)
+			}
+			unquoted, err := strconv.Unquote(s)
+			if err != nil {
+				return err
+			}
+			strs = append(strs, unquoted)
+			rest = rest[len(s):]
+		case ';':
+			rest = ""
+		default:
+			token, remaining, _ := strings.Cut(rest, " ")
+			if strings.HasPrefix(token, "#") || strings.HasPrefix(token, "^") {
+				entry.Tags = appendUnique(entry.Tags, token[1:])
+			}
+			rest = remaining
+		}
+	}
+
+	switch len(strs) {
+	case 0:
+	case 1:
+		entry.Narration = strs[0]
+	default:
+		entry.Payee = strs[0]
+		entry.Narration = strs[1]
+	}
+
+	p.entry = entry
+	return nil
+}
+
+func (p *parser) beancountPosting(line string) error {
+	fields := strings.Fields(stripComment(line))
+	if len(fields) > 0 && (fields[0] == "!" || fields[0] == "*") {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	posting := Posting{Account: fields[0]}
+	if len(fields) == 1 {
+		posting.elided = true
+		p.entry.Postings = append(p.entry.Postings, posting)
+		return nil
+	}
+	if len(fields) < 3 {
+		return fmt.Errorf("posting to %s needs an amount and commodity", fields[0])
+	}
+
+	var err error
+	posting.Amount, err = parseNumber(fields[1])
+	if err != nil {
+		return err
+	}
+	posting.Commodity = fields[2]
+
+	err = parsePrice(&posting, strings.Join(fields[3:], " "))
+	if err != nil {
+		return err
+	}
+
+	p.entry.Postings = append(p.entry.Postings, posting)
+	return nil
+}
+
+// parsePrice reads the {cost}, @ unit price or @@ total price after the amount of a posting
+func parsePrice(posting *Posting, rest string) error {
+	if cost, after, ok := strings.Cut(rest, "{"); ok && strings.TrimSpace(cost) == "" {
+		cost, rest, _ = strings.Cut(after, "}")
+		cost = strings.Trim(cost, "{ ")
+		fields := strings.Fields(strings.Split(cost, ",")[0])
+		if len(fields) >= 2 {
+			price, err := parseNumber(fields[0])
+			if err != nil {
+				return err
+			}
+			posting.Price, posting.PriceCommodity = price, fields[1]
+		}
+		rest = strings.TrimSpace(rest)
+	}
+
+	total := strings.HasPrefix(rest, "@@")
+	if !strings.HasPrefix(rest, "@") {
+		return nil
+	}
+
+	amount, commodity, err := parseAmount(strings.TrimLeft(rest, "@ "))
+	if err != nil {
+		return err
+	}
+	if total && posting.Amount != 0 {
+		amount = math.Abs(amount / posting.Amount)
+	}
+	posting.Price, posting.PriceCommodity = amount, commodity
+	return nil
+}
+
+func (p *parser) hledgerLine(line string) error {
+	if strings.TrimSpace(line) == "" {
+		return p.finishEntry()
+	}
+
+	if line[0] == ' ' || line[0] == '\t' {
+		trimmed := strings.TrimSpace(line)
+		if p.entry == nil {
+			return nil
+		}
+		if strings.HasPrefix(trimmed, ";") || strings.HasPrefix(trimmed, "#") {
+			p.entry.Tags = appendUnique(p.entry.Tags, hledgerTags(trimmed[1:])...)
+			return nil
+		}
+		return p.hledgerPosting(trimmed)
+	}
+
+	if err := p.finishEntry(); err != nil {
+		return err
+	}
+
+	switch line[0] {
+	case ';', '#', '*', '%', '|', '~', '=':
+		// comments, periodic and automated transactions
+		return nil
+	}
+
+	fields := strings.Fields(line)
+	if fields[0] == "account" && len(fields) > 1 {
+		name := strings.TrimSpace(postingSplitRegex.Split(stripComment(strings.TrimSpace(line[len("account"):])), 2)[0])
+		p.journal.Opens = append(p.journal.Opens, Open{Account: name})
+		return nil
+	}
+
+	date, err := parseDate(fields[0])
+	if err != nil {
+		// P, commodity, include, alias and the other directives
+		return nil
+	}
+
+	entry := &Entry{Date: date, Line: p.line}
+
+	header := strings.TrimSpace(line[len(fields[0]):])
+	header, comment, _ := strings.Cut(header, ";")
+	entry.Tags = hledgerTags(comment)
+
+	header = strings.TrimSpace(strings.TrimLeft(header, "*! "))
+	if strings.HasPrefix(header, "(") {
+		if _, after, ok := strings.Cut(header, ")"); ok {
+			header = strings.TrimSpace(after)
+		}
+	}
+
+	if payee, note, ok := strings.Cut(header, "|"); ok {
+		entry.Payee = strings.TrimSpace(payee)
+		entry.Narration = strings.TrimSpace(note)
+	} else {
+		entry.Payee = header
+	}
+
+	p.entry = entry
+	return nil
+}
+
+func (p *parser) hledgerPosting(line string) error {
+	line, comment, _ := strings.Cut(line, ";")
+	p.entry.Tags = appendUnique(p.entry.Tags, hledgerTags(comment)...)
+
+	line = strings.TrimSpace(strings.TrimLeft(line, "*! "))
+	parts := postingSplitRegex.Split(line, 2)
+
+	// (account) and [account] are virtual postings
+	posting := Posting{Account: strings.Trim(parts[0], "()[]")}
+	rest := ""
+	if len(parts) > 1 {
+		rest = strings.TrimSpace(parts[1])
+	}
+
+	rest, assertion, hasAssertion := strings.Cut(rest, "=")
+	rest = strings.TrimSpace(rest)
+
+	if rest == "" {
+		posting.elided = true
+	} else {
+		amount, price, _ := strings.Cut(rest, "@")
+		var err error
+		posting.Amount, posting.Commodity, err = parseAmount(amount)
+		if err != nil {
+			return err
+		}
+		if price != "" {
+			if err := parsePrice(&posting, "@"+price); err != nil {
+				return err
+			}
+		}
+	}
+	p.entry.Postings = append(p.entry.Postings, posting)
+
+	if hasAssertion {
+		amount, commodity, err := parseAmount(strings.TrimLeft(assertion, "=* "))
+		if err != nil {
+			return err
+		}
+		p.journal.Balances = append(p.journal.Balances, Balance{Date: p.entry.Date.AddDate(0, 0, 1), Account: posting.Account, Amount: amount, Commodity: commodity})
+	}
+
+	return nil
+}
+
+// hledgerTags are the names of the name: and name:value tags in a comment
+func hledgerTags(comment string) []string {
+	tags := []string{}
+	for _, match := range hledgerTagRegex.FindAllStringSubmatch(comment, -1) {
+		// date: and date2: set posting dates, they aren't tags
+		if match[1] != "date" && match[1] != "date2" {
+			tags = append(tags, match[1])
+		}
+	}
+	return tags
+}
+
+// parseAmount reads amounts like $-1,000.50, -10 USD or EUR 5, the commodity is empty when there isn't one
+func parseAmount(s string) (float64, string, error) {
+	s = strings.TrimSpace(s)
+	match := amountRegex.FindStringSubmatch(s)
+	if match == nil {
+		return 0, "", fmt.Errorf("invalid amount %q", s)
+	}
+
+	amount, err := parseNumber(match[4])
+	if err != nil {
+		return 0, "", err
+	}
+	if match[1] == "-" || match[3] == "-" {
+		amount = -amount
+	}
+
+	commodity := match[2]
+	if commodity == "" {
+		commodity = match[5]
+	}
+	return amount, strings.Trim(commodity, `"`), nil
+}
+
+func parseNumber(s string) (float64, error) {
+	n, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	match := dateRegex.FindStringSubmatch(s)
+	if match == nil {
+		return time.Time{}, fmt.Errorf("invalid date %s", s)
+	}
+
+	year, _ := strconv.Atoi(match[1])
+	month, _ := strconv.Atoi(match[2])
+	day, _ := strconv.Atoi(match[3])
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC), nil
+}
+
+// stripComment removes a trailing ; comment outside of quotes
+func stripComment(s string) string {
+	quoted := false
+	for i, r := range s {
+		switch r {
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				return strings.TrimSpace(s[:i])
+			}
+		}
+	}
+	return s
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		if item != "" && !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,132 @@
+package journal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBeancount = `option "operating_currency" "CAD"
+
+2024-01-01 open Assets:Bank:Chequing CAD
+2024-01-01 open Liabilities:Visa CAD
+2024-01-01 open Expenses:Food:Groceries
+
+pushtag #household
+
+2024-01-02 * "Costco" "weekly shop" #food ^receipt-12
+  receipt: "costco.pdf"
+  Liabilities:Visa                 -80.00 CAD
+  Expenses:Food:Groceries           50.00 CAD
+  Expenses:Home:Garden              30.00 CAD ; patio chairs
+
+poptag #household
+
+2024-01-03 * "Pay off visa"
+  Assets:Bank:Chequing            -100.00 CAD
+  Liabilities:Visa
+
+2024-01-04 balance Liabilities:Visa  20.00 CAD
+2024-02-01 close Liabilities:Visa
+`
+
+const testHledger = `; groceries and rent
+account assets:checking  ; type: A
+
+2024/01/02 * (42) Grocer | milk and eggs  ; food:, trip:paris
+    expenses:food          $12.50
+    assets:checking
+
+2024-01-03 Landlord
+    ; rent:
+    expenses:rent          1,200.00 CAD @ 0.75 USD
+    assets:checking        $-900.00 = $-887.50
+
+~ monthly
+    expenses:rent  1200 CAD
+    assets:checking
+`
+
+func TestParseBeancount(t *testing.T) {
+	j, err := Parse(strings.NewReader(testBeancount), FormatBeancount)
+	require.NoError(t, err)
+
+	require.Len(t, j.Entries, 2)
+	costco := j.Entries[0]
+	assert.Equal(t, "Costco", costco.Payee)
+	assert.Equal(t, "weekly shop", costco.Narration)
+	assert.Equal(t, []string{"household", "food", "receipt-12"}, costco.Tags)
+	assert.Len(t, costco.Postings, 3)
+	assert.Equal(t, []string{}, j.Entries[1].Tags)
+	assert.Equal(t, Posting{Account: "Liabilities:Visa", Amount: 100, Commodity: "CAD"}, j.Entries[1].Postings[1])
+
+	assert.Len(t, j.Opens, 3)
+	assert.Equal(t, []string{"CAD"}, j.Opens[0].Commodities)
+	assert.Equal(t, []Close{{Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), Account: "Liabilities:Visa"}}, j.Closes)
+	assert.Equal(t, []Balance{{Date: time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC), Account: "Liabilities:Visa", Amount: 20, Commodity: "CAD"}}, j.Balances)
+}
+
+func TestParseHledger(t *testing.T) {
+	j, err := Parse(strings.NewReader(testHledger), FormatHledger)
+	require.NoError(t, err)
+
+	require.Len(t, j.Entries, 2)
+	grocer := j.Entries[0]
+	assert.Equal(t, "Grocer", grocer.Payee)
+	assert.Equal(t, "milk and eggs", grocer.Narration)
+	assert.Equal(t, []string{"food", "trip"}, grocer.Tags)
+	assert.Equal(t, Posting{Account: "assets:checking", Amount: -12.5, Commodity: "$"}, grocer.Postings[1])
+
+	rent := j.Entries[1]
+	assert.Equal(t, []string{"rent"}, rent.Tags)
+	assert.Equal(t, Posting{Account: "expenses:rent", Amount: 1200, Commodity: "CAD", Price: 0.75, PriceCommodity: "USD"}, rent.Postings[0])
+
+	assert.Equal(t, []Open{{Account: "assets:checking"}}, j.Opens)
+	assert.Equal(t, []Balance{{Date: time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC), Account: "assets:checking", Amount: -887.5, Commodity: "$"}}, j.Balances)
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse(strings.NewReader("2024-01-01 * \"x\"\n  Assets:Cash\n  Expenses:Food\n"), FormatBeancount)
+	assert.ErrorContains(t, err, "more than one posting without an amount")
+
+	_, err = Parse(strings.NewReader("2024-01-01 x\n    assets:cash  ten dollars\n"), FormatHledger)
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestJournalTransactions(t *testing.T) {
+	j, err := Parse(strings.NewReader(testBeancount), FormatBeancount)
+	require.NoError(t, err)
+
+	transactions := j.Transactions("books")
+	require.Len(t, transactions, 2)
+
+	costco := transactions[0]
+	assert.Equal(t, "Liabilities:Visa", costco.Account())
+	assert.Equal(t, -80.0, costco.Amount())
+	assert.Equal(t, "journal::books", costco.BudgetID())
+	assert.True(t, costco.HasSubTransactions())
+	assert.Equal(t, financialimporter.Expense, costco.TransactionType())
+
+	subs := costco.SubTransactions()
+	require.Len(t, subs, 2)
+	assert.Equal(t, "Groceries", subs[0].Category())
+	assert.Equal(t, "Food", subs[0].CategoryGroup())
+	assert.Equal(t, -50.0, subs[0].Amount())
+	assert.Equal(t, costco.IndexKey()+"::1", subs[1].IndexKey())
+
+	transfer := transactions[1]
+	assert.Equal(t, "Assets:Bank:Chequing", transfer.Account())
+	assert.Equal(t, "Liabilities:Visa", transfer.TransferAccount())
+	assert.Equal(t, financialimporter.Transfer, transfer.TransactionType())
+	assert.Equal(t, "Pay off visa", transfer.Payee())
+	assert.Equal(t, "", transfer.Category())
+
+	// keys only depend on the entry
+	again, err := Parse(strings.NewReader("; moved\n"+testBeancount), FormatBeancount)
+	require.NoError(t, err)
+	assert.Equal(t, costco.IndexKey(), again.Transactions("books")[0].IndexKey())
+}
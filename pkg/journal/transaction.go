@@ -0,0 +1,238 @@
+package journal
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+)
+
+// SourceID is the budget id of the transactions and accounts of a journal named source
+func SourceID(source string) string {
+	return "journal::" + source
+}
+
+// IsBalanceAccount is true for asset and liability accounts, the accounts with a balance like ynab accounts. The other
+// accounts are categories
+func IsBalanceAccount(account string) bool {
+	root, _, _ := strings.Cut(strings.ToLower(account), ":")
+	return strings.HasPrefix(root, "asset") || strings.HasPrefix(root, "liabilit")
+}
+
+// IsLiabilityAccount is true for accounts under Liabilities
+func IsLiabilityAccount(account string) bool {
+	root, _, _ := strings.Cut(strings.ToLower(account), ":")
+	return strings.HasPrefix(root, "liabilit")
+}
+
+// Transaction is an entry from the point of view of its first asset or liability posting, the other postings are the
+// category or transfer account. Entries with more than one other posting are split into a sub transaction per posting
+type Transaction struct {
+	Entry  *Entry
+	Source string
+	// Posting is the account side of the entry
+	Posting Posting
+	// Counter are the other postings, one for sub transactions
+	Counter []Posting
+	key     string
+	amount  float64
+}
+
+// Transactions maps the entries to transactions, source names the journal in keys and ids
+func (j *Journal) Transactions(source string) []*Transaction {
+	transactions := make([]*Transaction, 0, len(j.Entries))
+	seen := map[string]int{}
+
+	for i := range j.Entries {
+		entry := &j.Entries[i]
+		if len(entry.Postings) == 0 {
+			continue
+		}
+
+		primary := 0
+		for i, posting := range entry.Postings {
+			if IsBalanceAccount(posting.Account) {
+				primary = i
+				break
+			}
+		}
+
+		counter := make([]Posting, 0, len(entry.Postings)-1)
+		counter = append(counter, entry.Postings[:primary]...)
+		counter = append(counter, entry.Postings[primary+1:]...)
+
+		// identical entries on the same day get a count so they keep their own key
+		key := entryKey(source, entry)
+		seen[key]++
+		if seen[key] > 1 {
+			key = fmt.Sprintf("%s-%d", key, seen[key])
+		}
+
+		transactions = append(transactions, &Transaction{
+			Entry:   entry,
+			Source:  source,
+			Posting: entry.Postings[primary],
+			Counter: counter,
+			key:     key,
+			amount:  entry.Postings[primary].Amount,
+		})
+	}
+
+	return transactions
+}
+
+// entryKey hashes the content of an entry so the key doesn't change when entries are added above it
+func entryKey(source string, entry *Entry) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s|%s|%s|%s", source, entry.Date.Format("2006-01-02"), entry.Payee, entry.Narration)
+	for _, posting := range entry.Postings {
+		fmt.Fprintf(h, "|%s %f %s", posting.Account, posting.Amount, posting.Commodity)
+	}
+	return fmt.Sprintf("journal::%s::%s", entry.Date.Format("2006-01-02"), hex.EncodeToString(h.Sum(nil))[:16])
+}
+
+func (t *Transaction) Date() string {
+	return t.Entry.Date.Format("2006-01-02")
+}
+
+func (t *Transaction) Payee() string {
+	if t.Entry.Payee == "" {
+		return t.Entry.Narration
+	}
+	return t.Entry.Payee
+}
+
+// counter is the category or transfer posting of a transaction that isn't split
+func (t *Transaction) counter() (Posting, bool) {
+	if len(t.Counter) != 1 {
+		return Posting{}, false
+	}
+	return t.Counter[0], true
+}
+
+// Category is the last part of the counter account, Expenses:Food:Groceries is Groceries
+func (t *Transaction) Category() string {
+	counter, ok := t.counter()
+	if !ok || IsBalanceAccount(counter.Account) {
+		return ""
+	}
+
+	parts := strings.Split(counter.Account, ":")
+	return parts[len(parts)-1]
+}
+
+// CategoryGroup is the part of the counter account before the category, Expenses:Food:Groceries is Food and
+// Income:Salary is Income
+func (t *Transaction) CategoryGroup() string {
+	counter, ok := t.counter()
+	if !ok || IsBalanceAccount(counter.Account) {
+		return ""
+	}
+
+	parts := strings.Split(counter.Account, ":")
+	switch len(parts) {
+	case 1:
+		return ""
+	case 2:
+		return parts[0]
+	}
+	return strings.Join(parts[1:len(parts)-1], ":")
+}
+
+func (t *Transaction) Memo() string {
+	return t.Entry.Narration
+}
+
+func (t *Transaction) Amount() float64 {
+	return t.amount
+}
+
+// Currency is the commodity of the account side of the transaction
+func (t *Transaction) Currency() string {
+	return t.Posting.Commodity
+}
+
+func (t *Transaction) TransactionType() financialimporter.TransactionType {
+	if t.TransferAccount() != "" {
+		return financialimporter.Transfer
+	}
+
+	if t.Amount() >= 0 {
+		return financialimporter.Income
+	}
+
+	return financialimporter.Expense
+}
+
+func (t *Transaction) Tags() []string {
+	return t.Entry.Tags
+}
+
+func (t *Transaction) HasSubTransactions() bool {
+	return len(t.Counter) > 1
+}
+
+// SubTransactions has a sub transaction per counter posting, amounts are converted to the commodity of the account
+// with the posting's price when they are in another commodity
+func (t *Transaction) SubTransactions() []financialimporter.Transaction {
+	if !t.HasSubTransactions() {
+		return []financialimporter.Transaction{}
+	}
+
+	transactions := make([]financialimporter.Transaction, len(t.Counter))
+	for i, counter := range t.Counter {
+		amount, commodity := counter.weight()
+		if commodity != t.Posting.Commodity {
+			amount = counter.Amount
+		}
+
+		transactions[i] = &Transaction{
+			Entry:   t.Entry,
+			Source:  t.Source,
+			Posting: t.Posting,
+			Counter: []Posting{counter},
+			key:     fmt.Sprintf("%s::%d", t.key, i),
+			amount:  -amount,
+		}
+	}
+
+	return transactions
+}
+
+func (t *Transaction) Account() string {
+	return t.Posting.Account
+}
+
+func (t *Transaction) IndexKey() string {
+	return t.key
+}
+
+func (t *Transaction) TransferAccount() string {
+	counter, ok := t.counter()
+	if !ok || !IsBalanceAccount(counter.Account) {
+		return ""
+	}
+	return counter.Account
+}
+
+func (t *Transaction) BudgetID() string {
+	return SourceID(t.Source)
+}
+
+func (t *Transaction) AccountID() string {
+	return t.Posting.Account
+}
+
+func (t *Transaction) CategoryID() string {
+	counter, ok := t.counter()
+	if !ok || IsBalanceAccount(counter.Account) {
+		return ""
+	}
+	return counter.Account
+}
+
+func (t *Transaction) PayeeID() string {
+	return ""
+}
@@ -14,12 +14,18 @@ import (
 	"github.com/bcaldwell/selfops/pkg/config"
 )
 
+// CreatePostgresClient connects to dbname, which defaults to the ynab database. A database url always connects to its
+// database
 func CreatePostgresClient(dbname string) (*bun.DB, error) {
 	var pgconn *pgdriver.Connector
 
+	if dbname == "" {
+		dbname = config.CurrentYnabConfig().SQL.YnabDatabase
+	}
+
 	// bypass creating of db if database_url is set because we are likely running in heroku then
 	if config.CurrentSecrets().DatabaseURL == "" {
-		err := ensureDBExistsInPostgres()
+		err := ensureDBExistsInPostgres(dbname)
 		if err != nil {
 			return nil, err
 		}
@@ -29,7 +35,7 @@ func CreatePostgresClient(dbname string) (*bun.DB, error) {
 			pgdriver.WithInsecure(true),
 			pgdriver.WithUser(config.CurrentSqlSecrets().SqlUsername),
 			pgdriver.WithPassword(config.CurrentSqlSecrets().SqlPassword),
-			pgdriver.WithDatabase(config.CurrentYnabConfig().SQL.YnabDatabase),
+			pgdriver.WithDatabase(dbname),
 		)
 	} else {
 		// this panics if its invalid
@@ -51,7 +57,7 @@ func sqlHost() string {
 	return sqlHost
 }
 
-func ensureDBExistsInPostgres() error {
+func ensureDBExistsInPostgres(dbname string) error {
 	pgconn := pgdriver.NewConnector(
 		pgdriver.WithAddr(sqlHost()),
 		pgdriver.WithInsecure(true),
@@ -61,7 +67,7 @@ func ensureDBExistsInPostgres() error {
 	)
 
 	db := sql.OpenDB(pgconn)
-	rows, err := db.Query(fmt.Sprintf("SELECT datname FROM pg_database where datname = '%s'", config.CurrentYnabConfig().SQL.YnabDatabase))
+	rows, err := db.Query("SELECT datname FROM pg_database where datname = $1", dbname)
 	if err != nil {
 		return fmt.Errorf("Failed to get list of databases: %s", err)
 	}
@@ -69,10 +75,10 @@ func ensureDBExistsInPostgres() error {
 
 	// next meaning there is a row, all we care about is if there is a row
 	if !rows.Next() {
-		klog.Infof("Creating database %s in postgres database\n", config.CurrentYnabConfig().SQL.YnabDatabase)
-		_, err := db.Exec("CREATE DATABASE " + config.CurrentYnabConfig().SQL.YnabDatabase)
+		klog.Infof("Creating database %s in postgres database\n", dbname)
+		_, err := db.Exec("CREATE DATABASE " + dbname)
 		if err != nil {
-			return fmt.Errorf("failed to create database %s: %w", config.CurrentYnabConfig().SQL.YnabDatabase, err)
+			return fmt.Errorf("failed to create database %s: %w", dbname, err)
 		}
 	}
 
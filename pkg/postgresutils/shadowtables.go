@@ -0,0 +1,338 @@
+package postgresutils
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"k8s.io/klog"
+)
+
+const shadowSuffix = "_shadow"
+
+// ShadowTables stages writes for a run into shadow copies of the tables. The shadow tables replace the real tables in a
+// single transaction once the run finishes, so a failed run leaves the previous complete snapshot in place
+type ShadowTables struct {
+	db *bun.DB
+	mu sync.Mutex
+	// live table name to shadow table name
+	tables map[string]string
+}
+
+func NewShadowTables(db *bun.DB) *ShadowTables {
+	return &ShadowTables{
+		db:     db,
+		tables: make(map[string]string),
+	}
+}
+
+// Name registers tableName to be swapped in and returns the name of its shadow table
+func (s *ShadowTables) Name(tableName string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if shadow, ok := s.tables[tableName]; ok {
+		return shadow
+	}
+
+	shadow := tableName + shadowSuffix
+	s.tables[tableName] = shadow
+	return shadow
+}
+
+// CopyExisting copies the rows of tableName into its shadow table, for tables that are upserted rather than rebuilt every run.
+// Only columns that exist in both tables are copied so older table layouts are migrated
+func (s *ShadowTables) CopyExisting(tableName string) error {
+	shadow := s.Name(tableName)
+
+	liveColumns, err := TableColumns(s.db, tableName)
+	if err != nil {
+		return err
+	}
+	if len(liveColumns) == 0 {
+		return nil
+	}
+
+	shadowColumns, err := TableColumns(s.db, shadow)
+	if err != nil {
+		return err
+	}
+
+	columns := []bun.Ident{}
+	for _, c := range liveColumns {
+		if slices.Contains(shadowColumns, c) {
+			columns = append(columns, bun.Ident(c))
+		}
+	}
+
+	_, err = s.db.ExecContext(context.Background(), "INSERT INTO ? (?) SELECT ? FROM ?", bun.Ident(shadow), bun.In(columns), bun.In(columns), bun.Ident(tableName))
+	if err != nil {
+		return fmt.Errorf("failed to copy %s into %s: %w", tableName, shadow, err)
+	}
+
+	// keep the id sequence ahead of the copied ids
+	if slices.Contains(columns, bun.Ident("id")) {
+		_, err = s.db.ExecContext(context.Background(), "SELECT setval(pg_get_serial_sequence(?, 'id'), COALESCE(MAX(id), 0) + 1, false) FROM ?", shadow, bun.Ident(shadow))
+		if err != nil {
+			return fmt.Errorf("failed to update id sequence for %s: %w", shadow, err)
+		}
+	}
+
+	return nil
+}
+
+// Tables returns the live names of every registered table
+func (s *ShadowTables) Tables() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tables := make([]string, 0, len(s.tables))
+	for tableName := range s.tables {
+		tables = append(tables, tableName)
+	}
+	slices.Sort(tables)
+	return tables
+}
+
+// Diff compares the shadow table against the live table by keyColumn and returns how many rows the swap will insert, update
+// and delete. The id and updated_at columns are ignored when looking for updates
+func (s *ShadowTables) Diff(tableName, keyColumn string) (inserted, updated, deleted int, err error) {
+	shadow := s.Name(tableName)
+
+	liveColumns, err := TableColumns(s.db, tableName)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if len(liveColumns) == 0 {
+		err = s.db.NewSelect().TableExpr("?", bun.Ident(shadow)).ColumnExpr("count(*)").Scan(context.Background(), &inserted)
+		return inserted, 0, 0, err
+	}
+
+	err = s.db.QueryRowContext(context.Background(), `SELECT
+	count(*) FILTER (WHERE live.k IS NULL),
+	count(*) FILTER (WHERE live.k IS NOT NULL AND shadow.k IS NOT NULL AND live.doc IS DISTINCT FROM shadow.doc),
+	count(*) FILTER (WHERE shadow.k IS NULL)
+FROM (SELECT t.? AS k, to_jsonb(t) - 'id' - 'updated_at' AS doc FROM ? t) shadow
+FULL OUTER JOIN (SELECT t.? AS k, to_jsonb(t) - 'id' - 'updated_at' AS doc FROM ? t) live ON shadow.k = live.k`,
+		bun.Ident(keyColumn), bun.Ident(shadow), bun.Ident(keyColumn), bun.Ident(tableName),
+	).Scan(&inserted, &updated, &deleted)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to diff %s against %s: %w", shadow, tableName, err)
+	}
+
+	return inserted, updated, deleted, nil
+}
+
+// Swap replaces every registered table with its shadow table in one transaction. beforeSwap and afterSwap run in the same
+// transaction and are used to drop and recreate anything that depends on the old tables, like views. Other views on
+// the tables are dropped and recreated from their definition, which keeps their name and query but not their grants or
+// comments. The swap fails if a view uses a column that is no longer in the table
+func (s *ShadowTables) Swap(ctx context.Context, beforeSwap, afterSwap func(ctx context.Context, tx bun.Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tables := make([]string, 0, len(s.tables))
+	for tableName := range s.tables {
+		tables = append(tables, tableName)
+	}
+
+	return s.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if beforeSwap != nil {
+			if err := beforeSwap(ctx, tx); err != nil {
+				return err
+			}
+		}
+
+		views, err := dependentViews(ctx, tx, tables)
+		if err != nil {
+			return err
+		}
+
+		// views are ordered so every view comes after the views it uses
+		for i := len(views) - 1; i >= 0; i-- {
+			_, err := tx.ExecContext(ctx, "DROP "+views[i].kind()+" ?.?", bun.Ident(views[i].Schema), bun.Ident(views[i].Name))
+			if err != nil {
+				return fmt.Errorf("failed to drop view %s: %w", views[i].Name, err)
+			}
+		}
+
+		for tableName, shadow := range s.tables {
+			_, err := tx.ExecContext(ctx, "DROP TABLE IF EXISTS ?", bun.Ident(tableName))
+			if err != nil {
+				return fmt.Errorf("failed to drop %s: %w", tableName, err)
+			}
+
+			_, err = tx.ExecContext(ctx, "ALTER TABLE ? RENAME TO ?", bun.Ident(shadow), bun.Ident(tableName))
+			if err != nil {
+				return fmt.Errorf("failed to rename %s to %s: %w", shadow, tableName, err)
+			}
+
+			err = renameShadowObjects(ctx, tx, tableName, shadow)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, view := range views {
+			_, err := tx.ExecContext(ctx, "CREATE "+view.kind()+" ?.? AS ?", bun.Ident(view.Schema), bun.Ident(view.Name), bun.Safe(strings.TrimSuffix(strings.TrimSpace(view.Definition), ";")))
+			if err != nil {
+				return fmt.Errorf("failed to recreate view %s on the imported tables, it may use a column that was removed: %w", view.Name, err)
+			}
+		}
+
+		if afterSwap != nil {
+			return afterSwap(ctx, tx)
+		}
+		return nil
+	})
+}
+
+type dependentView struct {
+	Schema     string
+	Name       string
+	Kind       string
+	Definition string
+}
+
+func (v dependentView) kind() string {
+	if v.Kind == "m" {
+		return "MATERIALIZED VIEW"
+	}
+	return "VIEW"
+}
+
+// dependentViews returns the views and materialized views that use tables, directly or through other views, with
+// every view after the views it uses
+func dependentViews(ctx context.Context, tx bun.Tx, tables []string) ([]dependentView, error) {
+	views := []dependentView{}
+	if len(tables) == 0 {
+		return views, nil
+	}
+
+	err := tx.NewRaw(`WITH RECURSIVE deps(oid, depth) AS (
+	SELECT r.ev_class, 1
+	FROM pg_depend d
+	JOIN pg_rewrite r ON r.oid = d.objid
+	JOIN pg_class t ON t.oid = d.refobjid
+	WHERE d.classid = 'pg_rewrite'::regclass
+		AND d.refclassid = 'pg_class'::regclass
+		AND t.relnamespace = current_schema()::regnamespace
+		AND t.relname IN (?)
+		AND r.ev_class <> d.refobjid
+	UNION
+	SELECT r.ev_class, deps.depth + 1
+	FROM deps
+	JOIN pg_depend d ON d.refobjid = deps.oid AND d.classid = 'pg_rewrite'::regclass AND d.refclassid = 'pg_class'::regclass
+	JOIN pg_rewrite r ON r.oid = d.objid
+	WHERE r.ev_class <> deps.oid
+)
+SELECT n.nspname AS schema, c.relname AS name, c.relkind::text AS kind, pg_get_viewdef(c.oid) AS definition
+FROM (SELECT oid, max(depth) AS depth FROM deps GROUP BY oid) v
+JOIN pg_class c ON c.oid = v.oid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+ORDER BY v.depth, n.nspname, c.relname`, bun.In(tables)).Scan(ctx, &views)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find views on %v: %w", tables, err)
+	}
+
+	return views, nil
+}
+
+// renameShadowObjects renames the constraints, indexes and sequences postgres named after the shadow table to the
+// names they get on a table created as tableName, so the next shadow table gets the same names again
+func renameShadowObjects(ctx context.Context, tx bun.Tx, tableName, shadow string) error {
+	prefix := shadow + "_"
+	newName := func(name string) string {
+		return tableName + "_" + strings.TrimPrefix(name, prefix)
+	}
+
+	constraints := []string{}
+	err := tx.NewRaw("SELECT conname FROM pg_constraint WHERE conrelid = ?::regclass AND starts_with(conname, ?)", tableName, prefix).Scan(ctx, &constraints)
+	if err != nil {
+		return fmt.Errorf("failed to list constraints of %s: %w", tableName, err)
+	}
+	for _, name := range constraints {
+		// renaming a primary key or unique constraint also renames its index
+		_, err := tx.ExecContext(ctx, "ALTER TABLE ? RENAME CONSTRAINT ? TO ?", bun.Ident(tableName), bun.Ident(name), bun.Ident(newName(name)))
+		if err != nil {
+			return fmt.Errorf("failed to rename constraint %s of %s: %w", name, tableName, err)
+		}
+	}
+
+	indexes := []string{}
+	err = tx.NewRaw("SELECT c.relname FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid WHERE i.indrelid = ?::regclass AND starts_with(c.relname, ?)", tableName, prefix).Scan(ctx, &indexes)
+	if err != nil {
+		return fmt.Errorf("failed to list indexes of %s: %w", tableName, err)
+	}
+	for _, name := range indexes {
+		_, err := tx.ExecContext(ctx, "ALTER INDEX ? RENAME TO ?", bun.Ident(name), bun.Ident(newName(name)))
+		if err != nil {
+			return fmt.Errorf("failed to rename index %s of %s: %w", name, tableName, err)
+		}
+	}
+
+	sequences := []string{}
+	err = tx.NewRaw(`SELECT s.relname FROM pg_class s
+JOIN pg_depend d ON d.objid = s.oid AND d.classid = 'pg_class'::regclass AND d.refclassid = 'pg_class'::regclass
+WHERE s.relkind = 'S' AND d.deptype IN ('a', 'i') AND d.refobjid = ?::regclass AND starts_with(s.relname, ?)`, tableName, prefix).Scan(ctx, &sequences)
+	if err != nil {
+		return fmt.Errorf("failed to list sequences of %s: %w", tableName, err)
+	}
+	for _, name := range sequences {
+		_, err := tx.ExecContext(ctx, "ALTER SEQUENCE ? RENAME TO ?", bun.Ident(name), bun.Ident(newName(name)))
+		if err != nil {
+			return fmt.Errorf("failed to rename sequence %s of %s: %w", name, tableName, err)
+		}
+	}
+
+	return nil
+}
+
+// Discard drops all the shadow tables, leaving the live tables untouched
+func (s *ShadowTables) Discard() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for _, shadow := range s.tables {
+		_, err := s.db.ExecContext(context.Background(), "DROP TABLE IF EXISTS ? CASCADE", bun.Ident(shadow))
+		if err != nil {
+			klog.Errorf("Failed to drop shadow table %s: %s\n", shadow, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// TableColumns returns the columns of tableName in the current schema, empty if the table doesn't exist. SQLite
+// databases are supported for the migrations that also run on them
+func TableColumns(db bun.IDB, tableName string) ([]string, error) {
+	columns := []string{}
+	if db.Dialect().Name() == dialect.SQLite {
+		err := db.NewRaw("SELECT name FROM pragma_table_info(?)", tableName).Scan(context.Background(), &columns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get columns for %s: %w", tableName, err)
+		}
+		return columns, nil
+	}
+
+	err := db.NewSelect().
+		TableExpr("information_schema.columns").
+		Column("column_name").
+		Where("table_schema = current_schema()").
+		Where("table_name = ?", tableName).
+		Scan(context.Background(), &columns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns for %s: %w", tableName, err)
+	}
+
+	return columns, nil
+}
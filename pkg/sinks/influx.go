@@ -0,0 +1,189 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/influxHelper"
+	influx "github.com/influxdata/influxdb/client/v2"
+)
+
+// InfluxSink writes each dataset to a measurement. Points with the same tags and time replace each other, every row
+// has its key as a tag so rows are upserted by key
+type InfluxSink struct {
+	client   influx.Client
+	database string
+}
+
+func NewInfluxSink(database string) (*InfluxSink, error) {
+	client, err := influxHelper.CreateInfluxClient()
+	if err != nil {
+		return nil, fmt.Errorf("Error creating InfluxDB Client: %s", err.Error())
+	}
+
+	return &InfluxSink{
+		client:   client,
+		database: database,
+	}, nil
+}
+
+func (s *InfluxSink) Migrate(ctx context.Context, dataset Dataset) error {
+	err := influxHelper.CreateDatabase(s.client, s.database)
+	if err != nil {
+		return fmt.Errorf("Error creating DB: %s", err.Error())
+	}
+
+	if dataset.Replace {
+		err = influxHelper.DropMeasurement(s.client, s.database, dataset.Table)
+		if err != nil {
+			return fmt.Errorf("Error dropping measurement %s: %s", dataset.Table, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (s *InfluxSink) Upsert(ctx context.Context, dataset Dataset, rows interface{}) error {
+	precision := dataset.Precision
+	if precision == "" {
+		precision = "s"
+	}
+
+	bp, err := influx.NewBatchPoints(influx.BatchPointsConfig{
+		Database:  s.database,
+		Precision: precision,
+	})
+	if err != nil {
+		return err
+	}
+
+	points, err := influxPoints(dataset, rows)
+	if err != nil {
+		return err
+	}
+	bp.AddPoints(points)
+
+	err = s.client.Write(bp)
+	if err != nil {
+		return fmt.Errorf("Error writing to influx: %s", err.Error())
+	}
+
+	return nil
+}
+
+func (s *InfluxSink) Delete(ctx context.Context, dataset Dataset, keys []string) error {
+	for _, key := range keys {
+		command, err := influxDeleteQuery(dataset, key)
+		if err != nil {
+			return fmt.Errorf("Error deleting %s from %s: %s", key, dataset.Table, err.Error())
+		}
+
+		response, err := s.client.Query(influx.NewQuery(command, s.database, ""))
+		if err != nil {
+			return fmt.Errorf("Error deleting %s from %s: %s", key, dataset.Table, err.Error())
+		}
+		if response.Error() != nil {
+			return fmt.Errorf("Error deleting %s from %s: %s", key, dataset.Table, response.Error().Error())
+		}
+	}
+
+	return nil
+}
+
+// influxDeleteQuery is the InfluxQL deleting the point of a key. Keys of datasets keyed by time are RFC 3339 timestamps
+func influxDeleteQuery(dataset Dataset, key string) (string, error) {
+	if dataset.KeyColumn == dataset.TimeColumn {
+		t, err := time.Parse(time.RFC3339Nano, key)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(`DELETE FROM %s WHERE time = '%s'`, influxQLIdent(dataset.Table), t.UTC().Format(time.RFC3339Nano)), nil
+	}
+
+	return fmt.Sprintf(`DELETE FROM %s WHERE %s = %s`, influxQLIdent(dataset.Table), influxQLIdent(dataset.KeyColumn), influxQLString(key)), nil
+}
+
+var influxQLIdentReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+var influxQLStringReplacer = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+func influxQLIdent(s string) string {
+	return `"` + influxQLIdentReplacer.Replace(s) + `"`
+}
+
+func influxQLString(s string) string {
+	return `'` + influxQLStringReplacer.Replace(s) + `'`
+}
+
+func (s *InfluxSink) Close() error {
+	return s.client.Close()
+}
+
+// influxPoints converts rows to points. Numbers and bools are fields, strings and string arrays are tags and the time
+// column is the timestamp. Other columns like json maps are left out, Point rows are written as they are
+func influxPoints(dataset Dataset, rows interface{}) ([]*influx.Point, error) {
+	if points, ok := rows.(*[]Point); ok {
+		influxPoints := make([]*influx.Point, 0, len(*points))
+		for _, p := range *points {
+			tags := map[string]string{}
+			for k, v := range p.Tags {
+				tags[k] = v
+			}
+			if p.Key != "" {
+				tags[dataset.KeyColumn] = p.Key
+			}
+
+			pt, err := influx.NewPoint(dataset.Table, tags, p.Fields, p.Time)
+			if err != nil {
+				return nil, fmt.Errorf("Error adding new point: %s", err.Error())
+			}
+			influxPoints = append(influxPoints, pt)
+		}
+		return influxPoints, nil
+	}
+
+	slice, err := rowsValue(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	table := tables.Get(slice.Type().Elem())
+	influxPoints := make([]*influx.Point, 0, slice.Len())
+
+	for i := 0; i < slice.Len(); i++ {
+		tags := map[string]string{}
+		fields := map[string]interface{}{}
+		var timestamp time.Time
+
+		for column, value := range columns(table, slice.Index(i)) {
+			if column == dataset.TimeColumn {
+				timestamp, _ = value.(time.Time)
+				continue
+			}
+
+			switch v := value.(type) {
+			case int, int32, int64, float32, float64, bool:
+				if column != "id" {
+					fields[column] = v
+				}
+			case string:
+				if v != "" {
+					tags[column] = v
+				}
+			case []string:
+				if len(v) > 0 {
+					tags[column] = strings.Join(v, ",")
+				}
+			}
+		}
+
+		pt, err := influx.NewPoint(dataset.Table, tags, fields, timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("Error adding new point: %s", err.Error())
+		}
+		influxPoints = append(influxPoints, pt)
+	}
+
+	return influxPoints, nil
+}
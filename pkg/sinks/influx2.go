@@ -0,0 +1,280 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+)
+
+// InfluxDB 2.x needs a start and stop for every delete, deletes that aren't limited to a time use the whole range
+var (
+	deleteStart = time.Unix(0, 0).UTC()
+	deleteStop  = time.Date(2262, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Influx2Sink writes each dataset to a measurement in an InfluxDB 2.x bucket through the HTTP API using the line
+// protocol. Like InfluxSink every row has its key as a tag so rows are upserted by key
+type Influx2Sink struct {
+	client        *http.Client
+	url           string
+	token         string
+	org           string
+	bucket        string
+	retentionDays int
+}
+
+func NewInflux2Sink(endpoint, token, org, bucket string, retentionDays int) (*Influx2Sink, error) {
+	if endpoint == "" || org == "" || bucket == "" {
+		return nil, fmt.Errorf("influx2 sink needs a url, org and bucket")
+	}
+
+	return &Influx2Sink{
+		client:        &http.Client{Timeout: time.Minute},
+		url:           strings.TrimSuffix(endpoint, "/"),
+		token:         token,
+		org:           org,
+		bucket:        bucket,
+		retentionDays: retentionDays,
+	}, nil
+}
+
+type influx2Bucket struct {
+	ID             string                 `json:"id,omitempty"`
+	OrgID          string                 `json:"orgID,omitempty"`
+	Name           string                 `json:"name"`
+	RetentionRules []influx2RetentionRule `json:"retentionRules"`
+}
+
+type influx2RetentionRule struct {
+	Type         string `json:"type"`
+	EverySeconds int64  `json:"everySeconds"`
+}
+
+func (s *Influx2Sink) Migrate(ctx context.Context, dataset Dataset) error {
+	err := s.ensureBucket(ctx)
+	if err != nil {
+		return err
+	}
+
+	if dataset.Replace {
+		err = s.deleteWhere(ctx, "_measurement="+influx2Quote(dataset.Table), deleteStart, deleteStop)
+		if err != nil {
+			return fmt.Errorf("Error dropping measurement %s: %s", dataset.Table, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// ensureBucket creates the bucket if it doesn't exist and updates its retention if it changed
+func (s *Influx2Sink) ensureBucket(ctx context.Context) error {
+	retentionRules := []influx2RetentionRule{}
+	if s.retentionDays > 0 {
+		retentionRules = append(retentionRules, influx2RetentionRule{Type: "expire", EverySeconds: int64(s.retentionDays) * 24 * 60 * 60})
+	}
+
+	buckets := struct {
+		Buckets []influx2Bucket `json:"buckets"`
+	}{}
+	err := s.do(ctx, http.MethodGet, "/api/v2/buckets", url.Values{"org": {s.org}, "name": {s.bucket}}, nil, &buckets)
+	if err != nil {
+		return fmt.Errorf("failed to get bucket %s: %w", s.bucket, err)
+	}
+
+	if len(buckets.Buckets) > 0 {
+		bucket := buckets.Buckets[0]
+		if retentionSeconds(bucket.RetentionRules) == retentionSeconds(retentionRules) {
+			return nil
+		}
+
+		err = s.do(ctx, http.MethodPatch, "/api/v2/buckets/"+bucket.ID, nil, influx2Bucket{Name: bucket.Name, RetentionRules: retentionRules}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to update retention of bucket %s: %w", s.bucket, err)
+		}
+		return nil
+	}
+
+	orgs := struct {
+		Orgs []struct {
+			ID string `json:"id"`
+		} `json:"orgs"`
+	}{}
+	err = s.do(ctx, http.MethodGet, "/api/v2/orgs", url.Values{"org": {s.org}}, nil, &orgs)
+	if err != nil {
+		return fmt.Errorf("failed to get org %s: %w", s.org, err)
+	}
+	if len(orgs.Orgs) == 0 {
+		return fmt.Errorf("org %s not found", s.org)
+	}
+
+	err = s.do(ctx, http.MethodPost, "/api/v2/buckets", nil, influx2Bucket{OrgID: orgs.Orgs[0].ID, Name: s.bucket, RetentionRules: retentionRules}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create bucket %s: %w", s.bucket, err)
+	}
+
+	return nil
+}
+
+func retentionSeconds(rules []influx2RetentionRule) int64 {
+	for _, r := range rules {
+		if r.Type == "expire" {
+			return r.EverySeconds
+		}
+	}
+	return 0
+}
+
+func (s *Influx2Sink) Upsert(ctx context.Context, dataset Dataset, rows interface{}) error {
+	points, err := influxPoints(dataset, rows)
+	if err != nil {
+		return err
+	}
+	if len(points) == 0 {
+		return nil
+	}
+
+	body, precision, err := lineProtocol(points, dataset.Precision)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"org": {s.org}, "bucket": {s.bucket}, "precision": {precision}}
+	err = s.do(ctx, http.MethodPost, "/api/v2/write", query, body, nil)
+	if err != nil {
+		return fmt.Errorf("Error writing to influx: %s", err.Error())
+	}
+
+	return nil
+}
+
+// lineProtocol encodes points for the write API. InfluxDB 2.x only takes ns, us, ms and s precisions, coarser precisions
+// are rounded and written in seconds
+func lineProtocol(points []*influx.Point, precision string) ([]byte, string, error) {
+	var round time.Duration
+	switch precision {
+	case "":
+		precision = "s"
+	case "h":
+		round, precision = time.Hour, "s"
+	case "m":
+		round, precision = time.Minute, "s"
+	}
+
+	var body bytes.Buffer
+	for _, pt := range points {
+		if round != 0 {
+			fields, err := pt.Fields()
+			if err != nil {
+				return nil, "", err
+			}
+			pt, err = influx.NewPoint(pt.Name(), pt.Tags(), fields, pt.Time().Truncate(round))
+			if err != nil {
+				return nil, "", err
+			}
+		}
+
+		body.WriteString(pt.PrecisionString(precision))
+		body.WriteByte('\n')
+	}
+
+	return body.Bytes(), precision, nil
+}
+
+// Delete removes the rows with the keys. The delete API only supports AND in predicates so every key is its own delete
+func (s *Influx2Sink) Delete(ctx context.Context, dataset Dataset, keys []string) error {
+	for _, key := range keys {
+		predicate := fmt.Sprintf(`_measurement=%s AND %s=%s`, influx2Quote(dataset.Table), influx2Quote(dataset.KeyColumn), influx2Quote(key))
+		start, stop := deleteStart, deleteStop
+
+		if dataset.KeyColumn == dataset.TimeColumn {
+			t, err := time.Parse(time.RFC3339, key)
+			if err != nil {
+				return fmt.Errorf("Error deleting %s from %s: %s", key, dataset.Table, err.Error())
+			}
+			predicate = "_measurement=" + influx2Quote(dataset.Table)
+			start, stop = t, t
+		}
+
+		err := s.deleteWhere(ctx, predicate, start, stop)
+		if err != nil {
+			return fmt.Errorf("Error deleting %s from %s: %s", key, dataset.Table, err.Error())
+		}
+	}
+
+	return nil
+}
+
+var influx2PredicateReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// influx2Quote quotes a tag key or value for a delete predicate, quoted keys can have spaces and reserved words
+func influx2Quote(s string) string {
+	return `"` + influx2PredicateReplacer.Replace(s) + `"`
+}
+
+func (s *Influx2Sink) deleteWhere(ctx context.Context, predicate string, start, stop time.Time) error {
+	body := map[string]string{
+		"start":     start.Format(time.RFC3339Nano),
+		"stop":      stop.Format(time.RFC3339Nano),
+		"predicate": predicate,
+	}
+	return s.do(ctx, http.MethodPost, "/api/v2/delete", url.Values{"org": {s.org}, "bucket": {s.bucket}}, body, nil)
+}
+
+// do calls the API, body is sent as is if it is bytes and as json otherwise. The json response is decoded into out if set
+func (s *Influx2Sink) do(ctx context.Context, method, path string, query url.Values, body interface{}, out interface{}) error {
+	var reader io.Reader
+	contentType := "application/json"
+
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+		contentType = "text/plain; charset=utf-8"
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	endpoint := s.url + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+s.token)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		raw, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(raw)))
+	}
+
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func (s *Influx2Sink) Close() error {
+	return nil
+}
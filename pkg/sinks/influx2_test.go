@@ -0,0 +1,73 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInflux2Sink(t *testing.T) {
+	var created map[string]interface{}
+	var deletes []map[string]string
+	var written, precision string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Token secret", r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v2/buckets":
+			w.Write([]byte(`{"buckets": []}`))
+		case "GET /api/v2/orgs":
+			assert.Equal(t, "home", r.URL.Query().Get("org"))
+			w.Write([]byte(`{"orgs": [{"id": "org1"}]}`))
+		case "POST /api/v2/buckets":
+			require.NoError(t, json.Unmarshal(body, &created))
+			w.WriteHeader(http.StatusCreated)
+		case "POST /api/v2/delete":
+			d := map[string]string{}
+			require.NoError(t, json.Unmarshal(body, &d))
+			deletes = append(deletes, d)
+			w.WriteHeader(http.StatusNoContent)
+		case "POST /api/v2/write":
+			assert.Equal(t, "airtable", r.URL.Query().Get("bucket"))
+			precision = r.URL.Query().Get("precision")
+			written = string(body)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	sink, err := NewInflux2Sink(server.URL, "secret", "home", "airtable", 30)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	dataset := Dataset{Table: "sleep", Model: (*Point)(nil), KeyColumn: "key", TimeColumn: "time", Replace: true, Precision: "h"}
+	require.NoError(t, sink.Migrate(ctx, dataset))
+
+	assert.Equal(t, "org1", created["orgID"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"type": "expire", "everySeconds": float64(30 * 24 * 60 * 60)}}, created["retentionRules"])
+	require.Len(t, deletes, 1)
+	assert.Equal(t, `_measurement="sleep"`, deletes[0]["predicate"])
+
+	rows := []Point{{Key: "rec1", Time: time.Date(2024, 3, 1, 7, 30, 0, 0, time.UTC), Fields: map[string]interface{}{"hours": 7.5}}}
+	require.NoError(t, sink.Upsert(ctx, dataset, &rows))
+	assert.Equal(t, "s", precision)
+	assert.Equal(t, "sleep,key=rec1 hours=7.5 1709276400\n", written)
+
+	require.NoError(t, sink.Delete(ctx, dataset, []string{"rec1"}))
+	assert.Equal(t, `_measurement="sleep" AND "key"="rec1"`, deletes[1]["predicate"])
+
+	dataset.KeyColumn = "record id"
+	require.NoError(t, sink.Delete(ctx, dataset, []string{`say "hi" \o/`}))
+	assert.Equal(t, `_measurement="sleep" AND "record id"="say \"hi\" \\o/"`, deletes[2]["predicate"])
+}
@@ -0,0 +1,77 @@
+package sinks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+)
+
+type testRow struct {
+	bun.BaseModel `bun:"table:test_rows"`
+	ID            int64  `bun:",pk,autoincrement"`
+	Key           string `bun:",pk,unique"`
+	Date          time.Time
+	Name          string
+	Tags          []string `bun:",array"`
+	Amount        float64
+	Cleared       bool
+	Fields        map[string]interface{} `bun:"type:jsonb"`
+}
+
+func TestInfluxPointsFromModel(t *testing.T) {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	dataset := Dataset{Table: "rows", Model: (*testRow)(nil), KeyColumn: "key", TimeColumn: "date"}
+	rows := []testRow{{ID: 4, Key: "a", Date: date, Name: "coffee", Tags: []string{"food", "cafe"}, Amount: 4.5, Cleared: true}}
+
+	points, err := influxPoints(dataset, &rows)
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+
+	assert.Equal(t, "rows", points[0].Name())
+	assert.Equal(t, date, points[0].Time())
+	assert.Equal(t, map[string]string{"key": "a", "name": "coffee", "tags": "food,cafe"}, points[0].Tags())
+
+	fields, err := points[0].Fields()
+	require.NoError(t, err)
+	// the id and json columns are left out
+	assert.Equal(t, map[string]interface{}{"amount": 4.5, "cleared": true}, fields)
+}
+
+func TestInfluxPointsFromPoints(t *testing.T) {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	dataset := Dataset{Table: "sleep", Model: (*Point)(nil), KeyColumn: "key", TimeColumn: "time"}
+	rows := []Point{{Key: "rec1", Time: date, Tags: map[string]string{"mood": "good"}, Fields: map[string]interface{}{"hours": 7.5}}}
+
+	points, err := influxPoints(dataset, &rows)
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+
+	assert.Equal(t, map[string]string{"key": "rec1", "mood": "good"}, points[0].Tags())
+	fields, err := points[0].Fields()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"hours": 7.5}, fields)
+}
+
+func TestInfluxPointsRequiresSlicePointer(t *testing.T) {
+	_, err := influxPoints(Dataset{Table: "rows"}, []testRow{})
+	assert.Error(t, err)
+}
+
+func TestInfluxDeleteQuery(t *testing.T) {
+	dataset := Dataset{Table: `sleep "log"`, KeyColumn: "key", TimeColumn: "time"}
+
+	command, err := influxDeleteQuery(dataset, `rec's\1`)
+	require.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "sleep \"log\"" WHERE "key" = 'rec\'s\\1'`, command)
+
+	dataset = Dataset{Table: "networth", KeyColumn: "date", TimeColumn: "date"}
+	command, err = influxDeleteQuery(dataset, "2024-03-01T00:00:00-05:00")
+	require.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "networth" WHERE time = '2024-03-01T05:00:00Z'`, command)
+
+	_, err = influxDeleteQuery(dataset, "2024-03-01' OR time > 0 --")
+	assert.Error(t, err)
+}
@@ -0,0 +1,113 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/uptrace/bun"
+)
+
+// PostgresSink writes each dataset to a table
+type PostgresSink struct {
+	db        *bun.DB
+	shadow    *postgresutils.ShadowTables
+	batchSize int
+	closeDB   bool
+}
+
+// NewPostgresSink writes to db. When shadow is set tables are written to their shadow table and only replace the real
+// tables once the shadow tables are swapped in
+func NewPostgresSink(db *bun.DB, shadow *postgresutils.ShadowTables, batchSize int) *PostgresSink {
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+
+	return &PostgresSink{
+		db:        db,
+		shadow:    shadow,
+		batchSize: batchSize,
+	}
+}
+
+func (s *PostgresSink) table(tableName string) string {
+	if s.shadow == nil {
+		return tableName
+	}
+	return s.shadow.Name(tableName)
+}
+
+func (s *PostgresSink) Migrate(ctx context.Context, dataset Dataset) error {
+	tableName := s.table(dataset.Table)
+
+	// shadow tables are always recreated, datasets that are upserted start from a copy of the current table
+	if dataset.Replace || s.shadow != nil {
+		_, err := s.db.NewDropTable().Model(dataset.Model).ModelTableExpr(tableName).IfExists().Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to drop %s table: %w", tableName, err)
+		}
+	}
+
+	_, err := s.db.NewCreateTable().Model(dataset.Model).ModelTableExpr(tableName).IfNotExists().Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create %s table: %w", tableName, err)
+	}
+
+	if dataset.Replace {
+		if err := postgresutils.SetUnlogged(s.db, tableName); err != nil {
+			return fmt.Errorf("failed to set %s unlogged: %w", tableName, err)
+		}
+	} else if s.shadow != nil {
+		return s.shadow.CopyExisting(dataset.Table)
+	}
+
+	return nil
+}
+
+func (s *PostgresSink) Upsert(ctx context.Context, dataset Dataset, rows interface{}) error {
+	tableName := s.table(dataset.Table)
+
+	slice, err := rowsValue(rows)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < slice.Len(); i += s.batchSize {
+		batch := reflect.New(slice.Type())
+		batch.Elem().Set(slice.Slice(i, min(slice.Len(), i+s.batchSize)))
+
+		_, err := s.db.NewInsert().
+			Model(batch.Interface()).
+			ModelTableExpr(tableName).
+			On(fmt.Sprintf("CONFLICT (%s) DO UPDATE", dataset.KeyColumn)).
+			Set(postgresutils.TableSetString(s.db, dataset.Model, "id", dataset.KeyColumn)).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("error writing to %s, batch start index %d: %w", tableName, i, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *PostgresSink) Delete(ctx context.Context, dataset Dataset, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	tableName := s.table(dataset.Table)
+	_, err := s.db.NewDelete().TableExpr("?", bun.Ident(tableName)).Where("? IN (?)", bun.Ident(dataset.KeyColumn), bun.In(keys)).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("error deleting from %s: %w", tableName, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresSink) Close() error {
+	if s.closeDB {
+		return s.db.Close()
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/schema"
+)
+
+const (
+	TypePostgres = "postgres"
+	TypeInflux   = "influx"
+	TypeInflux2  = "influx2"
+	TypeSQLite   = "sqlite"
+
+	defaultBatchSize = 1000
+)
+
+// Sink is a destination for imported rows. Rows are bun models so every sink reads the same column names from the
+// struct tags
+type Sink interface {
+	// Migrate prepares the sink to receive rows of the dataset, existing rows are dropped if the dataset is rebuilt every run
+	Migrate(ctx context.Context, dataset Dataset) error
+	// Upsert writes rows, a pointer to a slice of the dataset model, replacing existing rows with the same key
+	Upsert(ctx context.Context, dataset Dataset, rows interface{}) error
+	// Delete removes the rows with the keys
+	Delete(ctx context.Context, dataset Dataset, keys []string) error
+	Close() error
+}
+
+// Dataset describes a logical set of rows written by an importer
+type Dataset struct {
+	// Name identifies the dataset in the sinks config, ie transactions
+	Name string
+	// Table is the table or measurement the rows are written to
+	Table string
+	// Model is a nil pointer to the bun model of the rows
+	Model interface{}
+	// KeyColumn identifies a row, rows with the same key replace each other
+	KeyColumn string
+	// TimeColumn is the timestamp of a row for time series sinks
+	TimeColumn string
+	// Replace drops the existing rows on migrate, for datasets that are rebuilt every run
+	Replace bool
+	// Precision of the timestamps for time series sinks, defaults to s
+	Precision string
+}
+
+// Point is a row without a fixed schema, used by importers whose columns come from the source like Airtable
+type Point struct {
+	bun.BaseModel `bun:"table:points"`
+	ID            int64  `bun:",pk,autoincrement"`
+	Key           string `bun:",pk,unique"`
+	Time          time.Time
+	Tags          map[string]string      `bun:"type:jsonb"`
+	Fields        map[string]interface{} `bun:"type:jsonb"`
+}
+
+// Options are the defaults from the task opening the sinks
+type Options struct {
+	// DB is an existing postgres connection to Database or sqlite connection to SQLitePath, used by the sinks of the same
+	// type writing there. Other sinks open their own
+	DB *bun.DB
+	// ShadowTables stages postgres writes until the run succeeds, optional
+	ShadowTables *postgresutils.ShadowTables
+	// Database is the default database for sinks that don't set one
+	Database string
+	// SQLitePath is the default file for sqlite sinks that don't set one
+	SQLitePath string
+	BatchSize  int
+	// DefaultOnly ignores the configured sinks and writes to the default type
+	DefaultOnly bool
+}
+
+// Open creates the sinks configured for dataset, defaultType is used if none are configured. Several sinks are combined
+// into one that writes to all of them
+func Open(dataset string, defaultType string, opts Options) (Sink, error) {
+	configs := config.CurrentConfig().Sinks[dataset]
+	if len(configs) == 0 || opts.DefaultOnly {
+		configs = []config.SinkConfig{{Type: defaultType}}
+	}
+
+	opened := Multi{}
+	for _, c := range configs {
+		sink, err := open(c, opts)
+		if err != nil {
+			opened.Close()
+			return nil, fmt.Errorf("failed to open %s sink for %s: %w", c.Type, dataset, err)
+		}
+		opened = append(opened, sink)
+	}
+
+	if len(opened) == 1 {
+		return opened[0], nil
+	}
+	return opened, nil
+}
+
+func open(c config.SinkConfig, opts Options) (Sink, error) {
+	database := c.Database
+	if database == "" {
+		database = opts.Database
+	}
+
+	switch c.Type {
+	case TypePostgres, "":
+		// a database url connects every database name to the same database
+		sameDatabase := database == opts.Database || config.CurrentSecrets().DatabaseURL != ""
+		if opts.DB != nil && opts.DB.Dialect().Name() == dialect.PG && sameDatabase {
+			return NewPostgresSink(opts.DB, opts.ShadowTables, opts.BatchSize), nil
+		}
+
+		db, err := postgresutils.CreatePostgresClient(database)
+		if err != nil {
+			return nil, err
+		}
+		// the shadow tables are swapped in on the connection of the run, other databases are written directly
+		shadow := opts.ShadowTables
+		if !sameDatabase {
+			shadow = nil
+		}
+		sink := NewPostgresSink(db, shadow, opts.BatchSize)
+		sink.closeDB = true
+		return sink, nil
+	case TypeInflux:
+		return NewInfluxSink(database)
+	case TypeInflux2:
+		endpoint := c.URL
+		if endpoint == "" {
+			endpoint = config.CurrentInfluxSecrets().InfluxEndpoint
+		}
+		bucket := c.Bucket
+		if bucket == "" {
+			bucket = database
+		}
+		return NewInflux2Sink(endpoint, config.CurrentInfluxSecrets().InfluxToken, c.Org, bucket, c.RetentionDays)
+	case TypeSQLite:
+		path := c.Path
+		if path == "" {
+			path = opts.SQLitePath
+		}
+
+		if path == opts.SQLitePath && opts.DB != nil && opts.DB.Dialect().Name() == dialect.SQLite {
+			return NewSQLiteSink(opts.DB, opts.BatchSize), nil
+		}
+		if path == "" {
+			return nil, fmt.Errorf("sqlite sink needs a path")
+		}
+
+		db, err := OpenSQLite(path)
+		if err != nil {
+			return nil, err
+		}
+		sink := NewSQLiteSink(db, opts.BatchSize)
+		sink.closeDB = true
+		return sink, nil
+	default:
+		return nil, fmt.Errorf("unknown sink type %s", c.Type)
+	}
+}
+
+// WritesTo is whether sink, or one of the sinks it combines, is a postgres sink writing to db
+func WritesTo(sink Sink, db *bun.DB) bool {
+	switch sink := sink.(type) {
+	case *PostgresSink:
+		return db != nil && sink.db == db
+	case Multi:
+		for _, s := range sink {
+			if WritesTo(s, db) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Multi writes to every sink, stopping at the first error
+type Multi []Sink
+
+func (m Multi) Migrate(ctx context.Context, dataset Dataset) error {
+	for _, s := range m {
+		if err := s.Migrate(ctx, dataset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m Multi) Upsert(ctx context.Context, dataset Dataset, rows interface{}) error {
+	for _, s := range m {
+		if err := s.Upsert(ctx, dataset, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m Multi) Delete(ctx context.Context, dataset Dataset, keys []string) error {
+	for _, s := range m {
+		if err := s.Delete(ctx, dataset, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m Multi) Close() error {
+	var errs []error
+	for _, s := range m {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// tables reads the columns of the models, every sink uses the postgres column names
+var tables = pgdialect.New().Tables()
+
+// rowsValue returns the slice rows points to
+func rowsValue(rows interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return reflect.Value{}, fmt.Errorf("rows must be a pointer to a slice, got %T", rows)
+	}
+	return v.Elem(), nil
+}
+
+// columns returns the value of every column of a row keyed by column name
+func columns(table *schema.Table, row reflect.Value) map[string]interface{} {
+	values := make(map[string]interface{}, len(table.Fields))
+	for _, f := range table.Fields {
+		values[f.Name] = f.Value(row).Interface()
+	}
+	return values
+}
@@ -0,0 +1,26 @@
+package sinks
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritesTo(t *testing.T) {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "selfops.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	other, err := OpenSQLite(filepath.Join(t.TempDir(), "other.db"))
+	require.NoError(t, err)
+	defer other.Close()
+
+	postgres := NewPostgresSink(db, nil, 0)
+	assert.True(t, WritesTo(postgres, db))
+	assert.False(t, WritesTo(postgres, other))
+	assert.False(t, WritesTo(postgres, nil))
+	assert.False(t, WritesTo(NewSQLiteSink(db, 0), db))
+	assert.True(t, WritesTo(Multi{NewSQLiteSink(db, 0), postgres}, db))
+	assert.False(t, WritesTo(Multi{NewSQLiteSink(db, 0), NewPostgresSink(other, nil, 0)}, db))
+}
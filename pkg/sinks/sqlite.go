@@ -0,0 +1,159 @@
+package sinks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/driver/sqliteshim"
+	"github.com/uptrace/bun/schema"
+)
+
+// SQLiteSink writes each dataset to a table in a SQLite file, for running without a postgres server. Array and jsonb
+// columns are stored as JSON text. There are no shadow tables, rows are written in place
+type SQLiteSink struct {
+	db        *bun.DB
+	batchSize int
+	closeDB   bool
+}
+
+// OpenSQLite opens the SQLite database at path, the file is created if it doesn't exist
+func OpenSQLite(path string) (*bun.DB, error) {
+	sqldb, err := sql.Open(sqliteshim.ShimName, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %s: %w", path, err)
+	}
+	// writes are serialized by sqlite, a single connection avoids busy errors between the budget workers
+	sqldb.SetMaxOpenConns(1)
+
+	db := bun.NewDB(sqldb, sqlitedialect.New())
+	if _, err := db.Exec("PRAGMA journal_mode = WAL"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open sqlite database %s: %w", path, err)
+	}
+
+	return db, nil
+}
+
+func NewSQLiteSink(db *bun.DB, batchSize int) *SQLiteSink {
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+
+	return &SQLiteSink{
+		db:        db,
+		batchSize: batchSize,
+	}
+}
+
+func (s *SQLiteSink) Migrate(ctx context.Context, dataset Dataset) error {
+	if dataset.Replace {
+		_, err := s.db.ExecContext(ctx, "DROP TABLE IF EXISTS ?", bun.Ident(dataset.Table))
+		if err != nil {
+			return fmt.Errorf("failed to drop %s table: %w", dataset.Table, err)
+		}
+	}
+
+	table := s.db.Dialect().Tables().Get(reflect.TypeOf(dataset.Model).Elem())
+	_, err := s.db.ExecContext(ctx, sqliteCreateTable(table, dataset.Table, dataset.KeyColumn))
+	if err != nil {
+		return fmt.Errorf("failed to create %s table: %w", dataset.Table, err)
+	}
+
+	return nil
+}
+
+// sqliteCreateTable builds the create table statement for a model. Bun's create table makes id part of a composite
+// primary key which sqlite can't autoincrement, so id is the only primary key here and the key column is unique
+func sqliteCreateTable(table *schema.Table, tableName, keyColumn string) string {
+	columns := make([]string, 0, len(table.Fields))
+	for _, f := range table.Fields {
+		column := string(f.SQLName) + " " + sqliteType(f)
+		switch {
+		case f.Name == "id":
+			column = string(f.SQLName) + " INTEGER PRIMARY KEY AUTOINCREMENT"
+		case f.Name == keyColumn:
+			column += " NOT NULL UNIQUE"
+		}
+		columns = append(columns, column)
+	}
+
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (%s)`, tableName, strings.Join(columns, ", "))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func sqliteType(f *schema.Field) string {
+	switch strings.ToLower(f.UserSQLType) {
+	case "json", "jsonb":
+		return "JSON"
+	}
+
+	switch f.IndirectType.Kind() {
+	case reflect.Slice, reflect.Map:
+		if f.IndirectType.Elem().Kind() == reflect.Uint8 {
+			return "BLOB"
+		}
+		return "JSON"
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INTEGER"
+	case reflect.Float32, reflect.Float64:
+		return "REAL"
+	}
+
+	if f.IndirectType == timeType {
+		return "TIMESTAMP"
+	}
+	return "TEXT"
+}
+
+func (s *SQLiteSink) Upsert(ctx context.Context, dataset Dataset, rows interface{}) error {
+	slice, err := rowsValue(rows)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < slice.Len(); i += s.batchSize {
+		batch := reflect.New(slice.Type())
+		batch.Elem().Set(slice.Slice(i, min(slice.Len(), i+s.batchSize)))
+
+		_, err := s.db.NewInsert().
+			Model(batch.Interface()).
+			ModelTableExpr("?", bun.Ident(dataset.Table)).
+			On(fmt.Sprintf("CONFLICT (%s) DO UPDATE", dataset.KeyColumn)).
+			Set(postgresutils.TableSetString(s.db, dataset.Model, "id", dataset.KeyColumn)).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("error writing to %s, batch start index %d: %w", dataset.Table, i, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteSink) Delete(ctx context.Context, dataset Dataset, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := s.db.NewDelete().TableExpr("?", bun.Ident(dataset.Table)).Where("? IN (?)", bun.Ident(dataset.KeyColumn), bun.In(keys)).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("error deleting from %s: %w", dataset.Table, err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteSink) Close() error {
+	if s.closeDB {
+		return s.db.Close()
+	}
+	return nil
+}
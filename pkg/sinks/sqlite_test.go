@@ -0,0 +1,49 @@
+package sinks
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteSinkUpsertsByKey(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "selfops.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	sink := NewSQLiteSink(db, 1)
+	dataset := Dataset{Table: "rows", Model: (*testRow)(nil), KeyColumn: "key", TimeColumn: "date"}
+	require.NoError(t, sink.Migrate(ctx, dataset))
+
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	rows := []testRow{
+		{Key: "a", Date: date, Name: "coffee", Tags: []string{"food"}, Amount: 4.5, Fields: map[string]interface{}{"trip": "paris"}},
+		{Key: "b", Date: date, Name: "rent", Amount: 1200},
+	}
+	require.NoError(t, sink.Upsert(ctx, dataset, &rows))
+
+	rows = []testRow{{Key: "a", Date: date, Name: "coffee", Tags: []string{"food", "cafe"}, Amount: 5}}
+	require.NoError(t, sink.Upsert(ctx, dataset, &rows))
+	require.NoError(t, sink.Delete(ctx, dataset, []string{"b"}))
+
+	var tags string
+	var amount float64
+	err = db.QueryRow(`SELECT json_extract(tags, '$[1]'), amount FROM rows WHERE key = 'a'`).Scan(&tags, &amount)
+	require.NoError(t, err)
+	assert.Equal(t, "cafe", tags)
+	assert.Equal(t, 5.0, amount)
+
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM rows`).Scan(&count))
+	assert.Equal(t, 1, count)
+
+	// migrating again keeps upserted datasets
+	require.NoError(t, sink.Migrate(ctx, dataset))
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM rows`).Scan(&count))
+	assert.Equal(t, 1, count)
+}
@@ -6,11 +6,9 @@ import (
 	"log/slog"
 	"math"
 	"slices"
-	"strings"
 	"time"
 
 	"github.com/bcaldwell/selfops/pkg/config"
-	"github.com/bcaldwell/selfops/pkg/postgresutils"
 	"github.com/davidsteinsland/ynab-go/ynab"
 	"github.com/uptrace/bun"
 	"k8s.io/klog"
@@ -39,6 +37,8 @@ type SQLAccount struct {
 	ID            int64  `bun:",pk,autoincrement"`
 	Key           string `bun:",pk,unique"`
 	Date          time.Time
+	BudgetID      string
+	AccountID     string
 	Name          string
 	Currency      string
 	BudgetName    string
@@ -50,6 +50,8 @@ type SQLAccount struct {
 }
 
 type accountAggregator struct {
+	budgetID    string
+	accountID   string
 	balance     float64
 	name        string
 	accountType string
@@ -71,12 +73,15 @@ func (a *accountAggregator) appendTransaction(transaction ynab.TransactionSummar
 		return fmt.Errorf("failed to parse transaction date: %w", err)
 	}
 
-	amount := float64(transaction.Amount) / balanceMultiplier
-	i := a.ensureSqlForDate(t)
-	addToBalance(&a.sql[i], amount, a.conversion)
+	a.appendAmount(t, float64(transaction.Amount)/balanceMultiplier)
 	return nil
 }
 
+func (a *accountAggregator) appendAmount(date time.Time, amount float64) {
+	i := a.ensureSqlForDate(date)
+	addToBalance(&a.sql[i], amount, a.conversion)
+}
+
 // ensureSqlForDate ensures that there is a sql account for a date. It will add one and any missing ones if needed. Returns the index of the created sql (last in array)
 func (a *accountAggregator) ensureSqlForDate(date time.Time) int {
 	a.sql = ensureOrderedSqlRecordsForDate(date, func(t time.Time, last *SQLAccount) *SQLAccount {
@@ -110,7 +115,9 @@ func ensureOrderedSqlRecordsForDate[T ItemWithDate](date time.Time, newT func(ti
 
 func (a *accountAggregator) newSql(date time.Time, balance float64) *SQLAccount {
 	s := &SQLAccount{
-		Key:        fmt.Sprintf("%s::%s::%s", date.Format("01-02-2006"), a.budgetName, a.name),
+		Key:        accountKey(date, a.budgetID, a.accountID),
+		BudgetID:   a.budgetID,
+		AccountID:  a.accountID,
 		Balance:    0,
 		USD:        0,
 		CAD:        0,
@@ -125,6 +132,11 @@ func (a *accountAggregator) newSql(date time.Time, balance float64) *SQLAccount
 	return s
 }
 
+// accountKey identifies an account on a day by ids so renaming the budget or account keeps its history
+func accountKey(date time.Time, budgetID, accountID string) string {
+	return fmt.Sprintf("%s::%s::%s", date.Format("01-02-2006"), budgetID, accountID)
+}
+
 func addToBalance(s *SQLAccount, balance float64, conversion map[string]float64) {
 	s.Balance += balance
 	s.USD = Round(s.Balance*conversion["USD"], 0.01)
@@ -132,32 +144,18 @@ func addToBalance(s *SQLAccount, balance float64, conversion map[string]float64)
 }
 
 func (importer *ImportYNABRunner) migrateAccounts() error {
-	tableName := config.CurrentYnabConfig().SQL.AccountsTable
-	model := (*SQLAccount)(nil)
-	// todo make this come from config
 	// easiest way to handle deleted transactions, with the speed at which it works not too bad
-	_, err := importer.db.NewDropTable().Model(model).ModelTableExpr(tableName).Exec(context.Background())
-	if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("ERROR: table \"%s\" does not exist (SQLSTATE=42P01)", tableName)) {
-		return fmt.Errorf("failed to drop %s table: %w", tableName, err)
-	}
-
-	_, err = importer.db.NewCreateTable().Model((*SQLAccount)(nil)).ModelTableExpr(tableName).IfNotExists().Exec(context.Background())
-	if err != nil {
-		return err
-	}
-	return postgresutils.SetUnlogged(importer.db, tableName)
+	return importer.sinks[accountsDataset].Migrate(context.Background(), accountsSinkDataset())
 }
 
 func (importer *ImportYNABRunner) importAccounts(budget config.Budget, currencies []string) ([]SQLAccount, error) {
-	model := (*SQLAccount)(nil)
-	tableName := config.CurrentYnabConfig().SQL.AccountsTable
-
 	currencyNetworths := make(map[string]float64)
 	for _, currency := range currencies {
 		currencyNetworths[currency] = 0
 	}
 
-	accounts := importer.budgets[budget.ID].Accounts
+	budgetDetail := importer.budget(budget.ID)
+	accounts := budgetDetail.Accounts
 
 	// map of accountid to account info
 	accountsMap := map[string]*accountAggregator{}
@@ -166,6 +164,8 @@ func (importer *ImportYNABRunner) importAccounts(budget config.Budget, currencie
 		balance := float64(account.Balance) / balanceMultiplier
 
 		accountsMap[account.Id] = &accountAggregator{
+			budgetID:    budget.ID,
+			accountID:   account.Id,
 			name:        account.Name,
 			accountType: account.Type,
 			onBudget:    account.OnBudget,
@@ -178,12 +178,14 @@ func (importer *ImportYNABRunner) importAccounts(budget config.Budget, currencie
 		}
 	}
 
-	slices.SortFunc(importer.budgets[budget.ID].Transactions, func(a, b ynab.TransactionSummary) int {
+	// the budget detail is shared with the other importers, sort a copy
+	transactions := slices.Clone(budgetDetail.Transactions)
+	slices.SortFunc(transactions, func(a, b ynab.TransactionSummary) int {
 		aDate, _ := time.Parse("2006-01-02", a.Date)
 		bDate, _ := time.Parse("2006-01-02", b.Date)
 		return aDate.Compare(bDate)
 	})
-	for _, transaction := range importer.budgets[budget.ID].Transactions {
+	for _, transaction := range transactions {
 		accountsMap[transaction.AccountId].appendTransaction(transaction)
 	}
 
@@ -202,19 +204,14 @@ func (importer *ImportYNABRunner) importAccounts(budget config.Budget, currencie
 
 	sqlAccounts := []SQLAccount{}
 	for _, account := range accountsMap {
-		_, err := importer.db.NewInsert().
-			Model(&account.sql).
-			ModelTableExpr(tableName).
-			On("CONFLICT (key) DO UPDATE").
-			Set(postgresutils.TableSetString(importer.db, model, "id", "key")).
-			Exec(context.Background())
-
+		records := storedRecords(account.sql, sameAccountBalance)
+		err := importer.sinks[accountsDataset].Upsert(context.Background(), accountsSinkDataset(), &records)
 		if err != nil {
 			return nil, fmt.Errorf("Error writing accounts to sql: %s", err.Error())
 		}
 
 		sqlAccounts = append(sqlAccounts, account.sql...)
-		klog.Infof("Wrote %d accounts to sql from budget %s account %s\n", len(account.sql), budget.Name, account.name)
+		klog.Infof("Wrote %d accounts to sql from budget %s account %s\n", len(records), budget.Name, account.name)
 	}
 
 	return sqlAccounts, nil
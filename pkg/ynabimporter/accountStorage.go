@@ -0,0 +1,137 @@
+package ynabimporter
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/uptrace/bun"
+)
+
+const (
+	dailyStorage   = "daily"
+	changesStorage = "changes"
+
+	weeklyRollup  = "weekly"
+	monthlyRollup = "monthly"
+
+	defaultRollupAfterDays = 365
+)
+
+func validateAccountsStorage(conf *config.YnabConfig) error {
+	switch conf.SQL.AccountsStorage {
+	case "", dailyStorage, changesStorage:
+	default:
+		return fmt.Errorf("unknown accounts storage %s, expected %s or %s", conf.SQL.AccountsStorage, dailyStorage, changesStorage)
+	}
+
+	switch conf.SQL.Rollup {
+	case "", weeklyRollup, monthlyRollup:
+	default:
+		return fmt.Errorf("unknown rollup %s, expected %s or %s", conf.SQL.Rollup, weeklyRollup, monthlyRollup)
+	}
+
+	return nil
+}
+
+// storedRecords converts ordered daily records into the records that should be written based on the storage config
+func storedRecords[T ItemWithDate](items []T, same func(a, b T) bool) []T {
+	conf := config.CurrentYnabConfig().SQL
+
+	if conf.Rollup != "" {
+		rollupAfterDays := conf.RollupAfterDays
+		if rollupAfterDays == 0 {
+			rollupAfterDays = defaultRollupAfterDays
+		}
+
+		cutoff := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -rollupAfterDays)
+		items = rollupRecords(items, conf.Rollup, cutoff)
+	}
+
+	if conf.AccountsStorage == changesStorage {
+		items = changePoints(items, same)
+	}
+
+	return items
+}
+
+// changePoints only keeps the records where the value changes from the previous one. The last record is always kept so the
+// daily view knows how far the series goes
+func changePoints[T ItemWithDate](items []T, same func(a, b T) bool) []T {
+	if len(items) == 0 {
+		return items
+	}
+
+	points := []T{items[0]}
+	for i := 1; i < len(items); i++ {
+		if i == len(items)-1 || !same(points[len(points)-1], items[i]) {
+			points = append(points, items[i])
+		}
+	}
+
+	return points
+}
+
+// rollupRecords keeps only the last record of each week or month for records before the cutoff
+func rollupRecords[T ItemWithDate](items []T, period string, cutoff time.Time) []T {
+	rolledUp := []T{}
+
+	for i, item := range items {
+		if !item.ItemDate().Before(cutoff) || i == len(items)-1 {
+			rolledUp = append(rolledUp, item)
+			continue
+		}
+
+		next := items[i+1].ItemDate()
+		if !next.Before(cutoff) || rollupPeriod(item.ItemDate(), period) != rollupPeriod(next, period) {
+			rolledUp = append(rolledUp, item)
+		}
+	}
+
+	return rolledUp
+}
+
+func rollupPeriod(date time.Time, period string) time.Time {
+	if period == monthlyRollup {
+		return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	}
+
+	// weeks start on monday
+	offset := (int(date.Weekday()) + 6) % 7
+	return time.Date(date.Year(), date.Month(), date.Day()-offset, 0, 0, 0, 0, date.Location())
+}
+
+func sameAccountBalance(a, b SQLAccount) bool {
+	return a.Balance == b.Balance && a.USD == b.USD && a.CAD == b.CAD
+}
+
+func sameNetWorth(a, b SQLNetWorth) bool {
+	return a.USD == b.USD && a.CAD == b.CAD && reflect.DeepEqual(a.BudgetBreakdown, b.BudgetBreakdown)
+}
+
+func dailyViewName(tableName string) string {
+	return tableName + "_daily"
+}
+
+// createDailyView creates a view that expands stored records into one row per day. Each record is repeated until the day before
+// the next record in its partition, the last record in a partition is only returned for its own date
+func createDailyView(ctx context.Context, db bun.IDB, tableName string, partition []string, columns []string) error {
+	partitionBy := ""
+	if len(partition) > 0 {
+		partitionBy = "PARTITION BY " + strings.Join(partition, ", ")
+	}
+
+	query := fmt.Sprintf(`CREATE OR REPLACE VIEW ? AS
+SELECT day AS date, %s
+FROM (
+	SELECT *, lead(date) OVER (%s ORDER BY date) AS next_date FROM ?
+) t
+CROSS JOIN LATERAL generate_series(t.date, COALESCE(t.next_date - interval '1 day', t.date), interval '1 day') AS day`,
+		strings.Join(columns, ", "), partitionBy)
+
+	_, err := db.ExecContext(ctx, query, bun.Ident(dailyViewName(tableName)), bun.Ident(tableName))
+	return err
+}
@@ -0,0 +1,58 @@
+package ynabimporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func dailyAccounts(start time.Time, balances ...float64) []SQLAccount {
+	accounts := make([]SQLAccount, len(balances))
+	for i, balance := range balances {
+		accounts[i] = SQLAccount{Date: start.AddDate(0, 0, i), Balance: balance}
+	}
+	return accounts
+}
+
+func TestChangePoints(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	accounts := dailyAccounts(start, 100, 100, 100, 250, 250, 100, 100)
+
+	points := changePoints(accounts, sameAccountBalance)
+	assert.Len(t, points, 4)
+	assert.Equal(t, start, points[0].Date)
+	assert.Equal(t, start.AddDate(0, 0, 3), points[1].Date)
+	assert.Equal(t, start.AddDate(0, 0, 5), points[2].Date)
+	// last day is kept even without a change
+	assert.Equal(t, start.AddDate(0, 0, 6), points[3].Date)
+	assert.Equal(t, 100.0, points[3].Balance)
+
+	assert.Len(t, changePoints([]SQLAccount{}, sameAccountBalance), 0)
+}
+
+func TestRollupRecords(t *testing.T) {
+	// 2024-01-01 is a monday
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	balances := make([]float64, 60)
+	for i := range balances {
+		balances[i] = float64(i)
+	}
+	accounts := dailyAccounts(start, balances...)
+
+	cutoff := time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC)
+
+	weekly := rollupRecords(accounts, weeklyRollup, cutoff)
+	// sundays before the cutoff, the day before the cutoff then every day after
+	assert.Equal(t, time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC), weekly[0].Date)
+	assert.Equal(t, time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC), weekly[1].Date)
+	assert.Equal(t, time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC), weekly[6].Date)
+	assert.Equal(t, cutoff, weekly[7].Date)
+	assert.Len(t, weekly, 7+15)
+
+	monthly := rollupRecords(accounts, monthlyRollup, cutoff)
+	assert.Equal(t, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), monthly[0].Date)
+	assert.Equal(t, 30.0, monthly[0].Balance)
+	assert.Equal(t, time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC), monthly[1].Date)
+	assert.Len(t, monthly, 2+15)
+}
@@ -7,55 +7,54 @@ import (
 	"time"
 
 	"github.com/bcaldwell/selfops/pkg/config"
-	"github.com/bcaldwell/selfops/pkg/postgresutils"
 	"github.com/uptrace/bun"
 	"k8s.io/klog"
 )
 
 type SQLBudget struct {
-	bun.BaseModel `bun:"table:budgets"`
-	ID            int64  `bun:",pk,autoincrement"`
-	Key           string `bun:",pk,unique"`
-	Category      string
-	CategoryGroup string
-	Month         time.Time
-	Name          string
-	Currency      string
-	Budgeted      float64
-	Activity      float64
-	ActivityUSD   float64
-	ActivityCAD   float64
-	Balance       float64
-	BalanceUSD    float64
-	BalanceCAD    float64
-	Amount        float64
-	USD           float64
-	CAD           float64
-	Fields        map[string]interface{} `bun:"type:jsonb"`
+	bun.BaseModel   `bun:"table:budgets"`
+	ID              int64  `bun:",pk,autoincrement"`
+	Key             string `bun:",pk,unique"`
+	BudgetID        string
+	CategoryID      string
+	CategoryGroupID string
+	Category        string
+	CategoryGroup   string
+	Month           time.Time
+	Name            string
+	Currency        string
+	Budgeted        float64
+	Activity        float64
+	ActivityUSD     float64
+	ActivityCAD     float64
+	Balance         float64
+	BalanceUSD      float64
+	BalanceCAD      float64
+	Amount          float64
+	USD             float64
+	CAD             float64
+	Fields          map[string]interface{} `bun:"type:jsonb"`
 }
 
 func (importer *ImportYNABRunner) migrateBudgets() error {
-	tableName := config.CurrentYnabConfig().SQL.BudgetsTable
-	_, err := importer.db.NewCreateTable().Model((*SQLBudget)(nil)).ModelTableExpr(tableName).IfNotExists().Exec(context.Background())
-	return err
+	// budgets are upserted so past months are kept once they leave the budget
+	return importer.sinks[budgetsDataset].Migrate(context.Background(), budgetsSinkDataset())
 }
 
-func (importer *ImportYNABRunner) importBudgets(budget config.Budget, currencies []string) error {
-	// todo make this come from config
-	model := (*SQLBudget)(nil)
-	tableName := config.CurrentYnabConfig().SQL.BudgetsTable
-
+func (importer *ImportYNABRunner) importBudgets(budget config.Budget, currencies []string) ([]SQLBudget, error) {
 	sqlRecords := make([]SQLBudget, 0)
 
 	// importer.budgets[budget.ID].Months[0].Categories[0].
-	months := importer.budgets[budget.ID].Months
+	months := importer.budget(budget.ID).Months
+	categories := importer.budgetCategories(budget.ID)
 	// categories := importer.budgets[budget.ID].Categories
 
 	for monthIndex := range months {
 		for categoryIndex := range months[monthIndex].Categories {
 			// for categoryIndex := range categories {
 			category := months[monthIndex].Categories[categoryIndex]
-			categoryGroup := importer.categories[budget.Name][category.Id].Group
+			categoryGroup := categories[category.Id].Group
+			categoryGroupID := categories[category.Id].GroupID
 
 			if category.Hidden {
 				continue
@@ -67,27 +66,30 @@ func (importer *ImportYNABRunner) importBudgets(budget config.Budget, currencies
 
 			month, err := time.Parse("2006-01-02", months[monthIndex].Month)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			row := SQLBudget{
-				Key:           months[monthIndex].Month + "-" + category.Id,
-				Category:      category.Name,
-				CategoryGroup: categoryGroup,
-				Budgeted:      budgeted,
-				Amount:        budgeted,
-				USD:           Round(budgeted*budget.Conversions["USD"], 0.01),
-				CAD:           Round(budgeted*budget.Conversions["CAD"], 0.01),
-				Activity:      activity,
-				ActivityUSD:   Round(activity*budget.Conversions["USD"], 0.01),
-				ActivityCAD:   Round(activity*budget.Conversions["CAD"], 0.01),
-				Balance:       balance,
-				BalanceUSD:    Round(balance*budget.Conversions["USD"], 0.01),
-				BalanceCAD:    Round(balance*budget.Conversions["CAD"], 0.01),
-				Name:          budget.Name,
-				Currency:      budget.Currency,
-				Month:         month,
-				Fields:        make(map[string]interface{}),
+				Key:             months[monthIndex].Month + "-" + category.Id,
+				BudgetID:        budget.ID,
+				CategoryID:      category.Id,
+				CategoryGroupID: categoryGroupID,
+				Category:        category.Name,
+				CategoryGroup:   categoryGroup,
+				Budgeted:        budgeted,
+				Amount:          budgeted,
+				USD:             Round(budgeted*budget.Conversions["USD"], 0.01),
+				CAD:             Round(budgeted*budget.Conversions["CAD"], 0.01),
+				Activity:        activity,
+				ActivityUSD:     Round(activity*budget.Conversions["USD"], 0.01),
+				ActivityCAD:     Round(activity*budget.Conversions["CAD"], 0.01),
+				Balance:         balance,
+				BalanceUSD:      Round(balance*budget.Conversions["USD"], 0.01),
+				BalanceCAD:      Round(balance*budget.Conversions["CAD"], 0.01),
+				Name:            budget.Name,
+				Currency:        budget.Currency,
+				Month:           month,
+				Fields:          make(map[string]interface{}),
 			}
 
 			for _, field := range budget.CalculatedFields {
@@ -103,30 +105,14 @@ func (importer *ImportYNABRunner) importBudgets(budget config.Budget, currencies
 		}
 	}
 
-	batchSize := config.CurrentYnabConfig().SQL.BatchSize
-	if batchSize == 0 {
-		batchSize = 1000
-	}
-
-	for i := 0; i < len(sqlRecords); i += batchSize {
-		endIndex := min(len(sqlRecords), i+batchSize)
-
-		records := sqlRecords[i:endIndex]
-		_, err := importer.db.NewInsert().
-			Model(&records).
-			ModelTableExpr(tableName).
-			On("CONFLICT (key) DO UPDATE").
-			Set(postgresutils.TableSetString(importer.db, model, "id", "key")).
-			Exec(context.Background())
-
-		if err != nil {
-			return fmt.Errorf("error writing budgets: %s", err.Error())
-		}
+	err := importer.sinks[budgetsDataset].Upsert(context.Background(), budgetsSinkDataset(), &sqlRecords)
+	if err != nil {
+		return nil, fmt.Errorf("error writing budgets: %s", err.Error())
 	}
 
 	klog.Infof("Wrote %v budgets for %s to sql\n", len(sqlRecords), budget.Name)
 
-	return nil
+	return sqlRecords, nil
 }
 
 func min(a, b int) int {
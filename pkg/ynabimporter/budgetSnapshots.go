@@ -0,0 +1,120 @@
+package ynabimporter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/uptrace/bun"
+)
+
+// SQLBudgetSnapshot is a category's budget for a month as it was on SnapshotDate. The budgets table only keeps the
+// latest values, snapshots show how allocations moved during the month
+type SQLBudgetSnapshot struct {
+	bun.BaseModel `bun:"table:budget_snapshots"`
+	ID            int64  `bun:",pk,autoincrement"`
+	Key           string `bun:",pk,unique"`
+	SnapshotDate  time.Time
+	Month         time.Time
+	BudgetID      string
+	CategoryID    string
+	Name          string
+	Category      string
+	CategoryGroup string
+	Currency      string
+	Budgeted      float64
+	Activity      float64
+	Balance       float64
+	USD           float64
+	CAD           float64
+}
+
+func budgetSnapshotsTable() string {
+	return tableOrDefault(config.CurrentYnabConfig().SQL.BudgetSnapshotsTable, "budget_snapshots")
+}
+
+func (importer *ImportYNABRunner) migrateBudgetSnapshots() error {
+	if !config.CurrentYnabConfig().SQL.BudgetSnapshots {
+		return nil
+	}
+
+	liveTableName := budgetSnapshotsTable()
+	tableName := importer.table(liveTableName)
+	model := (*SQLBudgetSnapshot)(nil)
+
+	// snapshots are kept forever so start from a copy of the current table
+	_, err := importer.db.NewDropTable().Model(model).ModelTableExpr(tableName).IfExists().Exec(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to drop %s table: %w", tableName, err)
+	}
+
+	_, err = importer.db.NewCreateTable().Model(model).ModelTableExpr(tableName).Exec(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to create %s table: %w", tableName, err)
+	}
+
+	return importer.tables.CopyExisting(liveTableName)
+}
+
+// budgetSnapshots returns a snapshot of the budget rows for the current and future months on snapshotDate, past months
+// are closed and would only repeat the same rows every day. Running more than once a day replaces that day's snapshot
+func budgetSnapshots(budgets []SQLBudget, snapshotDate time.Time) []SQLBudgetSnapshot {
+	day := time.Date(snapshotDate.Year(), snapshotDate.Month(), snapshotDate.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	snapshots := make([]SQLBudgetSnapshot, 0, len(budgets))
+	for _, budget := range budgets {
+		if budget.Month.Before(month) {
+			continue
+		}
+
+		snapshots = append(snapshots, SQLBudgetSnapshot{
+			Key:           day.Format("2006-01-02") + "-" + budget.Key,
+			SnapshotDate:  day,
+			Month:         budget.Month,
+			BudgetID:      budget.BudgetID,
+			CategoryID:    budget.CategoryID,
+			Name:          budget.Name,
+			Category:      budget.Category,
+			CategoryGroup: budget.CategoryGroup,
+			Currency:      budget.Currency,
+			Budgeted:      budget.Budgeted,
+			Activity:      budget.Activity,
+			Balance:       budget.Balance,
+			USD:           budget.USD,
+			CAD:           budget.CAD,
+		})
+	}
+
+	return snapshots
+}
+
+func (importer *ImportYNABRunner) importBudgetSnapshots(budgets []SQLBudget) error {
+	if !config.CurrentYnabConfig().SQL.BudgetSnapshots {
+		return nil
+	}
+
+	model := (*SQLBudgetSnapshot)(nil)
+	tableName := importer.table(budgetSnapshotsTable())
+	sqlRecords := budgetSnapshots(budgets, time.Now())
+
+	for i := 0; i < len(sqlRecords); i += batchSize() {
+		endIndex := min(len(sqlRecords), i+batchSize())
+
+		records := sqlRecords[i:endIndex]
+		_, err := importer.db.NewInsert().
+			Model(&records).
+			ModelTableExpr(tableName).
+			On("CONFLICT (key) DO UPDATE").
+			Set(postgresutils.TableSetString(importer.db, model, "id", "key")).
+			Exec(context.Background())
+
+		if err != nil {
+			return fmt.Errorf("error writing budget snapshots: %s", err.Error())
+		}
+	}
+
+	return nil
+}
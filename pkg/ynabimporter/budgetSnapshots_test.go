@@ -0,0 +1,27 @@
+package ynabimporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudgetSnapshots(t *testing.T) {
+	budgets := []SQLBudget{
+		{Key: "2024-02-01-groceries", Month: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), Budgeted: 400},
+		{Key: "2024-03-01-groceries", Month: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Budgeted: 450, Activity: -120, Balance: 330},
+		{Key: "2024-04-01-groceries", Month: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), Budgeted: 50},
+	}
+
+	snapshots := budgetSnapshots(budgets, time.Date(2024, 3, 15, 18, 30, 0, 0, time.UTC))
+
+	// february is closed
+	assert.Len(t, snapshots, 2)
+	assert.Equal(t, "2024-03-15-2024-03-01-groceries", snapshots[0].Key)
+	assert.Equal(t, time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), snapshots[0].SnapshotDate)
+	assert.Equal(t, 450.0, snapshots[0].Budgeted)
+	assert.Equal(t, -120.0, snapshots[0].Activity)
+	assert.Equal(t, 330.0, snapshots[0].Balance)
+	assert.Equal(t, "2024-03-15-2024-04-01-groceries", snapshots[1].Key)
+}
@@ -0,0 +1,256 @@
+package ynabimporter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/uptrace/bun"
+	"k8s.io/klog"
+)
+
+// SQLCategory is a YNAB category keyed by its id, names are attributes so renames don't break joins
+type SQLCategory struct {
+	bun.BaseModel   `bun:"table:categories"`
+	ID              int64  `bun:",pk,autoincrement"`
+	Key             string `bun:",pk,unique"`
+	BudgetID        string
+	BudgetName      string
+	Name            string
+	CategoryGroupID string
+	CategoryGroup   string
+	Hidden          bool
+	Deleted         bool
+	Note            string `bun:"type:text"`
+	Currency        string
+	// goal fields are empty for categories without a goal
+	GoalType               string
+	GoalCreationMonth      time.Time `bun:",nullzero"`
+	GoalTarget             float64
+	GoalTargetMonth        time.Time `bun:",nullzero"`
+	GoalPercentageComplete int
+	GoalUnderFunded        float64
+	GoalOverallFunded      float64
+	GoalOverallLeft        float64
+}
+
+// SQLCategoryGroup is a YNAB category group keyed by its id
+type SQLCategoryGroup struct {
+	bun.BaseModel `bun:"table:category_groups"`
+	ID            int64  `bun:",pk,autoincrement"`
+	Key           string `bun:",pk,unique"`
+	BudgetID      string
+	BudgetName    string
+	Name          string
+	Hidden        bool
+	Deleted       bool
+}
+
+// SQLPayee is a YNAB payee keyed by its id, transfer payees reference the account they transfer to
+type SQLPayee struct {
+	bun.BaseModel     `bun:"table:payees"`
+	ID                int64  `bun:",pk,autoincrement"`
+	Key               string `bun:",pk,unique"`
+	BudgetID          string
+	BudgetName        string
+	Name              string
+	TransferAccountID string
+	TransferAccount   string
+	Deleted           bool
+}
+
+// SQLPayeeLocation is a location a payee was used at, keyed by the location id
+type SQLPayeeLocation struct {
+	bun.BaseModel `bun:"table:payee_locations"`
+	ID            int64  `bun:",pk,autoincrement"`
+	Key           string `bun:",pk,unique"`
+	BudgetID      string
+	BudgetName    string
+	PayeeID       string
+	Payee         string
+	Latitude      float64
+	Longitude     float64
+	Deleted       bool
+}
+
+func categoriesTable() string {
+	return tableOrDefault(config.CurrentYnabConfig().SQL.CategoriesTable, "categories")
+}
+
+func categoryGroupsTable() string {
+	return tableOrDefault(config.CurrentYnabConfig().SQL.CategoryGroupsTable, "category_groups")
+}
+
+func payeesTable() string {
+	return tableOrDefault(config.CurrentYnabConfig().SQL.PayeesTable, "payees")
+}
+
+func payeeLocationsTable() string {
+	return tableOrDefault(config.CurrentYnabConfig().SQL.PayeeLocationsTable, "payee_locations")
+}
+
+func (importer *ImportYNABRunner) migrateDimensions() error {
+	tables := map[string]interface{}{
+		importer.table(categoriesTable()):     (*SQLCategory)(nil),
+		importer.table(categoryGroupsTable()): (*SQLCategoryGroup)(nil),
+		importer.table(payeesTable()):         (*SQLPayee)(nil),
+		importer.table(payeeLocationsTable()): (*SQLPayeeLocation)(nil),
+	}
+
+	// every run has the full list so the tables are rebuilt
+	for tableName, model := range tables {
+		_, err := importer.db.NewDropTable().Model(model).ModelTableExpr(tableName).Exec(context.Background())
+		if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("ERROR: table \"%s\" does not exist (SQLSTATE=42P01)", tableName)) {
+			return fmt.Errorf("failed to drop %s table: %w", tableName, err)
+		}
+
+		_, err = importer.db.NewCreateTable().Model(model).ModelTableExpr(tableName).IfNotExists().Exec(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to create %s table: %w", tableName, err)
+		}
+
+		if err := postgresutils.SetUnlogged(importer.db, tableName); err != nil {
+			return fmt.Errorf("failed to set %s unlogged: %w", tableName, err)
+		}
+	}
+
+	return nil
+}
+
+func (importer *ImportYNABRunner) importDimensions(budget config.Budget) error {
+	budgetDetail := importer.budget(budget.ID)
+	extras := importer.budgetExtras(budget.ID)
+
+	groups := make([]SQLCategoryGroup, 0, len(budgetDetail.CategoryGroups))
+	groupNames := map[string]string{}
+	for _, g := range budgetDetail.CategoryGroups {
+		groupNames[g.Id] = g.Name
+		groups = append(groups, SQLCategoryGroup{
+			Key:        g.Id,
+			BudgetID:   budget.ID,
+			BudgetName: budget.Name,
+			Name:       g.Name,
+			Hidden:     g.Hidden,
+			Deleted:    extras.CategoryGroups[g.Id].Deleted,
+		})
+	}
+
+	categories := make([]SQLCategory, 0, len(budgetDetail.Categories))
+	for _, c := range budgetDetail.Categories {
+		goal := extras.Categories[c.Id]
+		categories = append(categories, SQLCategory{
+			Key:                    c.Id,
+			BudgetID:               budget.ID,
+			BudgetName:             budget.Name,
+			Name:                   c.Name,
+			CategoryGroupID:        c.CategoryGroupId,
+			CategoryGroup:          groupNames[c.CategoryGroupId],
+			Hidden:                 c.Hidden,
+			Deleted:                goal.Deleted,
+			Note:                   stringValue(c.Note),
+			Currency:               budget.Currency,
+			GoalType:               stringValue(goal.GoalType),
+			GoalCreationMonth:      parseMonth(goal.GoalCreationMonth),
+			GoalTarget:             milliunits(goal.GoalTarget),
+			GoalTargetMonth:        parseMonth(goal.GoalTargetMonth),
+			GoalPercentageComplete: intValue(goal.GoalPercentageComplete),
+			GoalUnderFunded:        milliunits(goal.GoalUnderFunded),
+			GoalOverallFunded:      milliunits(goal.GoalOverallFunded),
+			GoalOverallLeft:        milliunits(goal.GoalOverallLeft),
+		})
+	}
+
+	accountNames := map[string]string{}
+	for _, account := range budgetDetail.Accounts {
+		accountNames[account.Id] = account.Name
+	}
+
+	payees := make([]SQLPayee, 0, len(budgetDetail.Payees))
+	payeeNames := map[string]string{}
+	for _, p := range budgetDetail.Payees {
+		payeeNames[p.Id] = p.Name
+		payees = append(payees, SQLPayee{
+			Key:               p.Id,
+			BudgetID:          budget.ID,
+			BudgetName:        budget.Name,
+			Name:              p.Name,
+			TransferAccountID: stringValue(p.TransferAccountId),
+			TransferAccount:   accountNames[stringValue(p.TransferAccountId)],
+			Deleted:           extras.Payees[p.Id].Deleted,
+		})
+	}
+
+	locations := make([]SQLPayeeLocation, 0, len(budgetDetail.PayeeLocations))
+	for _, l := range budgetDetail.PayeeLocations {
+		latitude, _ := strconv.ParseFloat(stringValue(l.Latitude), 64)
+		longitude, _ := strconv.ParseFloat(stringValue(l.Longitude), 64)
+		locations = append(locations, SQLPayeeLocation{
+			Key:        l.Id,
+			BudgetID:   budget.ID,
+			BudgetName: budget.Name,
+			PayeeID:    l.PayeeId,
+			Payee:      payeeNames[l.PayeeId],
+			Latitude:   latitude,
+			Longitude:  longitude,
+			Deleted:    extras.PayeeLocations[l.Id].Deleted,
+		})
+	}
+
+	tables := []struct {
+		name    string
+		records interface{}
+		count   int
+	}{
+		{categoryGroupsTable(), &groups, len(groups)},
+		{categoriesTable(), &categories, len(categories)},
+		{payeesTable(), &payees, len(payees)},
+		{payeeLocationsTable(), &locations, len(locations)},
+	}
+
+	for _, t := range tables {
+		if t.count == 0 {
+			continue
+		}
+
+		_, err := importer.db.NewInsert().Model(t.records).ModelTableExpr(importer.table(t.name)).Exec(context.Background())
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", t.name, err)
+		}
+	}
+
+	klog.Infof("Wrote %d categories, %d category groups, %d payees and %d payee locations to sql from budget %s\n", len(categories), len(groups), len(payees), len(locations), budget.Name)
+
+	return nil
+}
+
+// milliunits converts an optional YNAB amount to a float, missing amounts are 0
+func milliunits(amount *int64) float64 {
+	if amount == nil {
+		return 0
+	}
+	return float64(*amount) / balanceMultiplier
+}
+
+func intValue(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+// parseMonth parses an optional YNAB date, missing or invalid dates are the zero time
+func parseMonth(s *string) time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+
+	t, err := time.Parse("2006-01-02", *s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
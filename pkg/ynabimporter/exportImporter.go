@@ -0,0 +1,271 @@
+package ynabimporter
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/budgetexport"
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"k8s.io/klog"
+)
+
+func exportName(file config.ExportFile) string {
+	if file.Name != "" {
+		return file.Name
+	}
+	return strings.TrimSuffix(filepath.Base(file.Path), filepath.Ext(file.Path))
+}
+
+// exportBudgetID is the budget id of the rows of an export
+func exportBudgetID(file config.ExportFile) string {
+	return file.Type + "::" + exportName(file)
+}
+
+func exportCurrency(file config.ExportFile) string {
+	if file.Currency == "" {
+		return "USD"
+	}
+	return file.Currency
+}
+
+func readExport(file config.ExportFile) (*budgetexport.Export, error) {
+	switch file.Type {
+	case budgetexport.TypeActual:
+		return budgetexport.ReadActual(file.Path, exportBudgetID(file))
+	case budgetexport.TypeFirefly:
+		return budgetexport.ReadFirefly(file.Path, file.BudgetsPath, exportBudgetID(file))
+	case budgetexport.TypeSplitwise:
+		group := file.Group
+		if group == "" {
+			group = exportName(file)
+		}
+		return budgetexport.ReadSplitwise(file.Path, group, file.Me, exportBudgetID(file))
+	}
+	return nil, fmt.Errorf("unknown export type %s", file.Type)
+}
+
+// importExports writes the transactions, accounts and budgets of every Actual Budget, Firefly III and Splitwise export.
+// Returns the accounts for net worth
+func (importer *ImportYNABRunner) importExports(currencies []string) ([]SQLAccount, error) {
+	sqlAccounts := []SQLAccount{}
+	for _, file := range config.CurrentExportFiles() {
+		accounts, err := importer.importExport(file, currencies)
+		if err != nil {
+			return nil, fmt.Errorf("%s export %s: %w", file.Type, exportName(file), err)
+		}
+		sqlAccounts = append(sqlAccounts, accounts...)
+	}
+
+	return sqlAccounts, nil
+}
+
+func (importer *ImportYNABRunner) importExport(file config.ExportFile, currencies []string) ([]SQLAccount, error) {
+	export, err := readExport(file)
+	if err != nil {
+		return nil, err
+	}
+
+	name := exportName(file)
+
+	importAfterDate := time.Time{}
+	if file.ImportAfterDate != "" {
+		importAfterDate, err = time.Parse("01-02-2006", file.ImportAfterDate)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse import after date %s: %v", file.ImportAfterDate, err)
+		}
+	}
+
+	// the transaction importer converts from a single currency
+	byCurrency := map[string][]financialimporter.Transaction{}
+	for _, t := range export.Transactions {
+		currency := t.Currency()
+		if currency == "" {
+			currency = exportCurrency(file)
+		}
+		byCurrency[currency] = append(byCurrency[currency], t)
+	}
+
+	for currency, transactions := range byCurrency {
+		i := financialimporter.NewTransactionImporter(importer.sinks[transactionsDataset], importer.currencyConverter, transactions, file.CalculatedFields, currency, currencies, importAfterDate, config.CurrentYnabConfig().SQL.TransactionsTable, importer.history)
+
+		written, err := i.Import()
+		if err != nil {
+			return nil, err
+		}
+
+		klog.Infof("Wrote %d %s transactions to sql from %s export %s\n", written, currency, file.Type, name)
+	}
+
+	err = importer.importExportBudgets(file, export, currencies)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := importer.exportAccounts(file, export, currencies)
+	if err != nil {
+		return nil, err
+	}
+
+	return importer.writeAccounts(name, accounts)
+}
+
+// exportAccounts builds the daily balances of the accounts of an export from their transactions and adjustments, from
+// the first one until today or the last one of closed accounts. Accounts without a type are liabilities when their
+// balance is negative. Accounts with entries in several currencies are split by currency like the transactions, the
+// currency of the first entry keeps the id and name of the account
+func (importer *ImportYNABRunner) exportAccounts(file config.ExportFile, export *budgetexport.Export, currencies []string) ([]*accountAggregator, error) {
+	type entry struct {
+		date     string
+		amount   float64
+		currency string
+	}
+
+	entries := map[string][]entry{}
+	for _, t := range export.Transactions {
+		entries[t.AccountID()] = append(entries[t.AccountID()], entry{t.Date(), t.Amount(), t.Currency()})
+	}
+	for _, a := range export.Adjustments {
+		entries[a.AccountID] = append(entries[a.AccountID], entry{a.Date, a.Amount, a.Currency})
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	aggregators := []*accountAggregator{}
+	for _, account := range export.Accounts {
+		accountEntries := entries[account.ID]
+		if len(accountEntries) == 0 {
+			continue
+		}
+
+		sort.SliceStable(accountEntries, func(i, j int) bool {
+			return accountEntries[i].date < accountEntries[j].date
+		})
+
+		accountCurrencies := []string{}
+		byCurrency := map[string][]entry{}
+		for _, e := range accountEntries {
+			if e.currency == "" {
+				e.currency = exportCurrency(file)
+			}
+			if _, ok := byCurrency[e.currency]; !ok {
+				accountCurrencies = append(accountCurrencies, e.currency)
+			}
+			byCurrency[e.currency] = append(byCurrency[e.currency], e)
+		}
+
+		for i, currency := range accountCurrencies {
+			conversion, err := importer.conversions(currency, currencies)
+			if err != nil {
+				return nil, err
+			}
+
+			aggregator := &accountAggregator{
+				budgetID:    exportBudgetID(file),
+				accountID:   account.ID,
+				name:        account.Name,
+				accountType: account.Type,
+				onBudget:    account.OnBudget,
+				currency:    currency,
+				budgetName:  exportName(file),
+				conversion:  conversion,
+				closed:      account.Closed,
+				sql:         []SQLAccount{},
+			}
+			if i > 0 {
+				aggregator.accountID = account.ID + "::" + currency
+				aggregator.name = account.Name + " (" + currency + ")"
+			}
+
+			for _, e := range byCurrency[currency] {
+				date, err := time.Parse("2006-01-02", e.date)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse transaction date: %w", err)
+				}
+				aggregator.appendAmount(date, e.amount)
+			}
+
+			if !aggregator.closed {
+				aggregator.ensureSqlForDate(today)
+			}
+
+			if aggregator.accountType == "" {
+				aggregator.accountType = "otherAsset"
+				if aggregator.sql[len(aggregator.sql)-1].Balance < 0 {
+					aggregator.accountType = "otherLiability"
+				}
+				for i := range aggregator.sql {
+					aggregator.sql[i].Type = aggregator.accountType
+				}
+			}
+
+			aggregators = append(aggregators, aggregator)
+		}
+	}
+
+	return aggregators, nil
+}
+
+func (importer *ImportYNABRunner) importExportBudgets(file config.ExportFile, export *budgetexport.Export, currencies []string) error {
+	if len(export.Budgets) == 0 {
+		return nil
+	}
+
+	currency := exportCurrency(file)
+	conversion, err := importer.conversions(currency, currencies)
+	if err != nil {
+		return err
+	}
+
+	budgetID := exportBudgetID(file)
+	sqlRecords := make([]SQLBudget, 0, len(export.Budgets))
+	for _, b := range export.Budgets {
+		row := SQLBudget{
+			Key:             b.Month.Format("2006-01-02") + "-" + budgetID + "::" + b.CategoryID,
+			BudgetID:        budgetID,
+			CategoryID:      b.CategoryID,
+			CategoryGroupID: b.CategoryGroupID,
+			Category:        b.Category,
+			CategoryGroup:   b.CategoryGroup,
+			Budgeted:        b.Budgeted,
+			Amount:          b.Budgeted,
+			USD:             Round(b.Budgeted*conversion["USD"], 0.01),
+			CAD:             Round(b.Budgeted*conversion["CAD"], 0.01),
+			Activity:        b.Activity,
+			ActivityUSD:     Round(b.Activity*conversion["USD"], 0.01),
+			ActivityCAD:     Round(b.Activity*conversion["CAD"], 0.01),
+			Balance:         b.Balance,
+			BalanceUSD:      Round(b.Balance*conversion["USD"], 0.01),
+			BalanceCAD:      Round(b.Balance*conversion["CAD"], 0.01),
+			Name:            exportName(file),
+			Currency:        currency,
+			Month:           b.Month,
+			Fields:          make(map[string]interface{}),
+		}
+
+		for _, field := range file.CalculatedFields {
+			calculateField := stringInSlice(b.Category, field.Category) || stringInSlice(b.CategoryGroup, field.CategoryGroup)
+			if field.Inverted {
+				calculateField = !calculateField
+			}
+
+			row.Fields[field.Name] = strconv.FormatBool(calculateField)
+		}
+
+		sqlRecords = append(sqlRecords, row)
+	}
+
+	err = importer.sinks[budgetsDataset].Upsert(context.Background(), budgetsSinkDataset(), &sqlRecords)
+	if err != nil {
+		return fmt.Errorf("error writing budgets: %s", err.Error())
+	}
+
+	klog.Infof("Wrote %v budgets for %s to sql\n", len(sqlRecords), exportName(file))
+
+	return nil
+}
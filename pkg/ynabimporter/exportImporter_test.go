@@ -0,0 +1,41 @@
+package ynabimporter
+
+import (
+	"testing"
+
+	"github.com/bcaldwell/selfops/pkg/budgetexport"
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportAccountsSplitsCurrencies(t *testing.T) {
+	export := &budgetexport.Export{
+		Accounts: []budgetexport.Account{{ID: "friend", Name: "Sam", OnBudget: true}},
+		Adjustments: []budgetexport.Adjustment{
+			{AccountID: "friend", Date: "2024-01-01", Amount: 20},
+			{AccountID: "friend", Date: "2024-01-02", Amount: -50, Currency: "EUR"},
+			{AccountID: "friend", Date: "2024-01-03", Amount: 5, Currency: "CAD"},
+		},
+	}
+
+	importer := &ImportYNABRunner{}
+	accounts, err := importer.exportAccounts(config.ExportFile{Type: budgetexport.TypeSplitwise, Name: "trip", Currency: "CAD"}, export, nil)
+	require.NoError(t, err)
+	require.Len(t, accounts, 2)
+
+	cad := accounts[0]
+	assert.Equal(t, "friend", cad.accountID)
+	assert.Equal(t, "Sam", cad.name)
+	assert.Equal(t, "CAD", cad.currency)
+	assert.Equal(t, "otherAsset", cad.accountType)
+	assert.Equal(t, 25.0, cad.sql[len(cad.sql)-1].Balance)
+
+	eur := accounts[1]
+	assert.Equal(t, "friend::EUR", eur.accountID)
+	assert.Equal(t, "Sam (EUR)", eur.name)
+	assert.Equal(t, "EUR", eur.currency)
+	assert.Equal(t, "otherLiability", eur.accountType)
+	assert.Equal(t, -50.0, eur.sql[len(eur.sql)-1].Balance)
+	assert.NotEqual(t, cad.sql[0].Key, eur.sql[0].Key)
+}
@@ -0,0 +1,176 @@
+package ynabimporter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/davidsteinsland/ynab-go/ynab"
+	"github.com/uptrace/bun"
+	"k8s.io/klog"
+)
+
+// SQLGoalProgress is the state of a category goal in a month, only categories with a goal have rows
+type SQLGoalProgress struct {
+	bun.BaseModel   `bun:"table:goal_progress"`
+	ID              int64  `bun:",pk,autoincrement"`
+	Key             string `bun:",pk,unique"`
+	Month           time.Time
+	BudgetID        string
+	CategoryID      string
+	CategoryGroupID string
+	Name            string
+	Category        string
+	CategoryGroup   string
+	Currency        string
+	// TB (target balance), TBD (target balance by date), MF (monthly funding), NEED (plan your spending) or DEBT
+	GoalType           string
+	GoalTargetMonth    time.Time `bun:",nullzero"`
+	PercentageComplete int
+	Budgeted           float64
+	Balance            float64
+	Target             float64
+	TargetUSD          float64
+	TargetCAD          float64
+	// amount assigned towards the goal so far
+	Funded    float64
+	FundedUSD float64
+	FundedCAD float64
+	// amount still needed this month to stay on track
+	UnderFunded    float64
+	UnderFundedUSD float64
+	UnderFundedCAD float64
+	// amount still needed to complete the goal
+	Left    float64
+	LeftUSD float64
+	LeftCAD float64
+}
+
+func goalProgressTable() string {
+	return tableOrDefault(config.CurrentYnabConfig().SQL.GoalProgressTable, "goal_progress")
+}
+
+func goalsAtRiskViewName(tableName string) string {
+	return tableName + "_at_risk"
+}
+
+func (importer *ImportYNABRunner) migrateGoalProgress() error {
+	liveTableName := goalProgressTable()
+	tableName := importer.table(liveTableName)
+	model := (*SQLGoalProgress)(nil)
+
+	// goal progress for past months is kept even once the goal is removed, start from a copy of the current table
+	_, err := importer.db.NewDropTable().Model(model).ModelTableExpr(tableName).IfExists().Exec(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to drop %s table: %w", tableName, err)
+	}
+
+	_, err = importer.db.NewCreateTable().Model(model).ModelTableExpr(tableName).Exec(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to create %s table: %w", tableName, err)
+	}
+
+	return importer.tables.CopyExisting(liveTableName)
+}
+
+func (importer *ImportYNABRunner) importGoalProgress(budget config.Budget) error {
+	model := (*SQLGoalProgress)(nil)
+	tableName := importer.table(goalProgressTable())
+	sqlRecords := goalProgress(budget, importer.budget(budget.ID).Months, importer.budgetExtras(budget.ID), importer.budgetCategories(budget.ID))
+
+	for i := 0; i < len(sqlRecords); i += batchSize() {
+		endIndex := min(len(sqlRecords), i+batchSize())
+
+		records := sqlRecords[i:endIndex]
+		_, err := importer.db.NewInsert().
+			Model(&records).
+			ModelTableExpr(tableName).
+			On("CONFLICT (key) DO UPDATE").
+			Set(postgresutils.TableSetString(importer.db, model, "id", "key")).
+			Exec(context.Background())
+
+		if err != nil {
+			return fmt.Errorf("error writing goal progress: %s", err.Error())
+		}
+	}
+
+	klog.Infof("Wrote %d goal progress rows for %s to sql\n", len(sqlRecords), budget.Name)
+
+	return nil
+}
+
+func goalProgress(budget config.Budget, months []ynab.MonthDetail, extras budgetExtras, categories map[string]category) []SQLGoalProgress {
+	convert := func(amount float64, currency string) float64 {
+		return Round(amount*budget.Conversions[currency], 0.01)
+	}
+
+	sqlRecords := []SQLGoalProgress{}
+	for _, m := range months {
+		month, err := time.Parse("2006-01-02", m.Month)
+		if err != nil {
+			klog.Warningf("Skipping goals for month %s: %s\n", m.Month, err)
+			continue
+		}
+
+		for _, c := range m.Categories {
+			goal, ok := extras.Months[m.Month][c.Id]
+			if !ok || goal.GoalType == nil || c.Hidden {
+				continue
+			}
+
+			target := milliunits(goal.GoalTarget)
+			funded := milliunits(goal.GoalOverallFunded)
+			underFunded := milliunits(goal.GoalUnderFunded)
+			left := milliunits(goal.GoalOverallLeft)
+
+			sqlRecords = append(sqlRecords, SQLGoalProgress{
+				Key:                m.Month + "-" + c.Id,
+				Month:              month,
+				BudgetID:           budget.ID,
+				CategoryID:         c.Id,
+				CategoryGroupID:    categories[c.Id].GroupID,
+				Name:               budget.Name,
+				Category:           c.Name,
+				CategoryGroup:      categories[c.Id].Group,
+				Currency:           budget.Currency,
+				GoalType:           *goal.GoalType,
+				GoalTargetMonth:    parseMonth(goal.GoalTargetMonth),
+				PercentageComplete: intValue(goal.GoalPercentageComplete),
+				Budgeted:           float64(c.Budgeted) / balanceMultiplier,
+				Balance:            float64(c.Balance) / balanceMultiplier,
+				Target:             target,
+				TargetUSD:          convert(target, "USD"),
+				TargetCAD:          convert(target, "CAD"),
+				Funded:             funded,
+				FundedUSD:          convert(funded, "USD"),
+				FundedCAD:          convert(funded, "CAD"),
+				UnderFunded:        underFunded,
+				UnderFundedUSD:     convert(underFunded, "USD"),
+				UnderFundedCAD:     convert(underFunded, "CAD"),
+				Left:               left,
+				LeftUSD:            convert(left, "USD"),
+				LeftCAD:            convert(left, "CAD"),
+			})
+		}
+	}
+
+	return sqlRecords
+}
+
+// createGoalsAtRiskView creates a view of the goals for the current month that are behind. A goal is at risk when it is
+// underfunded for the month or its target month has arrived without the goal being complete
+func createGoalsAtRiskView(ctx context.Context, db bun.IDB, tableName string) error {
+	_, err := db.ExecContext(ctx, `CREATE OR REPLACE VIEW ? AS
+SELECT *,
+	CASE
+		WHEN goal_target_month IS NOT NULL AND goal_target_month <= month AND percentage_complete < 100 THEN 'past target month'
+		ELSE 'underfunded'
+	END AS reason
+FROM ?
+WHERE month = date_trunc('month', current_date)
+	AND (under_funded > 0 OR (goal_target_month IS NOT NULL AND goal_target_month <= month AND percentage_complete < 100))`,
+		bun.Ident(goalsAtRiskViewName(tableName)), bun.Ident(tableName))
+	return err
+}
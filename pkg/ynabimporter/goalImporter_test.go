@@ -0,0 +1,48 @@
+package ynabimporter
+
+import (
+	"testing"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/davidsteinsland/ynab-go/ynab"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoalProgress(t *testing.T) {
+	goalType := "TBD"
+	targetMonth := "2024-06-01"
+	target := int64(1200000)
+	funded := int64(300000)
+	underFunded := int64(100000)
+	percentage := 25
+
+	budget := config.Budget{ID: "budget", Name: "home", Currency: "CAD", Conversions: config.CurrencyConversion{"USD": 0.75, "CAD": 1}}
+	months := []ynab.MonthDetail{{
+		MonthSummary: ynab.MonthSummary{Month: "2024-03-01"},
+		Categories: []ynab.Category{
+			{Id: "vacation", Name: "Vacation", Budgeted: 200000, Balance: 300000},
+			{Id: "groceries", Name: "Groceries", Budgeted: 500000},
+		},
+	}}
+	extras := budgetExtras{Months: map[string]map[string]categoryExtras{
+		"2024-03-01": {
+			"vacation":  {ID: "vacation", GoalType: &goalType, GoalTargetMonth: &targetMonth, GoalTarget: &target, GoalOverallFunded: &funded, GoalUnderFunded: &underFunded, GoalPercentageComplete: &percentage},
+			"groceries": {ID: "groceries"},
+		},
+	}}
+	categories := map[string]category{"vacation": {Id: "vacation", Name: "Vacation", Group: "Savings", GroupID: "savings"}}
+
+	rows := goalProgress(budget, months, extras, categories)
+
+	// groceries has no goal
+	assert.Len(t, rows, 1)
+	assert.Equal(t, "2024-03-01-vacation", rows[0].Key)
+	assert.Equal(t, "Savings", rows[0].CategoryGroup)
+	assert.Equal(t, "TBD", rows[0].GoalType)
+	assert.Equal(t, 1200.0, rows[0].Target)
+	assert.Equal(t, 900.0, rows[0].TargetUSD)
+	assert.Equal(t, 300.0, rows[0].Funded)
+	assert.Equal(t, 75.0, rows[0].UnderFundedUSD)
+	assert.Equal(t, 25, rows[0].PercentageComplete)
+	assert.Equal(t, 2024, rows[0].GoalTargetMonth.Year())
+}
@@ -0,0 +1,148 @@
+package ynabimporter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"github.com/bcaldwell/selfops/pkg/httpsource"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"k8s.io/klog"
+)
+
+const (
+	httpSourceTimeout = 30 * time.Second
+	// LastSeen endpoints of the http sources start with it
+	sourceEndpointPrefix = "http/"
+)
+
+func sourceEndpoint(source config.HTTPSource) string {
+	return sourceEndpointPrefix + source.Name
+}
+
+// importSources writes the transactions or measurement rows of every http source
+func (importer *ImportYNABRunner) importSources(currencies []string) error {
+	var measurementSink sinks.Sink
+	defer func() {
+		if measurementSink != nil {
+			measurementSink.Close()
+		}
+	}()
+
+	client := &http.Client{Timeout: httpSourceTimeout}
+	for _, source := range config.CurrentHTTPSources() {
+		if source.Type != httpsource.Type {
+			return fmt.Errorf("source %s: unknown source type %s", source.Name, source.Type)
+		}
+
+		s := httpsource.NewSource(source, client, config.CurrentHTTPSecrets().Headers[source.AuthSecret])
+
+		var err error
+		switch {
+		case source.Transactions != nil:
+			err = importer.importSourceTransactions(s, source, currencies)
+		case source.Measurement != nil:
+			if measurementSink == nil {
+				measurementSink, err = importer.openSink(httpsource.Dataset)
+				if err != nil {
+					return err
+				}
+			}
+			err = importer.importSourceMeasurement(measurementSink, s, source)
+		default:
+			err = fmt.Errorf("no transactions or measurement mapping")
+		}
+		if err != nil {
+			return fmt.Errorf("source %s: %w", source.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// importSourceTransactions fetches every transaction each run, the transactions table is rebuilt every run
+func (importer *ImportYNABRunner) importSourceTransactions(s *httpsource.Source, source config.HTTPSource, currencies []string) error {
+	items, _, err := s.Fetch(context.Background(), "")
+	if err != nil {
+		return err
+	}
+
+	transactions, err := httpsource.Transactions(source, items)
+	if err != nil {
+		return err
+	}
+
+	importAfterDate := time.Time{}
+	if source.ImportAfterDate != "" {
+		importAfterDate, err = time.Parse("01-02-2006", source.ImportAfterDate)
+		if err != nil {
+			return fmt.Errorf("Failed to parse import after date %s: %v", source.ImportAfterDate, err)
+		}
+	}
+
+	// the transaction importer converts from a single currency
+	byCurrency := map[string][]financialimporter.Transaction{}
+	for _, t := range transactions {
+		currency := t.Currency()
+		if currency == "" {
+			currency = source.Currency
+		}
+		if currency == "" {
+			currency = "USD"
+		}
+		byCurrency[currency] = append(byCurrency[currency], t)
+	}
+
+	for currency, currencyTransactions := range byCurrency {
+		i := financialimporter.NewTransactionImporter(importer.sinks[transactionsDataset], importer.currencyConverter, currencyTransactions, source.CalculatedFields, currency, currencies, importAfterDate, config.CurrentYnabConfig().SQL.TransactionsTable, importer.history)
+
+		written, err := i.Import()
+		if err != nil {
+			return err
+		}
+
+		klog.Infof("Wrote %d %s transactions to sql from source %s\n", written, currency, source.Name)
+	}
+
+	return nil
+}
+
+// importSourceMeasurement fetches the items since the last successful run, the state is saved once the rows are in place
+func (importer *ImportYNABRunner) importSourceMeasurement(sink sinks.Sink, s *httpsource.Source, source config.HTTPSource) error {
+	state, err := importer.lastSeen(sourceEndpoint(source))
+	if err != nil {
+		return fmt.Errorf("failed to get last state: %w", err)
+	}
+
+	items, state, err := s.Fetch(context.Background(), state)
+	if err != nil {
+		return err
+	}
+
+	points, err := httpsource.Points(source, items)
+	if err != nil {
+		return err
+	}
+
+	dataset := httpsource.MeasurementDataset(*source.Measurement)
+	err = sink.Migrate(context.Background(), dataset)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Upsert(context.Background(), dataset, &points)
+	if err != nil {
+		return err
+	}
+
+	if state != "" {
+		importer.sourceStates[sourceEndpoint(source)] = state
+	}
+
+	klog.Infof("Wrote %d rows to %s from source %s\n", len(points), dataset.Table, source.Name)
+
+	return nil
+}
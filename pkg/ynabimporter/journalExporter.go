@@ -0,0 +1,95 @@
+package ynabimporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"github.com/bcaldwell/selfops/pkg/importruns"
+	"github.com/bcaldwell/selfops/pkg/journal"
+)
+
+// WriteJournal writes the transactions of every budget dated in [from, to) as a ledger, hledger or beancount journal.
+// Zero times are unbounded. Prices of the configured currencies in each budget currency are written as of today
+func (importer *ImportYNABRunner) WriteJournal(run *importruns.Run, w io.Writer, format string, from, to time.Time) error {
+	importer.run = run
+
+	g, err := journal.NewGenerator(w, format)
+	if err != nil {
+		return err
+	}
+
+	err = importer.detectBudgetIDs(config.CurrentYnabConfig())
+	if err != nil {
+		return fmt.Errorf("Error detecting budget IDs: %s", err)
+	}
+
+	_, err = importer.db.NewCreateTable().Model(&LastSeen{}).IfNotExists().Exec(context.Background())
+	if err != nil {
+		return err
+	}
+
+	err = g.Options("YNAB", config.CurrentYnabConfig().Currencies)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range config.CurrentYnabConfig().Budgets {
+		err = importer.fetchBudget(b)
+		if err != nil {
+			return err
+		}
+
+		rates := map[string]float64{}
+		for _, currency := range config.CurrentYnabConfig().Currencies {
+			rates[currency], err = importer.currencyConverter.ConversionRate(currency, b.Currency)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = g.Prices(time.Now(), b.Currency, rates)
+		if err != nil {
+			return err
+		}
+
+		transactions, err := importer.budgetTransactions(b)
+		if err != nil {
+			return err
+		}
+
+		accountTypes := map[string]string{}
+		for _, account := range importer.budget(b.ID).Accounts {
+			accountTypes[account.Name] = account.Type
+		}
+
+		err = g.Budget(journal.Budget{
+			Name:         b.Name,
+			Currency:     b.Currency,
+			AccountTypes: accountTypes,
+			Transactions: transactionsBetween(transactions, from, to),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func transactionsBetween(transactions []financialimporter.Transaction, from, to time.Time) []financialimporter.Transaction {
+	filtered := make([]financialimporter.Transaction, 0, len(transactions))
+	for _, t := range transactions {
+		if !from.IsZero() && t.Date() < from.Format("2006-01-02") {
+			continue
+		}
+		if !to.IsZero() && t.Date() >= to.Format("2006-01-02") {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+	return filtered
+}
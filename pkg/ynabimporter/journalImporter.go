@@ -0,0 +1,457 @@
+package ynabimporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/budgetexport"
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"github.com/bcaldwell/selfops/pkg/httpsource"
+	"github.com/bcaldwell/selfops/pkg/journal"
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"k8s.io/klog"
+)
+
+// currencySymbols are the currencies of commodities written as a symbol, $ is the currency of the file
+var currencySymbols = map[string]string{"€": "EUR", "£": "GBP", "¥": "JPY"}
+
+// NewImportJournalRunner imports only the journal files, budgeting app exports and http sources, for running without
+// ynab. The rows of ynab budgets in the shared tables are kept and the tables that only ynab fills are left alone. Net
+// worth combines every budget so it is only written when there are no ynab budgets, otherwise the ynab task writes it
+func NewImportJournalRunner() (*ImportYNABRunner, error) {
+	importer, err := NewImportYNABRunner()
+	if err != nil {
+		return nil, err
+	}
+
+	importer.journalOnly = true
+	return importer, nil
+}
+
+// ynabBudgets are the budgets imported by the run, none when only importing journals
+func (importer *ImportYNABRunner) ynabBudgets() []config.Budget {
+	if importer.journalOnly {
+		return nil
+	}
+	return config.CurrentYnabConfig().Budgets
+}
+
+// stageJournals writes the journal files, exports and http sources into the transactions, accounts and budgets shadow
+// tables, and net worth when there are no ynab budgets
+func (importer *ImportYNABRunner) stageJournals() error {
+	err := importer.loadTransactionHistory()
+	if err != nil {
+		return err
+	}
+
+	err = importer.migrateJournalDatasets()
+	if err != nil {
+		return err
+	}
+
+	sqlAccounts, err := importer.importFiles(config.CurrentYnabConfig().Currencies)
+	if err != nil {
+		return err
+	}
+
+	importer.history.RecordBudgetDeletions(isFileBudget)
+
+	return importer.importJournalNetworth(sqlAccounts)
+}
+
+// fileBudgetPrefixes start the budget ids of journal files, budgeting app exports and http sources
+var fileBudgetPrefixes = []string{
+	journal.SourceID(""),
+	httpsource.SourceID(""),
+	budgetexport.TypeActual + "::",
+	budgetexport.TypeFirefly + "::",
+	budgetexport.TypeSplitwise + "::",
+}
+
+// isFileBudget is true for the budget ids of rows written by the journal task
+func isFileBudget(budgetID string) bool {
+	for _, prefix := range fileBudgetPrefixes {
+		if strings.HasPrefix(budgetID, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateJournalDatasets prepares the transactions, accounts and budgets datasets for a journal only run. Unlike a ynab
+// run the existing rows are kept, only the rows of the files are deleted so the run rewrites them
+func (importer *ImportYNABRunner) migrateJournalDatasets() error {
+	ctx := context.Background()
+
+	datasets := map[string]sinks.Dataset{
+		transactionsDataset: financialimporter.TransactionsDataset(config.CurrentYnabConfig().SQL.TransactionsTable),
+		accountsDataset:     accountsSinkDataset(),
+		budgetsDataset:      budgetsSinkDataset(),
+	}
+
+	for name, dataset := range datasets {
+		dataset.Replace = false
+		err := importer.sinks[name].Migrate(ctx, dataset)
+		if err != nil {
+			return err
+		}
+
+		keys, err := importer.fileBudgetKeys(ctx, dataset.Table)
+		if err != nil {
+			return err
+		}
+
+		err = importer.sinks[name].Delete(ctx, dataset, keys)
+		if err != nil {
+			return err
+		}
+	}
+
+	if importer.writesJournalNetworth() {
+		return importer.migrateNetWorth()
+	}
+	return nil
+}
+
+// fileBudgetKeys returns the keys of the rows of tableName written by the journal task
+func (importer *ImportYNABRunner) fileBudgetKeys(ctx context.Context, tableName string) ([]string, error) {
+	// the sqlite tables were created by the sinks, postgres is read from the live table that may not exist yet
+	if importer.db.Dialect().Name() == dialect.PG {
+		columns, err := postgresutils.TableColumns(importer.db, tableName)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(columns, "budget_id") {
+			return nil, nil
+		}
+	}
+
+	keys := []string{}
+	err := importer.db.NewSelect().
+		TableExpr("?", bun.Ident(tableName)).
+		ColumnExpr("key").
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			for _, prefix := range fileBudgetPrefixes {
+				q = q.WhereOr("budget_id LIKE ?", prefix+"%")
+			}
+			return q
+		}).
+		Scan(ctx, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the file rows of %s: %w", tableName, err)
+	}
+
+	return keys, nil
+}
+
+// writesJournalNetworth is true when the journal task owns the net worth table, which is when there are no ynab budgets
+func (importer *ImportYNABRunner) writesJournalNetworth() bool {
+	return len(config.CurrentYnabConfig().Budgets) == 0
+}
+
+func (importer *ImportYNABRunner) importJournalNetworth(sqlAccounts []SQLAccount) error {
+	if !importer.writesJournalNetworth() {
+		slog.Info("skipping net worth, the ynab task writes it")
+		return nil
+	}
+	return importer.importNetworth(sqlAccounts)
+}
+
+func journalName(file config.JournalFile) string {
+	if file.Name != "" {
+		return file.Name
+	}
+	return strings.TrimSuffix(filepath.Base(file.Path), filepath.Ext(file.Path))
+}
+
+// journalCurrency maps a commodity to a currency code, false for commodities that aren't known currencies like stocks
+func journalCurrency(file config.JournalFile, commodity string) (string, bool) {
+	switch {
+	case commodity == "" || commodity == "$":
+		if file.Currency == "" {
+			return "USD", true
+		}
+		return file.Currency, true
+	case slices.Contains(file.Currencies, commodity) || slices.Contains(config.CurrentYnabConfig().Currencies, commodity):
+		return commodity, true
+	}
+
+	currency, ok := currencySymbols[commodity]
+	return currency, ok
+}
+
+// importFiles imports the journal files, budgeting app exports and http sources. Returns the accounts for net worth
+func (importer *ImportYNABRunner) importFiles(currencies []string) ([]SQLAccount, error) {
+	journalAccounts, err := importer.importJournals(currencies)
+	if err != nil {
+		return nil, err
+	}
+
+	exportAccounts, err := importer.importExports(currencies)
+	if err != nil {
+		return nil, err
+	}
+
+	err = importer.importSources(currencies)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(journalAccounts, exportAccounts...), nil
+}
+
+// importJournals writes the transactions and account balances of every journal file. Returns the accounts for net worth
+func (importer *ImportYNABRunner) importJournals(currencies []string) ([]SQLAccount, error) {
+	sqlAccounts := []SQLAccount{}
+	for _, file := range config.CurrentJournalConfig().Files {
+		accounts, err := importer.importJournal(file, currencies)
+		if err != nil {
+			return nil, fmt.Errorf("journal %s: %w", journalName(file), err)
+		}
+		sqlAccounts = append(sqlAccounts, accounts...)
+	}
+
+	return sqlAccounts, nil
+}
+
+func (importer *ImportYNABRunner) importJournal(file config.JournalFile, currencies []string) ([]SQLAccount, error) {
+	j, err := journal.ParseFile(file.Path, file.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	name := journalName(file)
+
+	importAfterDate := time.Time{}
+	if file.ImportAfterDate != "" {
+		importAfterDate, err = time.Parse("01-02-2006", file.ImportAfterDate)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse import after date %s: %v", file.ImportAfterDate, err)
+		}
+	}
+
+	// the transaction importer converts from a single currency
+	byCurrency := map[string][]financialimporter.Transaction{}
+	for _, t := range j.Transactions(name) {
+		currency, ok := journalCurrency(file, t.Currency())
+		if !ok {
+			slog.Warn("skipping journal transaction in a commodity that isn't a currency", "journal", name, "date", t.Date(), "commodity", t.Currency())
+			continue
+		}
+		byCurrency[currency] = append(byCurrency[currency], t)
+	}
+
+	for currency, transactions := range byCurrency {
+		i := financialimporter.NewTransactionImporter(importer.sinks[transactionsDataset], importer.currencyConverter, transactions, file.CalculatedFields, currency, currencies, importAfterDate, config.CurrentYnabConfig().SQL.TransactionsTable, importer.history)
+
+		written, err := i.Import()
+		if err != nil {
+			return nil, err
+		}
+
+		klog.Infof("Wrote %d %s transactions to sql from journal %s\n", written, currency, name)
+	}
+
+	accounts, err := importer.journalAccounts(file, j, currencies)
+	if err != nil {
+		return nil, err
+	}
+
+	return importer.writeAccounts(name, accounts)
+}
+
+// writeAccounts writes the rows of accounts that aren't from ynab, returns every daily row for net worth
+func (importer *ImportYNABRunner) writeAccounts(source string, accounts []*accountAggregator) ([]SQLAccount, error) {
+	sqlAccounts := []SQLAccount{}
+	for _, account := range accounts {
+		records := storedRecords(account.sql, sameAccountBalance)
+		err := importer.sinks[accountsDataset].Upsert(context.Background(), accountsSinkDataset(), &records)
+		if err != nil {
+			return nil, fmt.Errorf("Error writing accounts to sql: %s", err.Error())
+		}
+
+		sqlAccounts = append(sqlAccounts, account.sql...)
+		klog.Infof("Wrote %d accounts to sql from %s account %s\n", len(records), source, account.name)
+	}
+
+	return sqlAccounts, nil
+}
+
+// conversions are the rates from currency to each of currencies
+func (importer *ImportYNABRunner) conversions(currency string, currencies []string) (config.CurrencyConversion, error) {
+	conversion := config.CurrencyConversion{}
+	for _, c := range currencies {
+		rate, err := importer.currencyConverter.ConversionRate(currency, c)
+		if err != nil {
+			return nil, err
+		}
+		conversion[c] = rate
+	}
+	return conversion, nil
+}
+
+type journalAmount struct {
+	date   time.Time
+	amount float64
+}
+
+type journalAccount struct {
+	commodity string
+	open      time.Time
+	close     time.Time
+	postings  []journalAmount
+	balances  []journal.Balance
+}
+
+// journalAccounts builds the daily balances of the asset and liability accounts of a journal from the open date, or
+// the first posting, until today or the close date. Balance assertions win over the running balance when they disagree
+func (importer *ImportYNABRunner) journalAccounts(file config.JournalFile, j *journal.Journal, currencies []string) ([]*accountAggregator, error) {
+	name := journalName(file)
+
+	accounts := map[string]*journalAccount{}
+	account := func(name string) *journalAccount {
+		if _, ok := accounts[name]; !ok {
+			accounts[name] = &journalAccount{}
+		}
+		return accounts[name]
+	}
+
+	for _, open := range j.Opens {
+		if !journal.IsBalanceAccount(open.Account) {
+			continue
+		}
+		a := account(open.Account)
+		a.open = open.Date
+		if len(open.Commodities) > 0 {
+			a.commodity = open.Commodities[0]
+		}
+	}
+
+	for _, c := range j.Closes {
+		if journal.IsBalanceAccount(c.Account) {
+			account(c.Account).close = c.Date
+		}
+	}
+
+	for _, entry := range j.Entries {
+		for _, posting := range entry.Postings {
+			if !journal.IsBalanceAccount(posting.Account) {
+				continue
+			}
+
+			a := account(posting.Account)
+			if len(a.postings) == 0 && a.commodity == "" {
+				a.commodity = posting.Commodity
+			}
+			if posting.Commodity != a.commodity {
+				slog.Warn("skipping journal posting in another commodity than its account", "journal", name, "account", posting.Account, "date", entry.Date, "commodity", posting.Commodity)
+				continue
+			}
+			a.postings = append(a.postings, journalAmount{date: entry.Date, amount: posting.Amount})
+		}
+	}
+
+	for _, balance := range j.Balances {
+		if a, ok := accounts[balance.Account]; ok && balance.Commodity == a.commodity {
+			a.balances = append(a.balances, balance)
+		}
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	names := make([]string, 0, len(accounts))
+	for accountName := range accounts {
+		names = append(names, accountName)
+	}
+	sort.Strings(names)
+
+	aggregators := []*accountAggregator{}
+	for _, accountName := range names {
+		a := accounts[accountName]
+
+		currency, ok := journalCurrency(file, a.commodity)
+		if !ok {
+			slog.Warn("skipping journal account in a commodity that isn't a currency", "journal", name, "account", accountName, "commodity", a.commodity)
+			continue
+		}
+
+		start := a.open
+		sort.SliceStable(a.postings, func(i, j int) bool { return a.postings[i].date.Before(a.postings[j].date) })
+		sort.SliceStable(a.balances, func(i, j int) bool { return a.balances[i].Date.Before(a.balances[j].Date) })
+		if len(a.postings) > 0 && (start.IsZero() || a.postings[0].date.Before(start)) {
+			start = a.postings[0].date
+		}
+		if len(a.balances) > 0 && (start.IsZero() || a.balances[0].Date.Before(start)) {
+			start = a.balances[0].Date
+		}
+		if start.IsZero() {
+			continue
+		}
+
+		conversion, err := importer.conversions(currency, currencies)
+		if err != nil {
+			return nil, err
+		}
+
+		accountType := "otherAsset"
+		if journal.IsLiabilityAccount(accountName) {
+			accountType = "otherLiability"
+		}
+
+		aggregator := &accountAggregator{
+			budgetID:    journal.SourceID(name),
+			accountID:   accountName,
+			name:        accountName,
+			accountType: accountType,
+			onBudget:    true,
+			currency:    currency,
+			budgetName:  name,
+			conversion:  conversion,
+			closed:      !a.close.IsZero(),
+			sql:         []SQLAccount{},
+		}
+		aggregator.ensureSqlForDate(start)
+
+		// assertions are the balance at the start of their day, before the postings of that day
+		assert := func(balance journal.Balance) {
+			i := aggregator.ensureSqlForDate(balance.Date)
+			diff := Round(balance.Amount-aggregator.sql[i].Balance, 0.01)
+			if diff != 0 {
+				slog.Warn("journal balance assertion failed, using the asserted balance", "journal", name, "account", accountName, "date", balance.Date, "expected", balance.Amount, "actual", aggregator.sql[i].Balance)
+				addToBalance(&aggregator.sql[i], diff, aggregator.conversion)
+			}
+		}
+
+		b := 0
+		for _, posting := range a.postings {
+			for ; b < len(a.balances) && !a.balances[b].Date.After(posting.date); b++ {
+				assert(a.balances[b])
+			}
+			aggregator.appendAmount(posting.date, posting.amount)
+		}
+		for ; b < len(a.balances); b++ {
+			assert(a.balances[b])
+		}
+
+		end := today
+		if aggregator.closed && a.close.Before(end) {
+			end = a.close
+		}
+		if last := aggregator.sql[len(aggregator.sql)-1].Date; last.Before(end) {
+			aggregator.ensureSqlForDate(end)
+		}
+
+		aggregators = append(aggregators, aggregator)
+	}
+
+	return aggregators, nil
+}
@@ -0,0 +1,125 @@
+package ynabimporter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"github.com/bcaldwell/selfops/pkg/journal"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournalAccounts(t *testing.T) {
+	j, err := journal.Parse(strings.NewReader(`2024-01-01 open Assets:Chequing CAD
+2024-01-01 open Liabilities:Visa CAD
+2024-01-01 open Assets:Brokerage VTI
+
+2024-01-02 * "Costco"
+  Liabilities:Visa     -80.00 CAD
+  Expenses:Groceries
+
+2024-01-03 * "Pay off visa"
+  Assets:Chequing     -100.00 CAD
+  Liabilities:Visa
+
+2024-01-05 balance Liabilities:Visa  25.00 CAD
+2024-01-06 close Liabilities:Visa
+`), journal.FormatBeancount)
+	require.NoError(t, err)
+
+	importer := &ImportYNABRunner{}
+	accounts, err := importer.journalAccounts(config.JournalFile{Path: "books.beancount", Currencies: []string{"CAD"}}, j, nil)
+	require.NoError(t, err)
+
+	// the brokerage account isn't in a currency
+	require.Len(t, accounts, 2)
+
+	chequing := accounts[0]
+	assert.Equal(t, "Assets:Chequing", chequing.name)
+	assert.Equal(t, "journal::books", chequing.budgetID)
+	assert.Equal(t, "otherAsset", chequing.accountType)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), chequing.sql[0].Date)
+	assert.Equal(t, -100.0, chequing.sql[len(chequing.sql)-1].Balance)
+	assert.False(t, chequing.sql[len(chequing.sql)-1].Date.Before(time.Now().UTC().Truncate(24*time.Hour)))
+
+	visa := accounts[1]
+	assert.Equal(t, "otherLiability", visa.accountType)
+	balances := []float64{}
+	for _, row := range visa.sql {
+		balances = append(balances, row.Balance)
+	}
+	// the assertion on the 5th corrects the balance of 20 to 25 and the account stops at its close date
+	assert.Equal(t, []float64{0, -80, 20, 20, 25, 25}, balances)
+}
+
+func TestJournalOnlyRunKeepsYNABRows(t *testing.T) {
+	ynabConfig, journalConfig := *config.CurrentYnabConfig(), *config.CurrentJournalConfig()
+	t.Cleanup(func() {
+		*config.CurrentYnabConfig() = ynabConfig
+		*config.CurrentJournalConfig() = journalConfig
+	})
+
+	sqlConfig := &config.CurrentYnabConfig().SQL
+	sqlConfig.TransactionsTable, sqlConfig.AccountsTable, sqlConfig.BudgetsTable, sqlConfig.NetworthTable = "transactions", "accounts", "budgets", "networth"
+	config.CurrentYnabConfig().Budgets = []config.Budget{{ID: "b1", Name: "main"}}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "books.beancount")
+	require.NoError(t, os.WriteFile(path, []byte(`2024-01-01 open Assets:Chequing USD
+
+2024-01-02 * "Costco"
+  Assets:Chequing     -80.00 USD
+  Expenses:Groceries
+`), 0o644))
+	config.CurrentJournalConfig().Files = []config.JournalFile{{Path: path, Currencies: []string{"USD"}}}
+
+	sqlitePath := filepath.Join(dir, "selfops.db")
+	db, err := sinks.OpenSQLite(sqlitePath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// rows of an earlier ynab run and of a transaction since removed from the journal
+	ctx := context.Background()
+	sink := sinks.NewSQLiteSink(db, 0)
+	transactions := financialimporter.TransactionsDataset("transactions")
+	require.NoError(t, sink.Migrate(ctx, transactions))
+	date := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	existing := []financialimporter.SQLTransaction{
+		{Key: "ynab-1", BudgetID: "b1", TransactionDate: date, Amount: -10},
+		{Key: "journal::books::stale", BudgetID: "journal::books", TransactionDate: date, Amount: -5},
+	}
+	require.NoError(t, sink.Upsert(ctx, transactions, &existing))
+	require.NoError(t, sink.Migrate(ctx, accountsSinkDataset()))
+	accounts := []SQLAccount{{Key: accountKey(date, "b1", "a1"), Date: date, BudgetID: "b1", AccountID: "a1", Balance: 100}}
+	require.NoError(t, sink.Upsert(ctx, accountsSinkDataset(), &accounts))
+
+	_, err = db.NewCreateTable().Model(&LastSeen{}).Exec(ctx)
+	require.NoError(t, err)
+
+	importer := &ImportYNABRunner{db: db, journalOnly: true, opts: Options{SQLitePath: sqlitePath}, sourceStates: map[string]string{}}
+	require.NoError(t, importer.importYNABToSQLite())
+
+	budgetIDs := []string{}
+	require.NoError(t, db.NewSelect().TableExpr("transactions").ColumnExpr("budget_id").Order("budget_id").Scan(ctx, &budgetIDs))
+	assert.Equal(t, []string{"b1", "journal::books"}, budgetIDs)
+
+	keys := []string{}
+	require.NoError(t, db.NewSelect().TableExpr("transactions").ColumnExpr("key").Where("budget_id = ?", "journal::books").Scan(ctx, &keys))
+	assert.NotContains(t, keys, "journal::books::stale")
+
+	ynabAccounts, err := db.NewSelect().TableExpr("accounts").Where("budget_id = ?", "b1").Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ynabAccounts)
+
+	// net worth belongs to the ynab task while there are ynab budgets
+	var networthTables int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'networth'`).Scan(&networthTables))
+	assert.Equal(t, 0, networthTables)
+}
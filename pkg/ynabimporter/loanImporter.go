@@ -0,0 +1,397 @@
+package ynabimporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/davidsteinsland/ynab-go/ynab"
+	"github.com/uptrace/bun"
+)
+
+// loans longer than this are treated as never being paid off when projecting
+const maxLoanMonths = 100 * 12
+
+// SQLLoan is the summary for a configured loan, one row per loan
+type SQLLoan struct {
+	bun.BaseModel `bun:"table:loans"`
+	ID            int64  `bun:",pk,autoincrement"`
+	Key           string `bun:",pk,unique"`
+	Name          string
+	Account       string
+	BudgetName    string
+	Currency      string
+	Principal     float64
+	Rate          float64
+	TermMonths    int
+	StartDate     time.Time
+	Payment       float64
+	// payoff date and interest if the loan follows the original schedule
+	ScheduledPayoffDate time.Time `bun:",nullzero"`
+	ScheduledInterest   float64
+	// payoff date and interest based on the current balance and recent payments
+	ProjectedPayoffDate time.Time `bun:",nullzero"`
+	ProjectedInterest   float64
+	PrincipalPaid       float64
+	InterestPaid        float64
+	ModeledBalance      float64
+	ActualBalance       float64
+	BalanceDifference   float64
+	UpdatedAt           time.Time
+}
+
+// SQLLoanPayment is a payment transaction into the loan account split into principal and interest
+type SQLLoanPayment struct {
+	bun.BaseModel `bun:"table:loan_payments"`
+	ID            int64  `bun:",pk,autoincrement"`
+	Key           string `bun:",pk,unique"`
+	Loan          string
+	TransactionID string
+	Date          time.Time
+	Currency      string
+	Amount        float64
+	Principal     float64
+	Interest      float64
+	Balance       float64
+	PrincipalUSD  float64
+	PrincipalCAD  float64
+	InterestUSD   float64
+	InterestCAD   float64
+}
+
+// SQLLoanSchedule is the original amortization schedule reconciled against the account balance
+type SQLLoanSchedule struct {
+	bun.BaseModel `bun:"table:loan_schedule"`
+	ID            int64  `bun:",pk,autoincrement"`
+	Key           string `bun:",pk,unique"`
+	Loan          string
+	PaymentNumber int
+	Date          time.Time
+	Payment       float64
+	Principal     float64
+	Interest      float64
+	Balance       float64
+	// actual balance from the accounts table, null for future payments
+	ActualBalance     *float64
+	BalanceDifference *float64
+}
+
+type amortizationPayment struct {
+	number    int
+	date      time.Time
+	payment   float64
+	principal float64
+	interest  float64
+	balance   float64
+}
+
+func loanPayment(principal, monthlyRate float64, months int) float64 {
+	if months <= 0 {
+		return principal
+	}
+	if monthlyRate == 0 {
+		return principal / float64(months)
+	}
+	return principal * monthlyRate / (1 - math.Pow(1+monthlyRate, -float64(months)))
+}
+
+// amortizationSchedule generates monthly payments until the balance is paid off. Stops early if the payment doesn't cover the interest
+func amortizationSchedule(balance, monthlyRate, payment float64, start time.Time, maxMonths int) []amortizationPayment {
+	schedule := []amortizationPayment{}
+
+	for i := 1; balance >= 0.005 && i <= maxMonths; i++ {
+		interest := balance * monthlyRate
+		amount := math.Min(payment, balance+interest)
+		principal := amount - interest
+		if principal <= 0 {
+			break
+		}
+
+		balance -= principal
+		schedule = append(schedule, amortizationPayment{
+			number:    i,
+			date:      start.AddDate(0, i, 0),
+			payment:   amount,
+			principal: principal,
+			interest:  interest,
+			balance:   math.Max(balance, 0),
+		})
+	}
+
+	return schedule
+}
+
+func monthsBetween(a, b time.Time) int {
+	return (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())
+}
+
+func tableOrDefault(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+func (importer *ImportYNABRunner) migrateLoans() error {
+	if len(config.CurrentYnabConfig().Loans) == 0 {
+		return nil
+	}
+
+	sqlConfig := config.CurrentYnabConfig().SQL
+	tables := map[string]interface{}{
+		importer.table(tableOrDefault(sqlConfig.LoansTable, "loans")):                (*SQLLoan)(nil),
+		importer.table(tableOrDefault(sqlConfig.LoanPaymentsTable, "loan_payments")): (*SQLLoanPayment)(nil),
+		importer.table(tableOrDefault(sqlConfig.LoanScheduleTable, "loan_schedule")): (*SQLLoanSchedule)(nil),
+	}
+
+	// all loan tables are derived from the accounts so they are rebuilt every run
+	for tableName, model := range tables {
+		_, err := importer.db.NewDropTable().Model(model).ModelTableExpr(tableName).Exec(context.Background())
+		if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("ERROR: table \"%s\" does not exist (SQLSTATE=42P01)", tableName)) {
+			return fmt.Errorf("failed to drop %s table: %w", tableName, err)
+		}
+
+		_, err = importer.db.NewCreateTable().Model(model).ModelTableExpr(tableName).IfNotExists().Exec(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to create %s table: %w", tableName, err)
+		}
+
+		if err := postgresutils.SetUnlogged(importer.db, tableName); err != nil {
+			return fmt.Errorf("failed to set %s unlogged: %w", tableName, err)
+		}
+	}
+
+	return nil
+}
+
+func (importer *ImportYNABRunner) importLoans(accounts []SQLAccount) error {
+	sqlConfig := config.CurrentYnabConfig().SQL
+
+	for _, loan := range config.CurrentYnabConfig().Loans {
+		budget, account, err := importer.findLoanAccount(loan)
+		if err != nil {
+			return err
+		}
+
+		startDate, err := time.Parse("01-02-2006", loan.StartDate)
+		if err != nil {
+			return fmt.Errorf("Failed to parse start date %s for loan %s: %v", loan.StartDate, loan.Name, err)
+		}
+
+		// loan balances are negative in ynab, flip them so the model works with positive numbers
+		balanceHistory := map[time.Time]float64{}
+		for _, a := range accounts {
+			if a.BudgetID == budget.ID && a.AccountID == account.Id {
+				balanceHistory[a.Date] = -a.Balance
+			}
+		}
+
+		monthlyRate := loan.Rate / 100 / 12
+		payment := loanPayment(loan.Principal, monthlyRate, loan.TermMonths)
+		schedule := amortizationSchedule(loan.Principal, monthlyRate, payment, startDate, loan.TermMonths)
+
+		sqlSchedule := make([]SQLLoanSchedule, 0, len(schedule))
+		for _, p := range schedule {
+			row := SQLLoanSchedule{
+				Key:           fmt.Sprintf("%s::%d", loan.Name, p.number),
+				Loan:          loan.Name,
+				PaymentNumber: p.number,
+				Date:          p.date,
+				Payment:       Round(p.payment, 0.01),
+				Principal:     Round(p.principal, 0.01),
+				Interest:      Round(p.interest, 0.01),
+				Balance:       Round(p.balance, 0.01),
+			}
+
+			if actual, ok := balanceHistory[p.date]; ok {
+				difference := Round(actual-p.balance, 0.01)
+				actual = Round(actual, 0.01)
+				row.ActualBalance = &actual
+				row.BalanceDifference = &difference
+			}
+
+			sqlSchedule = append(sqlSchedule, row)
+		}
+
+		sqlPayments, modeledBalance := splitLoanPayments(loan, budget, account.Id, importer.budget(budget.ID).Transactions, startDate, monthlyRate)
+
+		summary := SQLLoan{
+			Key:            loan.Name,
+			Name:           loan.Name,
+			Account:        account.Name,
+			BudgetName:     budget.Name,
+			Currency:       budget.Currency,
+			Principal:      loan.Principal,
+			Rate:           loan.Rate,
+			TermMonths:     loan.TermMonths,
+			StartDate:      startDate,
+			Payment:        Round(payment, 0.01),
+			ModeledBalance: Round(modeledBalance, 0.01),
+			ActualBalance:  Round(-float64(account.Balance)/balanceMultiplier, 0.01),
+			UpdatedAt:      time.Now(),
+		}
+		summary.BalanceDifference = Round(summary.ActualBalance-summary.ModeledBalance, 0.01)
+
+		if len(schedule) > 0 {
+			summary.ScheduledPayoffDate = schedule[len(schedule)-1].date
+		}
+		for _, p := range schedule {
+			summary.ScheduledInterest += p.interest
+		}
+		summary.ScheduledInterest = Round(summary.ScheduledInterest, 0.01)
+
+		for _, p := range sqlPayments {
+			summary.PrincipalPaid += p.Principal
+			summary.InterestPaid += p.Interest
+		}
+		summary.PrincipalPaid = Round(summary.PrincipalPaid, 0.01)
+		summary.InterestPaid = Round(summary.InterestPaid, 0.01)
+
+		// project from the actual balance using the larger of the scheduled payment and the recent average
+		projectedPayment := math.Max(payment, recentAveragePayment(sqlPayments, 3))
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		projection := amortizationSchedule(summary.ActualBalance, monthlyRate, projectedPayment, today, maxLoanMonths)
+		if len(projection) > 0 && projection[len(projection)-1].balance < 0.005 {
+			summary.ProjectedPayoffDate = projection[len(projection)-1].date
+		} else if summary.ActualBalance > 0 {
+			slog.Warn("loan payments don't cover interest, unable to project payoff", "loan", loan.Name, "payment", projectedPayment)
+		}
+		for _, p := range projection {
+			summary.ProjectedInterest += p.interest
+		}
+		summary.ProjectedInterest = Round(summary.InterestPaid+summary.ProjectedInterest, 0.01)
+
+		if len(sqlSchedule) > 0 {
+			_, err = importer.db.NewInsert().
+				Model(&sqlSchedule).
+				ModelTableExpr(importer.table(tableOrDefault(sqlConfig.LoanScheduleTable, "loan_schedule"))).
+				On("CONFLICT (key) DO UPDATE").
+				Set(postgresutils.TableSetString(importer.db, (*SQLLoanSchedule)(nil), "id", "key")).
+				Exec(context.Background())
+			if err != nil {
+				return fmt.Errorf("Error writing loan schedule to sql: %s", err.Error())
+			}
+		}
+
+		if len(sqlPayments) > 0 {
+			_, err = importer.db.NewInsert().
+				Model(&sqlPayments).
+				ModelTableExpr(importer.table(tableOrDefault(sqlConfig.LoanPaymentsTable, "loan_payments"))).
+				On("CONFLICT (key) DO UPDATE").
+				Set(postgresutils.TableSetString(importer.db, (*SQLLoanPayment)(nil), "id", "key")).
+				Exec(context.Background())
+			if err != nil {
+				return fmt.Errorf("Error writing loan payments to sql: %s", err.Error())
+			}
+		}
+
+		_, err = importer.db.NewInsert().
+			Model(&summary).
+			ModelTableExpr(importer.table(tableOrDefault(sqlConfig.LoansTable, "loans"))).
+			On("CONFLICT (key) DO UPDATE").
+			Set(postgresutils.TableSetString(importer.db, (*SQLLoan)(nil), "id", "key")).
+			Exec(context.Background())
+		if err != nil {
+			return fmt.Errorf("Error writing loan to sql: %s", err.Error())
+		}
+
+		if summary.BalanceDifference != 0 {
+			slog.Warn("loan model doesn't match account balance", "loan", loan.Name, "modeled", summary.ModeledBalance, "actual", summary.ActualBalance)
+		}
+
+		slog.Info("Wrote loan to sql", "loan", loan.Name, "payments", len(sqlPayments), "schedule", len(sqlSchedule))
+	}
+
+	return nil
+}
+
+func (importer *ImportYNABRunner) findLoanAccount(loan config.Loan) (config.Budget, ynab.Account, error) {
+	for _, b := range config.CurrentYnabConfig().Budgets {
+		if loan.Budget != "" && loan.Budget != b.Name {
+			continue
+		}
+
+		for _, account := range importer.budget(b.ID).Accounts {
+			if account.Name == loan.Account {
+				return b, account, nil
+			}
+		}
+	}
+
+	return config.Budget{}, ynab.Account{}, fmt.Errorf("Unable to find account %s for loan %s", loan.Account, loan.Name)
+}
+
+// splitLoanPayments splits payments into the loan account into principal and interest. Interest accrues monthly on the modeled
+// balance, so multiple payments in the same month only pay down principal. Returns the payments and the remaining modeled balance
+func splitLoanPayments(loan config.Loan, budget config.Budget, accountID string, transactions []ynab.TransactionSummary, startDate time.Time, monthlyRate float64) ([]SQLLoanPayment, float64) {
+	payments := []ynab.TransactionSummary{}
+	for _, transaction := range transactions {
+		if transaction.AccountId == accountID && transaction.Amount > 0 {
+			payments = append(payments, transaction)
+		}
+	}
+
+	slices.SortFunc(payments, func(a, b ynab.TransactionSummary) int {
+		return strings.Compare(a.Date, b.Date)
+	})
+
+	balance := loan.Principal
+	lastInterestDate := startDate
+	sqlPayments := []SQLLoanPayment{}
+
+	for _, transaction := range payments {
+		date, err := time.Parse("2006-01-02", transaction.Date)
+		if err != nil || date.Before(startDate) {
+			continue
+		}
+
+		amount := float64(transaction.Amount) / balanceMultiplier
+		interest := 0.0
+		if months := monthsBetween(lastInterestDate, date); months > 0 {
+			interest = balance * (math.Pow(1+monthlyRate, float64(months)) - 1)
+			lastInterestDate = date
+		}
+
+		interest = math.Min(interest, amount)
+		principal := amount - interest
+		balance = math.Max(balance-principal, 0)
+
+		sqlPayments = append(sqlPayments, SQLLoanPayment{
+			Key:           fmt.Sprintf("%s::%s", loan.Name, transaction.Id),
+			Loan:          loan.Name,
+			TransactionID: transaction.Id,
+			Date:          date,
+			Currency:      budget.Currency,
+			Amount:        Round(amount, 0.01),
+			Principal:     Round(principal, 0.01),
+			Interest:      Round(interest, 0.01),
+			Balance:       Round(balance, 0.01),
+			PrincipalUSD:  Round(principal*budget.Conversions["USD"], 0.01),
+			PrincipalCAD:  Round(principal*budget.Conversions["CAD"], 0.01),
+			InterestUSD:   Round(interest*budget.Conversions["USD"], 0.01),
+			InterestCAD:   Round(interest*budget.Conversions["CAD"], 0.01),
+		})
+	}
+
+	return sqlPayments, balance
+}
+
+func recentAveragePayment(payments []SQLLoanPayment, count int) float64 {
+	if len(payments) == 0 {
+		return 0
+	}
+
+	recent := payments[max(0, len(payments)-count):]
+	total := 0.0
+	for _, p := range recent {
+		total += p.Amount
+	}
+
+	return total / float64(len(recent))
+}
@@ -0,0 +1,56 @@
+package ynabimporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/davidsteinsland/ynab-go/ynab"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAmortizationSchedule(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	monthlyRate := 0.06 / 12
+	payment := loanPayment(100000, monthlyRate, 360)
+
+	assert.InDelta(t, 599.55, payment, 0.01)
+
+	schedule := amortizationSchedule(100000, monthlyRate, payment, start, 360)
+	assert.Len(t, schedule, 360)
+	assert.Equal(t, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), schedule[0].date)
+	assert.InDelta(t, 500.0, schedule[0].interest, 0.01)
+	assert.InDelta(t, 99.55, schedule[0].principal, 0.01)
+	assert.InDelta(t, 0, schedule[359].balance, 0.01)
+
+	interest := 0.0
+	for _, p := range schedule {
+		interest += p.interest
+	}
+	assert.InDelta(t, 115838.19, interest, 1)
+
+	// payment that doesn't cover interest never pays off
+	assert.Len(t, amortizationSchedule(100000, monthlyRate, 400, start, maxLoanMonths), 0)
+}
+
+func TestSplitLoanPayments(t *testing.T) {
+	loan := config.Loan{Name: "mortgage", Principal: 1000}
+	budget := config.Budget{Currency: "USD", Conversions: config.CurrencyConversion{"USD": 1, "CAD": 1.3}}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	payments, balance := splitLoanPayments(loan, budget, "loan", []ynab.TransactionSummary{
+		{Id: "3", Date: "2024-02-15", Amount: 50 * balanceMultiplier, AccountId: "loan"},
+		{Id: "1", Date: "2024-02-01", Amount: 110 * balanceMultiplier, AccountId: "loan"},
+		{Id: "2", Date: "2024-02-01", Amount: -20 * balanceMultiplier, AccountId: "loan"},
+		{Id: "4", Date: "2024-02-01", Amount: 500 * balanceMultiplier, AccountId: "checking"},
+	}, start, 0.01)
+
+	assert.Len(t, payments, 2)
+	assert.Equal(t, "mortgage::1", payments[0].Key)
+	assert.Equal(t, 10.0, payments[0].Interest)
+	assert.Equal(t, 100.0, payments[0].Principal)
+	// second payment in the same month is all principal
+	assert.Equal(t, 0.0, payments[1].Interest)
+	assert.Equal(t, 50.0, payments[1].Principal)
+	assert.Equal(t, 850.0, balance)
+}
@@ -5,11 +5,8 @@ import (
 	"fmt"
 	"log/slog"
 	"slices"
-	"strings"
 	"time"
 
-	"github.com/bcaldwell/selfops/pkg/config"
-	"github.com/bcaldwell/selfops/pkg/postgresutils"
 	"github.com/uptrace/bun"
 )
 
@@ -23,47 +20,40 @@ type SQLNetWorth struct {
 	Date            time.Time `bun:",unique"`
 	USD             float64
 	CAD             float64
-	BudgetBreakdown map[string]map[string]float64 `bun:"type:jsonb"`
+	BudgetBreakdown map[string]NetWorthBreakdown `bun:"type:jsonb"`
+}
+
+// NetWorthBreakdown is the net worth of a budget, Name is its display name when the row was written
+type NetWorthBreakdown struct {
+	Name string  `json:"name"`
+	USD  float64 `json:"usd"`
+	CAD  float64 `json:"cad"`
 }
 
 func (s SQLNetWorth) ItemDate() time.Time {
 	return s.Date
 }
 
+// addAccountToRow adds the balance of account to the totals and the breakdown, which is keyed by budget id so renaming a
+// budget keeps its series
 func addAccountToRow(row *SQLNetWorth, account SQLAccount) {
 	row.USD += account.USD
 	row.CAD += account.CAD
 
-	if _, ok := row.BudgetBreakdown[account.BudgetName]; !ok {
-		row.BudgetBreakdown[account.BudgetName] = map[string]float64{
-			"usd": 0,
-			"cad": 0,
-		}
-	}
-	row.BudgetBreakdown[account.BudgetName]["usd"] += account.USD
-	row.BudgetBreakdown[account.BudgetName]["cad"] += account.CAD
+	breakdown := row.BudgetBreakdown[account.BudgetID]
+	breakdown.Name = account.BudgetName
+	breakdown.USD += account.USD
+	breakdown.CAD += account.CAD
+	row.BudgetBreakdown[account.BudgetID] = breakdown
 }
 
 func (importer *ImportYNABRunner) migrateNetWorth() error {
-	tableName := config.CurrentYnabConfig().SQL.NetworthTable
-	model := (*SQLNetWorth)(nil)
-	// todo make this come from config
 	// easiest way to handle deleted transactions, with the speed at which it works not too bad
-	_, err := importer.db.NewDropTable().Model(model).ModelTableExpr(tableName).Exec(context.Background())
-	if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("ERROR: table \"%s\" does not exist (SQLSTATE=42P01)", tableName)) {
-		return fmt.Errorf("failed to drop %s table: %w", tableName, err)
-	}
-	_, err = importer.db.NewCreateTable().Model(model).ModelTableExpr(tableName).IfNotExists().Exec(context.Background())
-	if err != nil {
-		return err
-	}
-	return postgresutils.SetUnlogged(importer.db, tableName)
+	return importer.sinks[networthDataset].Migrate(context.Background(), networthSinkDataset())
 }
 
 func (importer *ImportYNABRunner) importNetworth(accounts []SQLAccount) error {
 	slog.Info("starting net worth import")
-	tableName := config.CurrentYnabConfig().SQL.NetworthTable
-
 	slices.SortFunc(accounts, func(a, b SQLAccount) int {
 		return a.Date.Compare(b.Date)
 	})
@@ -74,7 +64,7 @@ func (importer *ImportYNABRunner) importNetworth(accounts []SQLAccount) error {
 		rows = ensureOrderedSqlRecordsForDate(account.Date, func(t time.Time, last *SQLNetWorth) *SQLNetWorth {
 			return &SQLNetWorth{
 				Date:            t,
-				BudgetBreakdown: map[string]map[string]float64{},
+				BudgetBreakdown: map[string]NetWorthBreakdown{},
 			}
 		}, rows)
 		addAccountToRow(&rows[len(rows)-1], account)
@@ -83,8 +73,8 @@ func (importer *ImportYNABRunner) importNetworth(accounts []SQLAccount) error {
 	// clean up values
 	for i, row := range rows {
 		for j, budgetBreakdown := range row.BudgetBreakdown {
-			budgetBreakdown["usd"] = Round(budgetBreakdown["usd"], 0.01)
-			budgetBreakdown["cad"] = Round(budgetBreakdown["cad"], 0.01)
+			budgetBreakdown.USD = Round(budgetBreakdown.USD, 0.01)
+			budgetBreakdown.CAD = Round(budgetBreakdown.CAD, 0.01)
 			rows[i].BudgetBreakdown[j] = budgetBreakdown
 		}
 
@@ -92,15 +82,10 @@ func (importer *ImportYNABRunner) importNetworth(accounts []SQLAccount) error {
 		rows[i].USD = Round(row.USD, 0.01)
 	}
 
+	rows = storedRecords(rows, sameNetWorth)
+
 	slog.Info("About to write net worth to sql", "rows", len(rows))
-	_, err := importer.db.NewInsert().
-		Model(&rows).
-		ModelTableExpr(tableName).
-		On("CONFLICT (date) DO UPDATE").
-		Set("budget_breakdown = EXCLUDED.budget_breakdown").
-		Set("usd = EXCLUDED.usd").
-		Set("cad = EXCLUDED.cad").
-		Exec(context.Background())
+	err := importer.sinks[networthDataset].Upsert(context.Background(), networthSinkDataset(), &rows)
 	if err != nil {
 		return fmt.Errorf("Failed to write net worth to db: %v", err)
 	}
@@ -0,0 +1,20 @@
+package ynabimporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddAccountToRow(t *testing.T) {
+	row := SQLNetWorth{BudgetBreakdown: map[string]NetWorthBreakdown{}}
+	addAccountToRow(&row, SQLAccount{BudgetID: "b1", BudgetName: "Personal", USD: 10, CAD: 13})
+	addAccountToRow(&row, SQLAccount{BudgetID: "b1", BudgetName: "Personal", USD: 5, CAD: 6.5})
+	addAccountToRow(&row, SQLAccount{BudgetID: "b2", BudgetName: "Shared", USD: -2, CAD: -2.6})
+
+	assert.Equal(t, 13.0, row.USD)
+	assert.Equal(t, map[string]NetWorthBreakdown{
+		"b1": {Name: "Personal", USD: 15, CAD: 19.5},
+		"b2": {Name: "Shared", USD: -2, CAD: -2.6},
+	}, row.BudgetBreakdown)
+}
@@ -0,0 +1,73 @@
+package ynabimporter
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ynab allows 200 requests per hour per access token
+const defaultRateLimit = 200
+
+// rateLimiter is a token bucket shared by all requests made with the same access token
+type rateLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	burst  float64
+	// tokens added per second
+	rate float64
+	last time.Time
+}
+
+func newRateLimiter(requestsPerHour int) *rateLimiter {
+	if requestsPerHour <= 0 {
+		requestsPerHour = defaultRateLimit
+	}
+
+	return &rateLimiter{
+		tokens: float64(requestsPerHour),
+		burst:  float64(requestsPerHour),
+		rate:   float64(requestsPerHour) / time.Hour.Seconds(),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a request is allowed
+func (l *rateLimiter) Wait() {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return
+		}
+
+		wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+type rateLimitedTransport struct {
+	limiter *rateLimiter
+	base    http.RoundTripper
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.limiter.Wait()
+	return t.base.RoundTrip(req)
+}
+
+func newRateLimitedClient(requestsPerHour int) *http.Client {
+	return &http.Client{
+		Transport: &rateLimitedTransport{
+			limiter: newRateLimiter(requestsPerHour),
+			base:    http.DefaultTransport,
+		},
+	}
+}
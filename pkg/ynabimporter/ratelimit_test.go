@@ -0,0 +1,37 @@
+package ynabimporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterDefaults(t *testing.T) {
+	l := newRateLimiter(0)
+	assert.Equal(t, float64(defaultRateLimit), l.burst)
+	assert.Equal(t, float64(defaultRateLimit), l.tokens)
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	l := newRateLimiter(3)
+
+	start := time.Now()
+	for range 3 {
+		l.Wait()
+	}
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.Less(t, l.tokens, 1.0)
+}
+
+func TestRateLimiterWaitsForToken(t *testing.T) {
+	l := newRateLimiter(1)
+	l.Wait()
+
+	// a token every 50ms
+	l.rate = 20
+
+	start := time.Now()
+	l.Wait()
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
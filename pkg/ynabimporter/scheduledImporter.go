@@ -0,0 +1,372 @@
+package ynabimporter
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/uptrace/bun"
+	"k8s.io/klog"
+)
+
+const defaultForecastDays = 90
+
+// SQLScheduledTransaction is an upcoming scheduled transaction, split scheduled transactions have a row per subtransaction
+type SQLScheduledTransaction struct {
+	bun.BaseModel     `bun:"table:scheduled_transactions"`
+	ID                int64  `bun:",pk,autoincrement"`
+	Key               string `bun:",pk,unique"`
+	BudgetID          string
+	AccountID         string
+	TransferAccountID string
+	PayeeID           string
+	CategoryID        string
+	BudgetName        string
+	Account           string
+	TransferAccount   string
+	Payee             string
+	Category          string
+	CategoryGroup     string
+	Memo              string `bun:"type:text"`
+	Frequency         string
+	DateFirst         time.Time
+	DateNext          time.Time
+	Currency          string
+	Amount            float64
+	USD               float64
+	CAD               float64
+}
+
+// SQLCashFlowForecast is the projected balance of an account on a day, from the current balance plus the scheduled
+// transactions up to that day
+type SQLCashFlowForecast struct {
+	bun.BaseModel `bun:"table:cash_flow_forecast"`
+	ID            int64  `bun:",pk,autoincrement"`
+	Key           string `bun:",pk,unique"`
+	Date          time.Time
+	BudgetID      string
+	AccountID     string
+	Name          string
+	BudgetName    string
+	Currency      string
+	// sum of the scheduled transactions on this day
+	Scheduled float64
+	Balance   float64
+	USD       float64
+	CAD       float64
+}
+
+func scheduledTransactionsTable() string {
+	return tableOrDefault(config.CurrentYnabConfig().SQL.ScheduledTransactionsTable, "scheduled_transactions")
+}
+
+func cashFlowForecastTable() string {
+	return tableOrDefault(config.CurrentYnabConfig().SQL.CashFlowForecastTable, "cash_flow_forecast")
+}
+
+func (importer *ImportYNABRunner) migrateScheduledTransactions() error {
+	tables := map[string]interface{}{
+		importer.table(scheduledTransactionsTable()): (*SQLScheduledTransaction)(nil),
+		importer.table(cashFlowForecastTable()):      (*SQLCashFlowForecast)(nil),
+	}
+
+	// scheduled transactions are rebuilt every run so deleted ones disappear
+	for tableName, model := range tables {
+		_, err := importer.db.NewDropTable().Model(model).ModelTableExpr(tableName).Exec(context.Background())
+		if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("ERROR: table \"%s\" does not exist (SQLSTATE=42P01)", tableName)) {
+			return fmt.Errorf("failed to drop %s table: %w", tableName, err)
+		}
+
+		_, err = importer.db.NewCreateTable().Model(model).ModelTableExpr(tableName).IfNotExists().Exec(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to create %s table: %w", tableName, err)
+		}
+
+		if err := postgresutils.SetUnlogged(importer.db, tableName); err != nil {
+			return fmt.Errorf("failed to set %s unlogged: %w", tableName, err)
+		}
+	}
+
+	return nil
+}
+
+func (importer *ImportYNABRunner) importScheduledTransactions(budget config.Budget) ([]SQLScheduledTransaction, error) {
+	budgetDetail := importer.budget(budget.ID)
+	categories := importer.budgetCategories(budget.ID)
+
+	accountNames := map[string]string{}
+	for _, account := range budgetDetail.Accounts {
+		accountNames[account.Id] = account.Name
+	}
+
+	payeeNames := map[string]string{}
+	for _, payee := range budgetDetail.Payees {
+		payeeNames[payee.Id] = payee.Name
+	}
+
+	subTransactions := map[string][]int{}
+	for i, sub := range budgetDetail.ScheduledSubtransactions {
+		subTransactions[sub.ScheduledTransactionId] = append(subTransactions[sub.ScheduledTransactionId], i)
+	}
+
+	sqlRecords := []SQLScheduledTransaction{}
+	for _, scheduled := range budgetDetail.ScheduledTransactions {
+		dateFirst, err := time.Parse("2006-01-02", scheduled.DateFirst)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse scheduled transaction first date: %w", err)
+		}
+
+		dateNext, err := time.Parse("2006-01-02", scheduled.DateNext)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse scheduled transaction next date: %w", err)
+		}
+
+		newRow := func(key string, amount int, payeeID, categoryID, transferAccountID, memo *string) SQLScheduledTransaction {
+			value := float64(amount) / balanceMultiplier
+			category := categories[stringValue(categoryID)]
+
+			return SQLScheduledTransaction{
+				Key:               key,
+				BudgetID:          budget.ID,
+				AccountID:         scheduled.AccountId,
+				TransferAccountID: stringValue(transferAccountID),
+				PayeeID:           stringValue(payeeID),
+				CategoryID:        stringValue(categoryID),
+				BudgetName:        budget.Name,
+				Account:           accountNames[scheduled.AccountId],
+				TransferAccount:   accountNames[stringValue(transferAccountID)],
+				Payee:             payeeNames[stringValue(payeeID)],
+				Category:          category.Name,
+				CategoryGroup:     category.Group,
+				Memo:              stringValue(memo),
+				Frequency:         scheduled.Frequency,
+				DateFirst:         dateFirst,
+				DateNext:          dateNext,
+				Currency:          budget.Currency,
+				Amount:            value,
+				USD:               Round(value*budget.Conversions["USD"], 0.01),
+				CAD:               Round(value*budget.Conversions["CAD"], 0.01),
+			}
+		}
+
+		subs := subTransactions[scheduled.Id]
+		if len(subs) == 0 {
+			sqlRecords = append(sqlRecords, newRow(scheduled.Id, scheduled.Amount, scheduled.PayeeId, scheduled.CategoryId, scheduled.TransferAccountId, scheduled.Memo))
+			continue
+		}
+
+		for _, i := range subs {
+			sub := budgetDetail.ScheduledSubtransactions[i]
+			payeeID := sub.PayeeId
+			if payeeID == nil {
+				payeeID = scheduled.PayeeId
+			}
+			sqlRecords = append(sqlRecords, newRow(sub.Id, sub.Amount, payeeID, sub.CategoryId, sub.TransferAccountId, sub.Memo))
+		}
+	}
+
+	if len(sqlRecords) == 0 {
+		return sqlRecords, nil
+	}
+
+	_, err := importer.db.NewInsert().Model(&sqlRecords).ModelTableExpr(importer.table(scheduledTransactionsTable())).Exec(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error writing scheduled transactions: %w", err)
+	}
+
+	klog.Infof("Wrote %d scheduled transactions to sql from budget %s\n", len(sqlRecords), budget.Name)
+
+	return sqlRecords, nil
+}
+
+// importCashFlowForecast projects the current account balances forward using the scheduled transactions
+func (importer *ImportYNABRunner) importCashFlowForecast(accounts []SQLAccount, scheduled []SQLScheduledTransaction) error {
+	days := config.CurrentYnabConfig().ForecastDays
+	if days == 0 {
+		days = defaultForecastDays
+	}
+
+	conversions := map[string]config.CurrencyConversion{}
+	for _, budget := range config.CurrentYnabConfig().Budgets {
+		conversions[budget.ID] = budget.Conversions
+	}
+
+	sqlRecords := cashFlowForecast(accounts, scheduled, time.Now().UTC().Truncate(24*time.Hour), days, conversions)
+
+	for i := 0; i < len(sqlRecords); i += batchSize() {
+		endIndex := min(len(sqlRecords), i+batchSize())
+
+		records := sqlRecords[i:endIndex]
+		_, err := importer.db.NewInsert().Model(&records).ModelTableExpr(importer.table(cashFlowForecastTable())).Exec(context.Background())
+		if err != nil {
+			return fmt.Errorf("error writing cash flow forecast: %w", err)
+		}
+	}
+
+	klog.Infof("Wrote %d cash flow forecast rows to sql\n", len(sqlRecords))
+
+	return nil
+}
+
+// cashFlowForecast returns a row per account per day from today until days from now. Accounts start at their latest
+// balance, closed accounts have no balance for today and are left out
+func cashFlowForecast(accounts []SQLAccount, scheduled []SQLScheduledTransaction, today time.Time, days int, conversions map[string]config.CurrencyConversion) []SQLCashFlowForecast {
+	end := today.AddDate(0, 0, days)
+
+	latest := map[string]SQLAccount{}
+	for _, account := range accounts {
+		key := account.BudgetID + "::" + account.AccountID
+		if account.Date.Equal(today) {
+			latest[key] = account
+		}
+	}
+
+	// account key to date to sum of scheduled amounts
+	changes := map[string]map[time.Time]float64{}
+	addChange := func(key string, date time.Time, amount float64) {
+		if _, ok := changes[key]; !ok {
+			changes[key] = map[time.Time]float64{}
+		}
+		changes[key][date] += amount
+	}
+
+	for _, s := range scheduled {
+		for _, date := range scheduledOccurrences(s.Frequency, s.DateFirst, s.DateNext, end) {
+			if date.Before(today) {
+				continue
+			}
+
+			addChange(s.BudgetID+"::"+s.AccountID, date, s.Amount)
+			if s.TransferAccountID != "" {
+				addChange(s.BudgetID+"::"+s.TransferAccountID, date, -s.Amount)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(latest))
+	for key := range latest {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	rows := []SQLCashFlowForecast{}
+	for _, key := range keys {
+		account := latest[key]
+		conversion := conversions[account.BudgetID]
+		balance := account.Balance
+
+		for date := today; !date.After(end); date = date.AddDate(0, 0, 1) {
+			amount := changes[key][date]
+			balance += amount
+
+			rows = append(rows, SQLCashFlowForecast{
+				Key:        accountKey(date, account.BudgetID, account.AccountID),
+				Date:       date,
+				BudgetID:   account.BudgetID,
+				AccountID:  account.AccountID,
+				Name:       account.Name,
+				BudgetName: account.BudgetName,
+				Currency:   account.Currency,
+				Scheduled:  Round(amount, 0.01),
+				Balance:    Round(balance, 0.01),
+				USD:        Round(balance*conversion["USD"], 0.01),
+				CAD:        Round(balance*conversion["CAD"], 0.01),
+			})
+		}
+	}
+
+	return rows
+}
+
+// scheduledOccurrences returns every date the scheduled transaction happens from next until end. Monthly frequencies
+// stay on the day of the month of the first date, falling back to the end of shorter months
+func scheduledOccurrences(frequency string, first, next, end time.Time) []time.Time {
+	occurrences := []time.Time{}
+
+	if frequency == "never" {
+		if !next.After(end) {
+			occurrences = append(occurrences, next)
+		}
+		return occurrences
+	}
+
+	if frequency == "twiceAMonth" {
+		// YNAB repeats on the first date's day and 15 days later
+		days := []int{first.Day(), first.Day() + 15}
+		if first.Day() > 15 {
+			days = []int{first.Day() - 15, first.Day()}
+		}
+
+		for month := time.Date(next.Year(), next.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(end); month = month.AddDate(0, 1, 0) {
+			for _, day := range days {
+				date := dayOfMonth(month, day)
+				if !date.Before(next) && !date.After(end) {
+					occurrences = append(occurrences, date)
+				}
+			}
+		}
+		return occurrences
+	}
+
+	dayIntervals := map[string]int{
+		"daily":          1,
+		"weekly":         7,
+		"everyOtherWeek": 14,
+		"every4Weeks":    28,
+	}
+	monthIntervals := map[string]int{
+		"monthly":         1,
+		"everyOtherMonth": 2,
+		"every3Months":    3,
+		"every4Months":    4,
+		"twiceAYear":      6,
+		"yearly":          12,
+		"everyOtherYear":  24,
+	}
+
+	if interval, ok := dayIntervals[frequency]; ok {
+		for date := next; !date.After(end); date = date.AddDate(0, 0, interval) {
+			occurrences = append(occurrences, date)
+		}
+		return occurrences
+	}
+
+	if interval, ok := monthIntervals[frequency]; ok {
+		// count from the first date so short months don't move later occurrences
+		start := monthsBetween(first, next)
+		for i := start; ; i += interval {
+			month := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, i, 0)
+			date := dayOfMonth(month, first.Day())
+			if date.After(end) {
+				break
+			}
+			if !date.Before(next) {
+				occurrences = append(occurrences, date)
+			}
+		}
+		return occurrences
+	}
+
+	klog.Warningf("Unknown scheduled transaction frequency %s, only using the next date\n", frequency)
+	if !next.After(end) {
+		occurrences = append(occurrences, next)
+	}
+	return occurrences
+}
+
+// dayOfMonth returns day in the month of month, or the last day of the month if it is shorter
+func dayOfMonth(month time.Time, day int) time.Time {
+	lastDay := time.Date(month.Year(), month.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return time.Date(month.Year(), month.Month(), min(day, lastDay), 0, 0, 0, 0, time.UTC)
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
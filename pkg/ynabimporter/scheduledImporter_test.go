@@ -0,0 +1,52 @@
+package ynabimporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestScheduledOccurrences(t *testing.T) {
+	end := date(2024, 5, 31)
+
+	assert.Equal(t, []time.Time{date(2024, 1, 31), date(2024, 2, 29), date(2024, 3, 31), date(2024, 4, 30), date(2024, 5, 31)},
+		scheduledOccurrences("monthly", date(2023, 1, 31), date(2024, 1, 31), end))
+	assert.Equal(t, []time.Time{date(2024, 5, 1), date(2024, 5, 15), date(2024, 5, 29)},
+		scheduledOccurrences("everyOtherWeek", date(2024, 1, 1), date(2024, 5, 1), end))
+	assert.Equal(t, []time.Time{date(2024, 4, 20), date(2024, 5, 5), date(2024, 5, 20)},
+		scheduledOccurrences("twiceAMonth", date(2024, 1, 5), date(2024, 4, 20), end))
+	assert.Equal(t, []time.Time{date(2024, 3, 1)},
+		scheduledOccurrences("never", date(2024, 3, 1), date(2024, 3, 1), end))
+	assert.Len(t, scheduledOccurrences("yearly", date(2023, 6, 1), date(2024, 6, 1), end), 0)
+}
+
+func TestCashFlowForecast(t *testing.T) {
+	today := date(2024, 5, 1)
+	accounts := []SQLAccount{
+		{Date: today.AddDate(0, 0, -1), BudgetID: "home", AccountID: "checking", Name: "Checking", Balance: 900},
+		{Date: today, BudgetID: "home", AccountID: "checking", Name: "Checking", Balance: 1000},
+		{Date: today, BudgetID: "home", AccountID: "visa", Name: "Visa", Balance: -300},
+	}
+	scheduled := []SQLScheduledTransaction{
+		{BudgetID: "home", AccountID: "checking", Frequency: "monthly", DateFirst: date(2024, 1, 3), DateNext: date(2024, 5, 3), Amount: -1200},
+		{BudgetID: "home", AccountID: "checking", TransferAccountID: "visa", Frequency: "never", DateFirst: date(2024, 5, 2), DateNext: date(2024, 5, 2), Amount: -300},
+	}
+
+	rows := cashFlowForecast(accounts, scheduled, today, 3, map[string]config.CurrencyConversion{"home": {"USD": 1, "CAD": 1.5}})
+
+	// 4 days for each account
+	assert.Len(t, rows, 8)
+	assert.Equal(t, 1000.0, rows[0].Balance)
+	assert.Equal(t, 700.0, rows[1].Balance)
+	assert.Equal(t, -500.0, rows[2].Balance)
+	assert.Equal(t, -1200.0, rows[2].Scheduled)
+	assert.Equal(t, -750.0, rows[2].CAD)
+	assert.Equal(t, "Visa", rows[5].Name)
+	assert.Equal(t, 0.0, rows[5].Balance)
+}
@@ -0,0 +1,96 @@
+package ynabimporter
+
+import (
+	"fmt"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+)
+
+const (
+	transactionsDataset = "transactions"
+	accountsDataset     = "accounts"
+	budgetsDataset      = "budgets"
+	networthDataset     = "networth"
+)
+
+// Datasets returns the datasets the runner writes keyed by name
+func Datasets() map[string]sinks.Dataset {
+	return map[string]sinks.Dataset{
+		transactionsDataset: financialimporter.TransactionsDataset(config.CurrentYnabConfig().SQL.TransactionsTable),
+		accountsDataset:     accountsSinkDataset(),
+		budgetsDataset:      budgetsSinkDataset(),
+		networthDataset:     networthSinkDataset(),
+	}
+}
+
+func accountsSinkDataset() sinks.Dataset {
+	return sinks.Dataset{
+		Name:       accountsDataset,
+		Table:      config.CurrentYnabConfig().SQL.AccountsTable,
+		Model:      (*SQLAccount)(nil),
+		KeyColumn:  "key",
+		TimeColumn: "date",
+		Replace:    true,
+	}
+}
+
+func budgetsSinkDataset() sinks.Dataset {
+	return sinks.Dataset{
+		Name:       budgetsDataset,
+		Table:      config.CurrentYnabConfig().SQL.BudgetsTable,
+		Model:      (*SQLBudget)(nil),
+		KeyColumn:  "key",
+		TimeColumn: "month",
+	}
+}
+
+func networthSinkDataset() sinks.Dataset {
+	return sinks.Dataset{
+		Name:       networthDataset,
+		Table:      config.CurrentYnabConfig().SQL.NetworthTable,
+		Model:      (*SQLNetWorth)(nil),
+		KeyColumn:  "date",
+		TimeColumn: "date",
+		Replace:    true,
+	}
+}
+
+// openSinks opens the sinks of every dataset for a run, defaultType is used for datasets without configured sinks.
+// Sinks of the same type as the runner's connection share it, postgres sinks write to the shadow tables of the run
+func (importer *ImportYNABRunner) openSinks(defaultType string) error {
+	importer.defaultSinkType = defaultType
+	importer.sinks = make(map[string]sinks.Sink)
+	for _, dataset := range []string{transactionsDataset, accountsDataset, budgetsDataset, networthDataset} {
+		sink, err := importer.openSink(dataset)
+		if err != nil {
+			importer.closeSinks()
+			return err
+		}
+		importer.sinks[dataset] = sink
+	}
+
+	return nil
+}
+
+// openSink opens the sinks of a dataset with the defaults of the run
+func (importer *ImportYNABRunner) openSink(dataset string) (sinks.Sink, error) {
+	return sinks.Open(dataset, importer.defaultSinkType, sinks.Options{
+		DB:           importer.db,
+		ShadowTables: importer.tables,
+		Database:     config.CurrentYnabConfig().SQL.YnabDatabase,
+		SQLitePath:   importer.opts.SQLitePath,
+		BatchSize:    batchSize(),
+		DefaultOnly:  importer.opts.DefaultSinks,
+	})
+}
+
+func (importer *ImportYNABRunner) closeSinks() {
+	for dataset, sink := range importer.sinks {
+		if err := sink.Close(); err != nil {
+			fmt.Printf("Failed to close %s sink: %s\n", dataset, err)
+		}
+	}
+	importer.sinks = nil
+}
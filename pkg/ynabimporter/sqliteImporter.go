@@ -0,0 +1,101 @@
+package ynabimporter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/uptrace/bun"
+)
+
+// importYNABToSQLite imports into the SQLite file of the runner, for running without a postgres server. Only
+// transactions, accounts, budgets and net worth are written, the tables built with postgres features (history,
+// dimensions, loans, the forecast, goals and their views) are skipped. Rows are written in place without shadow tables.
+// Journal files, budgeting app exports and http sources are imported alongside the budgets
+func (importer *ImportYNABRunner) importYNABToSQLite() error {
+	err := importer.openSinks(sinks.TypeSQLite)
+	if err != nil {
+		return err
+	}
+	defer importer.closeSinks()
+
+	if importer.journalOnly {
+		err = importer.migrateJournalDatasets()
+	} else {
+		err = importer.migrateSQLiteDatasets()
+	}
+	if err != nil {
+		return err
+	}
+
+	var sqlAccountsMu sync.Mutex
+	sqlAccounts := []SQLAccount{}
+
+	err = forEachBudget(importer.ynabBudgets(), config.CurrentYnabConfig().Concurrency, func(b config.Budget) error {
+		err := importer.fetchBudget(b)
+		if err != nil {
+			return err
+		}
+
+		err = importer.importTransactions(b, config.CurrentYnabConfig().Currencies)
+		if err != nil {
+			return err
+		}
+
+		currentSqlAccounts, err := importer.importAccounts(b, config.CurrentYnabConfig().Currencies)
+		if err != nil {
+			return err
+		}
+
+		sqlAccountsMu.Lock()
+		sqlAccounts = append(sqlAccounts, currentSqlAccounts...)
+		sqlAccountsMu.Unlock()
+
+		_, err = importer.importBudgets(b, config.CurrentYnabConfig().Currencies)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fileAccounts, err := importer.importFiles(config.CurrentYnabConfig().Currencies)
+	if err != nil {
+		return err
+	}
+
+	if importer.journalOnly {
+		err = importer.importJournalNetworth(fileAccounts)
+	} else {
+		err = importer.importNetworth(append(sqlAccounts, fileAccounts...))
+	}
+	if err != nil {
+		return err
+	}
+
+	return importer.db.RunInTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		return importer.saveRunState(ctx, tx)
+	})
+}
+
+func (importer *ImportYNABRunner) migrateSQLiteDatasets() error {
+	fimporter := financialimporter.NewTransactionImporter(importer.sinks[transactionsDataset], importer.currencyConverter, nil, nil, "", nil, time.Now(), config.CurrentYnabConfig().SQL.TransactionsTable, nil)
+	err := fimporter.Migrate()
+	if err != nil {
+		return err
+	}
+
+	err = importer.migrateBudgets()
+	if err != nil {
+		return err
+	}
+
+	err = importer.migrateAccounts()
+	if err != nil {
+		return err
+	}
+
+	return importer.migrateNetWorth()
+}
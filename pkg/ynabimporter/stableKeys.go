@@ -0,0 +1,88 @@
+package ynabimporter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/uptrace/bun"
+	"k8s.io/klog"
+)
+
+// LastSeen endpoint recording that the tables were migrated to id based keys
+const stableKeysMigration = "migrations/stable-keys"
+
+// migrateStableKeys fills in the new id columns of the budgets and budget snapshots tables, which keep rows between runs.
+// Their keys already end in the category id so they are kept, rows written by the next run update the migrated rows. It
+// runs once, before the first import that uses ids. The name based keys of the accounts and net worth tables aren't
+// rewritten on purpose, both are Replace datasets dropped and rebuilt from the budgets every run, so the first run
+// after the migration writes them with id based keys. Rows are matched on the current names, rows for budgets that were
+// renamed before the migration are left without ids
+func (importer *ImportYNABRunner) migrateStableKeys() error {
+	lastSeen := LastSeen{}
+	err := importer.db.NewSelect().Model(&lastSeen).Where("endpoint = ?", stableKeysMigration).Limit(1).Scan(context.Background())
+	if err == nil {
+		return nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	err = importer.db.RunInTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := migrateBudgetIDs(ctx, tx, config.CurrentYnabConfig().SQL.BudgetsTable, len("2006-01-02-")); err != nil {
+			return err
+		}
+
+		if err := migrateBudgetIDs(ctx, tx, budgetSnapshotsTable(), len("2006-01-02-2006-01-02-")); err != nil {
+			return err
+		}
+
+		_, err := tx.NewInsert().Model(&LastSeen{Endpoint: stableKeysMigration, LastSeen: time.Now().Format(time.RFC3339)}).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to migrate to id based keys: %w", err)
+	}
+
+	klog.Infof("Migrated existing tables to id based keys\n")
+
+	return nil
+}
+
+// migrateBudgetIDs fills in the id columns of a budgets table. The keys already end in the category id, categoryOffset is
+// the length of the prefix before it
+func migrateBudgetIDs(ctx context.Context, tx bun.Tx, tableName string, categoryOffset int) error {
+	columns, err := postgresutils.TableColumns(tx, tableName)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+
+	// one column at a time, sqlite can't add several or only missing ones
+	for _, column := range []string{"budget_id", "category_id"} {
+		if slices.Contains(columns, column) {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, "ALTER TABLE ? ADD COLUMN ? varchar", bun.Ident(tableName), bun.Ident(column))
+		if err != nil {
+			return fmt.Errorf("failed to add id columns to %s: %w", tableName, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE ? SET category_id = substr(key, ?) WHERE category_id IS NULL", bun.Ident(tableName), categoryOffset+1)
+	if err != nil {
+		return fmt.Errorf("failed to migrate %s category ids: %w", tableName, err)
+	}
+
+	for _, budget := range config.CurrentYnabConfig().Budgets {
+		_, err = tx.ExecContext(ctx, "UPDATE ? SET budget_id = ? WHERE name = ? AND budget_id IS NULL", bun.Ident(tableName), budget.ID, budget.Name)
+		if err != nil {
+			return fmt.Errorf("failed to migrate %s budget ids: %w", tableName, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package ynabimporter
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateStableKeys(t *testing.T) {
+	ynabConfig := *config.CurrentYnabConfig()
+	t.Cleanup(func() {
+		*config.CurrentYnabConfig() = ynabConfig
+	})
+	config.CurrentYnabConfig().SQL.BudgetsTable = "budgets"
+	config.CurrentYnabConfig().Budgets = []config.Budget{{ID: "b1", Name: "main"}}
+
+	ctx := context.Background()
+	db, err := sinks.OpenSQLite(filepath.Join(t.TempDir(), "selfops.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.NewCreateTable().Model(&LastSeen{}).Exec(ctx)
+	require.NoError(t, err)
+
+	// a budgets row written before the id columns existed
+	sink := sinks.NewSQLiteSink(db, 0)
+	require.NoError(t, sink.Migrate(ctx, budgetsSinkDataset()))
+	month := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := "2024-01-01-5b1f-groceries"
+	existing := []SQLBudget{{Key: key, Name: "main", Category: "Groceries", Month: month, Budgeted: 100}}
+	require.NoError(t, sink.Upsert(ctx, budgetsSinkDataset(), &existing))
+	_, err = db.ExecContext(ctx, "ALTER TABLE budgets DROP COLUMN budget_id")
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "ALTER TABLE budgets DROP COLUMN category_id")
+	require.NoError(t, err)
+
+	importer := &ImportYNABRunner{db: db}
+	require.NoError(t, importer.migrateStableKeys())
+
+	migrated := SQLBudget{}
+	require.NoError(t, db.NewSelect().Model(&migrated).ModelTableExpr("budgets AS sql_budget").Where("key = ?", key).Scan(ctx))
+	assert.Equal(t, "b1", migrated.BudgetID)
+	assert.Equal(t, "5b1f-groceries", migrated.CategoryID)
+
+	// the next run updates the migrated row instead of adding one
+	rows := []SQLBudget{{Key: key, BudgetID: "b1", CategoryID: "5b1f-groceries", Name: "main", Category: "Groceries", Month: month, Budgeted: 120}}
+	require.NoError(t, sink.Upsert(ctx, budgetsSinkDataset(), &rows))
+
+	budgets := []SQLBudget{}
+	require.NoError(t, db.NewSelect().Model(&budgets).ModelTableExpr("budgets AS sql_budget").Scan(ctx))
+	require.Len(t, budgets, 1)
+	assert.Equal(t, 120.0, budgets[0].Budgeted)
+	assert.Equal(t, "b1", budgets[0].BudgetID)
+
+	// the migration only runs once
+	_, err = db.ExecContext(ctx, "UPDATE budgets SET budget_id = NULL")
+	require.NoError(t, err)
+	require.NoError(t, importer.migrateStableKeys())
+	count, err := db.NewSelect().TableExpr("budgets").Where("budget_id IS NULL").Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
@@ -14,9 +14,10 @@ import (
 type (
 	TransactionType string
 	category        struct {
-		Name  string
-		Group string
-		Id    string
+		Name    string
+		Group   string
+		GroupID string
+		Id      string
 	}
 )
 
@@ -25,33 +26,15 @@ var defaultRegex = "^[A-Za-z0-9]([A-Za-z0-9\\-\\_]+)?$"
 // http://www.postgresqltutorial.com/postgresql-array/
 
 func (importer *ImportYNABRunner) importTransactions(budget config.Budget, currencies []string) error {
-	regexPattern := config.CurrentYnabConfig().Tags.RegexMatch
-	if regexPattern == "" {
-		regexPattern = defaultRegex
-	}
-
-	regex := regexp.MustCompile(regexPattern)
-
 	lastSeen := LastSeen{}
 	_, err := importer.db.NewSelect().Model(&lastSeen).Where("endpoint = ?", "transactions").Exec(context.Background())
 	if err != nil {
 		return err
 	}
 
-	// need to get transactions from transaction service to have the sub transaction data
-	ynabTransactions, err := importer.ynabClient.TransactionsService.List(budget.ID)
+	transactions, err := importer.budgetTransactions(budget)
 	if err != nil {
-		return fmt.Errorf("Error getting transactions: %s", err.Error())
-	}
-
-	transactions := make([]financialimporter.Transaction, len(ynabTransactions))
-
-	for i := range ynabTransactions {
-		transactions[i] = &YnabTransaction{
-			TransactionDetail: &ynabTransactions[i],
-			Regex:             regex,
-			CategoryIDMap:     importer.categories[budget.Name],
-		}
+		return err
 	}
 
 	importAfterDate := time.Time{}
@@ -62,7 +45,7 @@ func (importer *ImportYNABRunner) importTransactions(budget config.Budget, curre
 		}
 	}
 
-	i := financialimporter.NewTransactionImporter(importer.db, importer.currencyConverter, transactions, budget.CalculatedFields, budget.Currency, currencies, importAfterDate, config.CurrentYnabConfig().SQL.TransactionsTable)
+	i := financialimporter.NewTransactionImporter(importer.sinks[transactionsDataset], importer.currencyConverter, transactions, budget.CalculatedFields, budget.Currency, currencies, importAfterDate, config.CurrentYnabConfig().SQL.TransactionsTable, importer.history)
 
 	written, err := i.Import()
 	if err != nil {
@@ -73,3 +56,38 @@ func (importer *ImportYNABRunner) importTransactions(budget config.Budget, curre
 
 	return nil
 }
+
+// budgetTransactions lists the transactions of a budget, the budget must have been fetched
+func (importer *ImportYNABRunner) budgetTransactions(budget config.Budget) ([]financialimporter.Transaction, error) {
+	regexPattern := config.CurrentYnabConfig().Tags.RegexMatch
+	if regexPattern == "" {
+		regexPattern = defaultRegex
+	}
+
+	regex := regexp.MustCompile(regexPattern)
+
+	// need to get transactions from transaction service to have the sub transaction data
+	ynabTransactions, err := importer.ynabClient.TransactionsService.List(budget.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting transactions: %s", err.Error())
+	}
+
+	accountNames := make(map[string]string)
+	for _, account := range importer.budget(budget.ID).Accounts {
+		accountNames[account.Id] = account.Name
+	}
+
+	transactions := make([]financialimporter.Transaction, len(ynabTransactions))
+
+	for i := range ynabTransactions {
+		transactions[i] = &YnabTransaction{
+			TransactionDetail: &ynabTransactions[i],
+			Regex:             regex,
+			BudgetId:          budget.ID,
+			CategoryIDMap:     importer.budgetCategories(budget.ID),
+			AccountNames:      accountNames,
+		}
+	}
+
+	return transactions, nil
+}
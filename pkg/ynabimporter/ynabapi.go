@@ -0,0 +1,218 @@
+package ynabimporter
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/davidsteinsland/ynab-go/ynab"
+	"github.com/uptrace/bun"
+)
+
+// ynabGet requests a YNAB endpoint directly for the parts of the responses the ynab client doesn't expose
+func (importer *ImportYNABRunner) ynabGet(path string, v interface{}) error {
+	endpoint := importer.ynabClient.BaseURL.ResolveReference(&url.URL{Path: path})
+
+	req, err := http.NewRequest("GET", endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+config.CurrentYnabSecrets().YnabAccessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := importer.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		errorResponse := ynab.ErrorResponse{Response: resp}
+		json.Unmarshal(body, &errorResponse)
+		return errorResponse
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// budgetExtras are the fields of a budget the ynab client doesn't decode, keyed by id
+type budgetExtras struct {
+	Categories map[string]categoryExtras
+	// month to category id, goal progress as of that month
+	Months         map[string]map[string]categoryExtras
+	CategoryGroups map[string]deletedExtras
+	Payees         map[string]deletedExtras
+	PayeeLocations map[string]deletedExtras
+}
+
+type deletedExtras struct {
+	ID      string `json:"id"`
+	Deleted bool   `json:"deleted"`
+}
+
+type categoryExtras struct {
+	ID                     string  `json:"id"`
+	Deleted                bool    `json:"deleted"`
+	GoalType               *string `json:"goal_type"`
+	GoalCreationMonth      *string `json:"goal_creation_month"`
+	GoalTarget             *int64  `json:"goal_target"`
+	GoalTargetMonth        *string `json:"goal_target_month"`
+	GoalPercentageComplete *int    `json:"goal_percentage_complete"`
+	GoalUnderFunded        *int64  `json:"goal_under_funded"`
+	GoalOverallFunded      *int64  `json:"goal_overall_funded"`
+	GoalOverallLeft        *int64  `json:"goal_overall_left"`
+}
+
+// getBudget is BudgetService.Get but also returns the fields the client drops and the server knowledge of the budget
+func (importer *ImportYNABRunner) getBudget(id string) (ynab.BudgetDetail, budgetExtras, int64, error) {
+	var raw json.RawMessage
+	if err := importer.ynabGet("budgets/"+id, &raw); err != nil {
+		return ynab.BudgetDetail{}, budgetExtras{}, 0, err
+	}
+
+	var response ynab.BudgetDetailResponse
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return ynab.BudgetDetail{}, budgetExtras{}, 0, err
+	}
+
+	var extrasResponse struct {
+		Data struct {
+			Budget struct {
+				Categories     []categoryExtras `json:"categories"`
+				CategoryGroups []deletedExtras  `json:"category_groups"`
+				Payees         []deletedExtras  `json:"payees"`
+				PayeeLocations []deletedExtras  `json:"payee_locations"`
+				Months         []struct {
+					Month      string           `json:"month"`
+					Categories []categoryExtras `json:"categories"`
+				} `json:"months"`
+			} `json:"budget"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &extrasResponse); err != nil {
+		return ynab.BudgetDetail{}, budgetExtras{}, 0, err
+	}
+
+	budget := extrasResponse.Data.Budget
+	extras := budgetExtras{
+		Categories:     make(map[string]categoryExtras, len(budget.Categories)),
+		Months:         make(map[string]map[string]categoryExtras, len(budget.Months)),
+		CategoryGroups: byID(budget.CategoryGroups),
+		Payees:         byID(budget.Payees),
+		PayeeLocations: byID(budget.PayeeLocations),
+	}
+	for _, c := range budget.Categories {
+		extras.Categories[c.ID] = c
+	}
+	for _, m := range budget.Months {
+		extras.Months[m.Month] = make(map[string]categoryExtras, len(m.Categories))
+		for _, c := range m.Categories {
+			extras.Months[m.Month][c.ID] = c
+		}
+	}
+
+	return response.Data.Budget, extras, int64(response.Data.ServerKnowledge), nil
+}
+
+func byID(items []deletedExtras) map[string]deletedExtras {
+	m := make(map[string]deletedExtras, len(items))
+	for _, item := range items {
+		m[item.ID] = item
+	}
+	return m
+}
+
+func budgetEndpoint(budgetID string) string {
+	return "budgets/" + budgetID
+}
+
+// lastServerKnowledge returns the server knowledge saved by the last successful run, 0 if there isn't one
+func (importer *ImportYNABRunner) lastServerKnowledge(endpoint string) (int64, error) {
+	value, err := importer.lastSeen(endpoint)
+	if err != nil || value == "" {
+		return 0, err
+	}
+
+	knowledge, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid last seen %s for %s: %w", value, endpoint, err)
+	}
+
+	return knowledge, nil
+}
+
+// lastSeen returns the value saved for an endpoint by the last successful run, empty if there isn't one
+func (importer *ImportYNABRunner) lastSeen(endpoint string) (string, error) {
+	lastSeen := LastSeen{}
+	err := importer.db.NewSelect().Model(&lastSeen).Where("endpoint = ?", endpoint).Limit(1).Scan(context.Background())
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return lastSeen.LastSeen, nil
+}
+
+func saveServerKnowledge(ctx context.Context, db bun.IDB, endpoint string, knowledge int64) error {
+	return saveLastSeen(ctx, db, endpoint, strconv.FormatInt(knowledge, 10))
+}
+
+func saveLastSeen(ctx context.Context, db bun.IDB, endpoint string, value string) error {
+	_, err := db.NewDelete().Model((*LastSeen)(nil)).Where("endpoint = ?", endpoint).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewInsert().Model(&LastSeen{Endpoint: endpoint, LastSeen: value}).Exec(ctx)
+	return err
+}
+
+// saveRunState saves the server knowledge of the budgets and the state of the http sources of the run
+func (importer *ImportYNABRunner) saveRunState(ctx context.Context, db bun.IDB) error {
+	for budgetID, knowledge := range importer.serverKnowledge {
+		if err := saveServerKnowledge(ctx, db, budgetEndpoint(budgetID), knowledge); err != nil {
+			return fmt.Errorf("failed to save server knowledge: %w", err)
+		}
+	}
+
+	// sources removed from the config don't keep their state
+	configured := []string{}
+	for _, source := range config.CurrentHTTPSources() {
+		configured = append(configured, sourceEndpoint(source))
+	}
+	q := db.NewDelete().Model((*LastSeen)(nil)).Where("endpoint LIKE ?", sourceEndpointPrefix+"%")
+	if len(configured) > 0 {
+		q = q.Where("endpoint NOT IN (?)", bun.In(configured))
+	}
+	if _, err := q.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to remove the state of removed sources: %w", err)
+	}
+
+	for endpoint, state := range importer.sourceStates {
+		if !slices.Contains(configured, endpoint) {
+			delete(importer.sourceStates, endpoint)
+			continue
+		}
+
+		if err := saveLastSeen(ctx, db, endpoint, state); err != nil {
+			return fmt.Errorf("failed to save state of %s: %w", endpoint, err)
+		}
+	}
+
+	return nil
+}
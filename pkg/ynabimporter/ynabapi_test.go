@@ -0,0 +1,74 @@
+package ynabimporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/davidsteinsland/ynab-go/ynab"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetBudgetExtras(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/budgets/budget-id", r.URL.Path)
+		w.Write([]byte(`{"data": {"server_knowledge": 42, "budget": {
+			"id": "budget-id",
+			"categories": [{"id": "groceries", "name": "Groceries", "deleted": false, "goal_type": "MF", "goal_target": 450000, "goal_percentage_complete": 50}],
+			"payees": [{"id": "old-payee", "name": "Old", "deleted": true}]
+		}}}`))
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL + "/v1/")
+	importer := &ImportYNABRunner{
+		ynabClient: ynab.NewClient(baseURL, server.Client(), ""),
+		httpClient: server.Client(),
+	}
+
+	budget, extras, knowledge, err := importer.getBudget("budget-id")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), knowledge)
+	assert.Equal(t, "Groceries", budget.Categories[0].Name)
+
+	goal := extras.Categories["groceries"]
+	assert.Equal(t, "MF", *goal.GoalType)
+	assert.Equal(t, 450.0, milliunits(goal.GoalTarget))
+	assert.Equal(t, 50, intValue(goal.GoalPercentageComplete))
+	assert.Equal(t, 0.0, milliunits(goal.GoalUnderFunded))
+	assert.True(t, extras.Payees["old-payee"].Deleted)
+}
+
+func TestSaveRunStateDropsRemovedSources(t *testing.T) {
+	sources := config.CurrentConfig().Sources
+	t.Cleanup(func() { config.CurrentConfig().Sources = sources })
+	config.CurrentConfig().Sources = []config.HTTPSource{{Name: "bank"}}
+
+	ctx := context.Background()
+	db, err := sinks.OpenSQLite(filepath.Join(t.TempDir(), "selfops.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.NewCreateTable().Model(&LastSeen{}).Exec(ctx)
+	require.NoError(t, err)
+	for _, endpoint := range []string{"http/bank", "http/removed", "budgets/b1"} {
+		require.NoError(t, saveLastSeen(ctx, db, endpoint, "1"))
+	}
+
+	importer := &ImportYNABRunner{
+		serverKnowledge: map[string]int64{},
+		sourceStates:    map[string]string{"http/bank": "2", "http/removed": "2"},
+	}
+	require.NoError(t, importer.saveRunState(ctx, db))
+
+	saved := []LastSeen{}
+	require.NoError(t, db.NewSelect().Model(&saved).Order("endpoint").Scan(ctx))
+	assert.Equal(t, []LastSeen{{Endpoint: "budgets/b1", LastSeen: "1"}, {Endpoint: "http/bank", LastSeen: "2"}}, saved)
+	assert.Equal(t, map[string]string{"http/bank": "2"}, importer.sourceStates)
+}
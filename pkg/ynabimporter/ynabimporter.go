@@ -3,21 +3,45 @@ package ynabimporter
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/bcaldwell/selfops/pkg/config"
 	"github.com/bcaldwell/selfops/pkg/financialimporter"
+	"github.com/bcaldwell/selfops/pkg/importruns"
 	"github.com/bcaldwell/selfops/pkg/postgresutils"
+	"github.com/bcaldwell/selfops/pkg/sinks"
 	"github.com/davidsteinsland/ynab-go/ynab"
 	"github.com/uptrace/bun"
 )
 
 type ImportYNABRunner struct {
 	ynabClient        *ynab.Client
+	httpClient        *http.Client
 	currencyConverter *financialimporter.CurrencyConverter
 	db                *bun.DB
-	budgets           map[string]ynab.BudgetDetail
-	categories        map[string]map[string]category
+	// shadow tables for the current run, every write goes to these until the run succeeds
+	tables *postgresutils.ShadowTables
+	sinks  map[string]sinks.Sink
+	// sink type of datasets without configured sinks
+	defaultSinkType string
+	run             *importruns.Run
+	history         *financialimporter.TransactionHistory
+	// only import the journal files, see NewImportJournalRunner
+	journalOnly bool
+	// state of the incremental http sources keyed by endpoint, saved with the server knowledge
+	sourceStates map[string]string
+	opts         Options
+	// budgets, extras, categories and serverKnowledge are filled in by the budget workers, use the accessors
+	mu              sync.RWMutex
+	budgets         map[string]ynab.BudgetDetail
+	extras          map[string]budgetExtras
+	categories      map[string]map[string]category
+	serverKnowledge map[string]int64
 }
 
 type LastSeen struct {
@@ -25,7 +49,18 @@ type LastSeen struct {
 	LastSeen string
 }
 
-func (importer *ImportYNABRunner) Run() error {
+const defaultConcurrency = 4
+
+// Options change where a runner writes from what is configured
+type Options struct {
+	// SQLite file written instead of postgres, defaults to the configured SQLitePath
+	SQLitePath string
+	// Only write to the default sinks, ignoring the configured sinks
+	DefaultSinks bool
+}
+
+func (importer *ImportYNABRunner) Run(run *importruns.Run) error {
+	importer.run = run
 	return importer.importYNAB()
 }
 
@@ -34,98 +69,428 @@ func (importer *ImportYNABRunner) Close() error {
 }
 
 func NewImportYNABRunner() (*ImportYNABRunner, error) {
-	ynabClient := ynab.NewDefaultClient(config.CurrentYnabSecrets().YnabAccessToken)
+	return NewImportYNABRunnerWithOptions(Options{})
+}
+
+func NewImportYNABRunnerWithOptions(opts Options) (*ImportYNABRunner, error) {
+	if opts.SQLitePath == "" {
+		opts.SQLitePath = config.CurrentYnabConfig().SQL.SQLitePath
+	}
 
-	db, err := postgresutils.CreatePostgresClient(config.CurrentYnabConfig().SQL.YnabDatabase)
+	baseURL, err := url.Parse(ynab.DefaultBaseURL)
 	if err != nil {
-		return nil, fmt.Errorf("Error connecting to postgres DB: %s", err)
+		return nil, err
+	}
+
+	// all budgets share the same client so they share the rate limit
+	httpClient := newRateLimitedClient(config.CurrentYnabConfig().RateLimit)
+	ynabClient := ynab.NewClient(baseURL, httpClient, config.CurrentYnabSecrets().YnabAccessToken)
+
+	var db *bun.DB
+	if opts.SQLitePath != "" {
+		db, err = sinks.OpenSQLite(opts.SQLitePath)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		db, err = postgresutils.CreatePostgresClient(config.CurrentYnabConfig().SQL.YnabDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to postgres DB: %s", err)
+		}
 	}
 
 	return &ImportYNABRunner{
 		ynabClient:        ynabClient,
+		httpClient:        httpClient,
 		currencyConverter: financialimporter.NewCurrencyConverter(config.CurrentExchangeRateAPISecrets().AccessKey),
 		db:                db,
 		budgets:           make(map[string]ynab.BudgetDetail),
+		extras:            make(map[string]budgetExtras),
 		categories:        make(map[string]map[string]category),
+		serverKnowledge:   make(map[string]int64),
+		sourceStates:      make(map[string]string),
+		opts:              opts,
 	}, nil
 }
 
+func (importer *ImportYNABRunner) budget(id string) ynab.BudgetDetail {
+	importer.mu.RLock()
+	defer importer.mu.RUnlock()
+	return importer.budgets[id]
+}
+
+func (importer *ImportYNABRunner) budgetExtras(id string) budgetExtras {
+	importer.mu.RLock()
+	defer importer.mu.RUnlock()
+	return importer.extras[id]
+}
+
+func (importer *ImportYNABRunner) budgetCategories(budgetID string) map[string]category {
+	importer.mu.RLock()
+	defer importer.mu.RUnlock()
+	return importer.categories[budgetID]
+}
+
+func (importer *ImportYNABRunner) fetchBudget(b config.Budget) error {
+	knowledgeBefore, err := importer.lastServerKnowledge(budgetEndpoint(b.ID))
+	if err != nil {
+		return fmt.Errorf("Failed to get last server knowledge: %w", err)
+	}
+
+	budgetDetail, extras, knowledge, err := importer.getBudget(b.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to get budget details for %s", err)
+	}
+
+	importer.run.SetServerKnowledge(b.ID, knowledgeBefore, knowledge)
+
+	categoryGroupIDToName := make(map[string]string)
+	for _, g := range budgetDetail.CategoryGroups {
+		categoryGroupIDToName[g.Id] = g.Name
+	}
+
+	categories := make(map[string]category)
+	for _, c := range budgetDetail.Categories {
+		categories[c.Id] = category{
+			Id:      c.Id,
+			Name:    c.Name,
+			Group:   categoryGroupIDToName[c.CategoryGroupId],
+			GroupID: c.CategoryGroupId,
+		}
+	}
+
+	importer.mu.Lock()
+	defer importer.mu.Unlock()
+	importer.budgets[b.ID] = budgetDetail
+	importer.extras[b.ID] = extras
+	importer.categories[b.ID] = categories
+	importer.serverKnowledge[b.ID] = knowledge
+
+	return nil
+}
+
 func (importer *ImportYNABRunner) importYNAB() error {
-	err := importer.detectBudgetIDs(config.CurrentYnabConfig())
+	err := validateAccountsStorage(config.CurrentYnabConfig())
 	if err != nil {
-		return fmt.Errorf("Error detecting budget IDs: %s", err)
+		return err
 	}
 
-	for _, b := range config.CurrentYnabConfig().Budgets {
-		importer.budgets[b.ID], err = importer.ynabClient.BudgetService.Get(b.ID)
+	if !importer.journalOnly {
+		err = importer.detectBudgetIDs(config.CurrentYnabConfig())
 		if err != nil {
-			return fmt.Errorf("Failed to get budget details for %s", err)
+			return fmt.Errorf("Error detecting budget IDs: %s", err)
 		}
+	}
 
-		categoryGroupIDToName := make(map[string]string)
-		for _, g := range importer.budgets[b.ID].CategoryGroups {
-			categoryGroupIDToName[g.Id] = g.Name
-		}
+	_, err = importer.db.NewCreateTable().Model(&LastSeen{}).IfNotExists().Exec(context.Background())
+	if err != nil {
+		return err
+	}
 
-		importer.categories[b.Name] = make(map[string]category)
+	if importer.opts.SQLitePath != "" {
+		return importer.importYNABToSQLite()
+	}
 
-		for _, c := range importer.budgets[b.ID].Categories {
-			importer.categories[b.Name][c.Id] = category{
-				Id:    c.Id,
-				Name:  c.Name,
-				Group: categoryGroupIDToName[c.CategoryGroupId],
-			}
+	stage := importer.stageYNAB
+	if importer.journalOnly {
+		stage = importer.stageJournals
+	} else {
+		err = importer.migrateStableKeys()
+		if err != nil {
+			return err
 		}
 	}
 
+	importer.tables = postgresutils.NewShadowTables(importer.db)
+
+	err = importer.openSinks(sinks.TypePostgres)
 	if err != nil {
 		return err
 	}
+	defer importer.closeSinks()
 
-	_, err = importer.db.NewCreateTable().Model(&LastSeen{}).IfNotExists().Exec(context.Background())
+	err = stage()
+	if err != nil {
+		if discardErr := importer.tables.Discard(); discardErr != nil {
+			slog.Warn("failed to clean up shadow tables", "error", discardErr)
+		}
+		return err
+	}
+
+	importer.recordTableCounts()
+
+	// the shadow tables are locked during the swap, get the tables before
+	tables := importer.tables.Tables()
+	beforeSwap := func(ctx context.Context, tx bun.Tx) error {
+		return dropViews(ctx, tx, tables)
+	}
+	err = importer.tables.Swap(context.Background(), beforeSwap, func(ctx context.Context, tx bun.Tx) error {
+		if err := createViews(ctx, tx, tables); err != nil {
+			return err
+		}
+
+		historyCount, err := importer.history.Write(ctx, tx, transactionsHistoryTable(), batchSize())
+		if err != nil {
+			return err
+		}
+		importer.run.AddTableCounts(transactionsHistoryTable(), historyCount, 0, 0)
+
+		// only remember the server knowledge once the data it describes is in place
+		return importer.saveRunState(ctx, tx)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to swap in imported tables: %w", err)
+	}
+
+	return nil
+}
+
+// recordTableCounts adds what the swap is going to change to the run
+func (importer *ImportYNABRunner) recordTableCounts() {
+	for _, tableName := range importer.tables.Tables() {
+		keyColumn := "key"
+		if tableName == config.CurrentYnabConfig().SQL.NetworthTable {
+			keyColumn = "date"
+		}
+
+		inserted, updated, deleted, err := importer.tables.Diff(tableName, keyColumn)
+		if err != nil {
+			slog.Warn("failed to count changes", "table", tableName, "error", err)
+			continue
+		}
+
+		importer.run.AddTableCounts(tableName, inserted, updated, deleted)
+	}
+}
+
+// stageYNAB writes the full import into the shadow tables
+func (importer *ImportYNABRunner) stageYNAB() error {
+	err := importer.Migrate()
 	if err != nil {
 		return err
 	}
 
-	err = importer.Migrate()
+	err = importer.loadTransactionHistory()
 	if err != nil {
 		return err
 	}
 
-	fimporter := financialimporter.NewTransactionImporter(importer.db, importer.currencyConverter, nil, nil, "", nil, time.Now(), config.CurrentYnabConfig().SQL.TransactionsTable)
+	fimporter := financialimporter.NewTransactionImporter(importer.sinks[transactionsDataset], importer.currencyConverter, nil, nil, "", nil, time.Now(), config.CurrentYnabConfig().SQL.TransactionsTable, nil)
 	err = fimporter.Migrate()
 	if err != nil {
 		return err
 	}
 
+	var sqlAccountsMu sync.Mutex
 	sqlAccounts := []SQLAccount{}
+	sqlScheduled := []SQLScheduledTransaction{}
+
+	err = forEachBudget(importer.ynabBudgets(), config.CurrentYnabConfig().Concurrency, func(b config.Budget) error {
+		err := importer.fetchBudget(b)
+		if err != nil {
+			return err
+		}
 
-	for _, b := range config.CurrentYnabConfig().Budgets {
 		err = importer.importTransactions(b, config.CurrentYnabConfig().Currencies)
 		if err != nil {
 			return err
 		}
 
+		err = importer.importDimensions(b)
+		if err != nil {
+			return err
+		}
+
 		currentSqlAccounts, err := importer.importAccounts(b, config.CurrentYnabConfig().Currencies)
 		if err != nil {
 			return err
 		}
+
+		currentSqlScheduled, err := importer.importScheduledTransactions(b)
+		if err != nil {
+			return err
+		}
+
+		sqlAccountsMu.Lock()
 		sqlAccounts = append(sqlAccounts, currentSqlAccounts...)
+		sqlScheduled = append(sqlScheduled, currentSqlScheduled...)
+		sqlAccountsMu.Unlock()
+
+		sqlBudgets, err := importer.importBudgets(b, config.CurrentYnabConfig().Currencies)
+		if err != nil {
+			return err
+		}
 
-		err = importer.importBudgets(b, config.CurrentYnabConfig().Currencies)
+		err = importer.importBudgetSnapshots(sqlBudgets)
 		if err != nil {
 			return err
 		}
+
+		return importer.importGoalProgress(b)
+	})
+	if err != nil {
+		return err
+	}
+
+	fileAccounts, err := importer.importFiles(config.CurrentYnabConfig().Currencies)
+	if err != nil {
+		return err
+	}
+
+	// transactions missing from every budget were deleted
+	importer.history.RecordDeletions()
+
+	// net worth, loans and the forecast need the accounts from every budget, journal and export accounts only count in net worth
+	err = importer.importNetworth(append(slices.Clone(sqlAccounts), fileAccounts...))
+	if err != nil {
+		return err
+	}
+
+	err = importer.importLoans(sqlAccounts)
+	if err != nil {
+		return err
+	}
+
+	err = importer.importCashFlowForecast(sqlAccounts, sqlScheduled)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// loadTransactionHistory loads the transactions of the previous run to record what changed. The previous transactions
+// are only in the transactions table of the run's database when the transactions are written there, with other sinks
+// there is no history like in sqlite mode
+func (importer *ImportYNABRunner) loadTransactionHistory() error {
+	importer.history = nil
+	if !sinks.WritesTo(importer.sinks[transactionsDataset], importer.db) {
+		slog.Info("transactions aren't written to postgres, skipping transaction history")
+		return nil
 	}
 
-	err = importer.importNetworth(sqlAccounts)
+	historyTable := transactionsHistoryTable()
+	err := financialimporter.MigrateTransactionHistory(importer.db, historyTable)
 	if err != nil {
 		return err
 	}
 
+	importer.history, err = financialimporter.LoadTransactionHistory(importer.db, config.CurrentYnabConfig().SQL.TransactionsTable, historyTable)
+	return err
+}
+
+func transactionsHistoryTable() string {
+	return tableOrDefault(config.CurrentYnabConfig().SQL.TransactionsHistoryTable, "transactions_history")
+}
+
+func batchSize() int {
+	if config.CurrentYnabConfig().SQL.BatchSize == 0 {
+		return 1000
+	}
+	return config.CurrentYnabConfig().SQL.BatchSize
+}
+
+// table returns the shadow table the current run writes to in place of tableName
+func (importer *ImportYNABRunner) table(tableName string) string {
+	return importer.tables.Name(tableName)
+}
+
+// dropViews drops the views on the tables that are about to be replaced, views on tables the run doesn't replace are kept
+func dropViews(ctx context.Context, tx bun.Tx, tables []string) error {
+	sqlConfig := config.CurrentYnabConfig().SQL
+
+	views := map[string]string{
+		sqlConfig.AccountsTable: dailyViewName(sqlConfig.AccountsTable),
+		sqlConfig.NetworthTable: dailyViewName(sqlConfig.NetworthTable),
+		goalProgressTable():     goalsAtRiskViewName(goalProgressTable()),
+	}
+	for table, view := range views {
+		if !slices.Contains(tables, table) {
+			continue
+		}
+
+		_, err := tx.ExecContext(ctx, "DROP VIEW IF EXISTS ?", bun.Ident(view))
+		if err != nil {
+			return fmt.Errorf("failed to drop %s view: %w", view, err)
+		}
+	}
+
+	return nil
+}
+
+// createViews recreates the views on the swapped in tables. Tables written to other sinks aren't in postgres and
+// get no views
+func createViews(ctx context.Context, tx bun.Tx, tables []string) error {
+	sqlConfig := config.CurrentYnabConfig().SQL
+
+	if slices.Contains(tables, sqlConfig.AccountsTable) {
+		err := createDailyView(ctx, tx, sqlConfig.AccountsTable, []string{"budget_id", "account_id"}, []string{"budget_id", "account_id", "name", "currency", "budget_name", "on_budget", "type", "balance", "usd", "cad"})
+		if err != nil {
+			return fmt.Errorf("failed to create %s view: %w", dailyViewName(sqlConfig.AccountsTable), err)
+		}
+	}
+
+	if slices.Contains(tables, sqlConfig.NetworthTable) {
+		err := createDailyView(ctx, tx, sqlConfig.NetworthTable, nil, []string{"usd", "cad", "budget_breakdown"})
+		if err != nil {
+			return fmt.Errorf("failed to create %s view: %w", dailyViewName(sqlConfig.NetworthTable), err)
+		}
+	}
+
+	if slices.Contains(tables, goalProgressTable()) {
+		err := createGoalsAtRiskView(ctx, tx, goalProgressTable())
+		if err != nil {
+			return fmt.Errorf("failed to create %s view: %w", goalsAtRiskViewName(goalProgressTable()), err)
+		}
+	}
+
 	return nil
 }
 
+// forEachBudget runs fn for every budget with at most concurrency budgets at a time. Returns the first error, budgets
+// that haven't started yet are skipped once a budget fails
+func forEachBudget(budgets []config.Budget, concurrency int, fn func(config.Budget) error) error {
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	failed := make(chan struct{})
+	sem := make(chan struct{}, concurrency)
+
+	for _, b := range budgets {
+		select {
+		case <-failed:
+		case sem <- struct{}{}:
+		}
+
+		select {
+		case <-failed:
+			wg.Wait()
+			return firstErr
+		default:
+		}
+
+		wg.Add(1)
+		go func(b config.Budget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fn(b); err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("budget %s: %w", b.Name, err)
+					close(failed)
+				})
+			}
+		}(b)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
 func (importer *ImportYNABRunner) detectBudgetIDs(conf *config.YnabConfig) error {
 	budgets, err := importer.ynabClient.BudgetService.List()
 	if err != nil {
@@ -203,5 +568,30 @@ func (importer *ImportYNABRunner) Migrate() error {
 		return err
 	}
 
+	err = importer.migrateLoans()
+	if err != nil {
+		return err
+	}
+
+	err = importer.migrateBudgetSnapshots()
+	if err != nil {
+		return err
+	}
+
+	err = importer.migrateScheduledTransactions()
+	if err != nil {
+		return err
+	}
+
+	err = importer.migrateDimensions()
+	if err != nil {
+		return err
+	}
+
+	err = importer.migrateGoalProgress()
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,80 @@
+package ynabimporter
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bcaldwell/selfops/pkg/config"
+	"github.com/bcaldwell/selfops/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEachBudgetConcurrency(t *testing.T) {
+	budgets := []config.Budget{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}, {Name: "e"}}
+
+	var mu sync.Mutex
+	seen := []string{}
+	var running, maxRunning int32
+
+	err := forEachBudget(budgets, 2, func(b config.Budget) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		seen = append(seen, b.Name)
+		mu.Unlock()
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d", "e"}, seen)
+	assert.LessOrEqual(t, maxRunning, int32(2))
+}
+
+func TestForEachBudgetStopsOnError(t *testing.T) {
+	budgets := []config.Budget{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}
+	failure := errors.New("failed")
+
+	var started int32
+	err := forEachBudget(budgets, 1, func(b config.Budget) error {
+		atomic.AddInt32(&started, 1)
+		if b.Name == "b" {
+			return failure
+		}
+		return nil
+	})
+
+	assert.ErrorIs(t, err, failure)
+	assert.ErrorContains(t, err, "budget b")
+	assert.Equal(t, int32(2), started)
+}
+
+func TestLoadTransactionHistorySkipsOtherSinks(t *testing.T) {
+	db, err := sinks.OpenSQLite(filepath.Join(t.TempDir(), "selfops.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// transactions routed away from the database of the run have no previous transactions there
+	importer := &ImportYNABRunner{db: db, sinks: map[string]sinks.Sink{transactionsDataset: sinks.NewSQLiteSink(db, 0)}}
+	require.NoError(t, importer.loadTransactionHistory())
+	assert.Nil(t, importer.history)
+
+	importer.history.RecordDeletions()
+	written, err := importer.history.Write(context.Background(), db, "transactions_history", 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, written)
+}
@@ -10,8 +10,11 @@ import (
 
 type YnabTransaction struct {
 	*ynab.TransactionDetail
+	BudgetId      string
 	Regex         *regexp.Regexp
 	CategoryIDMap map[string]category
+	// account id to name, for the other side of transfers
+	AccountNames map[string]string
 }
 
 func (t *YnabTransaction) Date() string {
@@ -83,6 +86,26 @@ func (t *YnabTransaction) IndexKey() string {
 	return t.Id
 }
 
+func (t *YnabTransaction) TransferAccount() string {
+	return transferAccountName(t.TransferAccountId, t.AccountNames, t.PayeeName)
+}
+
+func (t *YnabTransaction) BudgetID() string {
+	return t.BudgetId
+}
+
+func (t *YnabTransaction) AccountID() string {
+	return t.AccountId
+}
+
+func (t *YnabTransaction) CategoryID() string {
+	return stringValue(t.CategoryId)
+}
+
+func (t *YnabTransaction) PayeeID() string {
+	return stringValue(t.PayeeId)
+}
+
 type YnabSubTransaction struct {
 	*ynab.SubTransaction
 	Parent *YnabTransaction
@@ -155,6 +178,40 @@ func (t *YnabSubTransaction) IndexKey() string {
 	return t.Id
 }
 
+func (t *YnabSubTransaction) TransferAccount() string {
+	return transferAccountName(t.TransferAccountId, t.Parent.AccountNames, "")
+}
+
+func (t *YnabSubTransaction) BudgetID() string {
+	return t.Parent.BudgetId
+}
+
+func (t *YnabSubTransaction) AccountID() string {
+	return t.Parent.AccountId
+}
+
+func (t *YnabSubTransaction) CategoryID() string {
+	return stringValue(t.CategoryId)
+}
+
+func (t *YnabSubTransaction) PayeeID() string {
+	if t.PayeeId == nil {
+		return t.Parent.PayeeID()
+	}
+	return *t.PayeeId
+}
+
+// transferAccountName looks up the account by id, falling back to the "Transfer : <account>" payee ynab gives transfers
+func transferAccountName(accountID *string, accountNames map[string]string, payee string) string {
+	if accountID == nil {
+		return ""
+	}
+	if name, ok := accountNames[*accountID]; ok {
+		return name
+	}
+	return strings.TrimPrefix(payee, "Transfer : ")
+}
+
 func tagsList(regex *regexp.Regexp, memo string) []string {
 	var tags []string
 	parts := strings.Split(memo, ",")